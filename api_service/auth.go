@@ -0,0 +1,360 @@
+package main
+
+import (
+	"bytes"
+	"common"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log/slog"
+	metapb "meta/proto"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// requests are signed as in AWS Signature Version 4, so existing S3 clients (aws cli, curl --aws-sigv4)
+// can be used with service "s3" and any region
+const (
+	sigV4Algorithm          = "AWS4-HMAC-SHA256"
+	sigV4Service            = "s3"
+	sigV4Terminator         = "aws4_request"
+	amzDateHeader           = "X-Amz-Date"
+	amzContentSHA256Header  = "X-Amz-Content-Sha256"
+	amzDateFormat           = "20060102T150405Z"
+	unsignedPayload         = "UNSIGNED-PAYLOAD"
+	streamingPayloadPrefix  = "STREAMING-"
+	maxRequestClockSkew     = 15 * time.Minute
+	credentialScopeDateSize = len("20060102")
+	// bodies without X-Amz-Content-Sha256 header are read into memory to hash them, so their size is limited
+	maxHashedBodySize = 1 << 20
+)
+
+type contextKey int
+
+const (
+	identityContextKey contextKey = iota
+	accessKeyContextKey
+)
+
+// requestIdentity returns who signed the request, user is empty for anonymous requests
+// and if authentication is disabled
+func requestIdentity(req *http.Request) common.Identity {
+	identity, _ := req.Context().Value(identityContextKey).(common.Identity)
+	return identity
+}
+
+func requestUser(req *http.Request) string {
+	return requestIdentity(req).User
+}
+
+// requestAccessKey returns id of the access key which the request is signed with
+func requestAccessKey(req *http.Request) string {
+	access_key_id, _ := req.Context().Value(accessKeyContextKey).(string)
+	return access_key_id
+}
+
+type sigV4Credential struct {
+	access_key_id string
+	date          string
+	region        string
+	service       string
+}
+
+func (c sigV4Credential) scope() string {
+	return c.date + "/" + c.region + "/" + c.service + "/" + sigV4Terminator
+}
+
+func parseCredential(credential string) (sigV4Credential, error) {
+	parts := strings.Split(credential, "/")
+	if len(parts) != 5 || parts[4] != sigV4Terminator {
+		return sigV4Credential{}, fmt.Errorf("credential must look like <access key id>/<date>/<region>/%s/%s", sigV4Service, sigV4Terminator)
+	}
+	if parts[3] != sigV4Service {
+		return sigV4Credential{}, fmt.Errorf("credential must be scoped to service %s, got %s", sigV4Service, parts[3])
+	}
+	return sigV4Credential{access_key_id: parts[0], date: parts[1], region: parts[2], service: parts[3]}, nil
+}
+
+// parseAuthorization parses header like
+// AWS4-HMAC-SHA256 Credential=<id>/<date>/<region>/s3/aws4_request, SignedHeaders=host;x-amz-date, Signature=<hex>
+func parseAuthorization(header string) (sigV4Credential, []string, string, error) {
+	params, found := strings.CutPrefix(header, sigV4Algorithm+" ")
+	if !found {
+		return sigV4Credential{}, nil, "", fmt.Errorf("only %s signatures are supported", sigV4Algorithm)
+	}
+
+	values := make(map[string]string)
+	for _, param := range strings.Split(params, ",") {
+		key, value, found := strings.Cut(strings.TrimSpace(param), "=")
+		if found {
+			values[key] = value
+		}
+	}
+	if values["Credential"] == "" || values["SignedHeaders"] == "" || values["Signature"] == "" {
+		return sigV4Credential{}, nil, "", errors.New("Credential, SignedHeaders and Signature are required in Authorization header")
+	}
+
+	credential, err := parseCredential(values["Credential"])
+	if err != nil {
+		return sigV4Credential{}, nil, "", err
+	}
+	return credential, strings.Split(values["SignedHeaders"], ";"), values["Signature"], nil
+}
+
+// uriEncode encodes everything except unreserved symbols, as required by SigV4
+func uriEncode(value string, encode_slash bool) string {
+	var encoded strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (c == '/' && !encode_slash) {
+			encoded.WriteByte(c)
+		} else {
+			fmt.Fprintf(&encoded, "%%%02X", c)
+		}
+	}
+	return encoded.String()
+}
+
+func canonicalQuery(query url.Values) string {
+	params := make([]string, 0, len(query))
+	for key, values := range query {
+		for _, value := range values {
+			params = append(params, uriEncode(key, true)+"="+uriEncode(value, true))
+		}
+	}
+	sort.Strings(params)
+	return strings.Join(params, "&")
+}
+
+func canonicalHeaderValue(req *http.Request, name string) string {
+	switch name {
+	case "host":
+		return req.Host
+	case "content-length":
+		if req.Header.Get("Content-Length") == "" && req.ContentLength >= 0 {
+			return strconv.FormatInt(req.ContentLength, 10)
+		}
+	}
+
+	values := make([]string, 0, len(req.Header.Values(name)))
+	for _, value := range req.Header.Values(name) {
+		values = append(values, strings.Join(strings.Fields(value), " "))
+	}
+	return strings.Join(values, ",")
+}
+
+// stringToSign builds canonical request from the request and hashes it into the string which is signed
+func stringToSign(req *http.Request, credential sigV4Credential, signed_headers []string, amz_date, canonical_query, payload_hash string) string {
+	var canonical_headers strings.Builder
+	for _, name := range signed_headers {
+		canonical_headers.WriteString(name + ":" + canonicalHeaderValue(req, name) + "\n")
+	}
+
+	canonical_request := strings.Join([]string{
+		req.Method,
+		uriEncode(req.URL.Path, false),
+		canonical_query,
+		canonical_headers.String(),
+		strings.Join(signed_headers, ";"),
+		payload_hash,
+	}, "\n")
+	canonical_request_hash := sha256.Sum256([]byte(canonical_request))
+
+	return strings.Join([]string{sigV4Algorithm, amz_date, credential.scope(), hex.EncodeToString(canonical_request_hash[:])}, "\n")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func computeSignature(secret_access_key string, credential sigV4Credential, string_to_sign string) string {
+	key := hmacSHA256([]byte("AWS4"+secret_access_key), credential.date)
+	key = hmacSHA256(key, credential.region)
+	key = hmacSHA256(key, credential.service)
+	key = hmacSHA256(key, sigV4Terminator)
+	return hex.EncodeToString(hmacSHA256(key, string_to_sign))
+}
+
+// checkRequestTime protects from replaying of old signed requests, request is valid for valid_for after it is signed
+func checkRequestTime(amz_date string, credential sigV4Credential, valid_for time.Duration) (int, error) {
+	signed_at, err := time.Parse(amzDateFormat, amz_date)
+	if err != nil {
+		return http.StatusBadRequest, fmt.Errorf("time of request must be passed in %s format, got %q", amzDateFormat, amz_date)
+	}
+	if amz_date[:credentialScopeDateSize] != credential.date {
+		return http.StatusBadRequest, errors.New("date of credential does not match time of request")
+	}
+	if skew := time.Since(signed_at); skew > valid_for || skew < -maxRequestClockSkew {
+		return http.StatusForbidden, errors.New("request is signed too long ago or in the future")
+	}
+	return 0, nil
+}
+
+// payloadVerifier checks hash of the body which was signed, mismatch is reported instead of io.EOF,
+// so handlers discard everything they have written for the request
+type payloadVerifier struct {
+	body     io.ReadCloser
+	hash     hash.Hash
+	expected string
+}
+
+func (v *payloadVerifier) Read(p []byte) (int, error) {
+	n, err := v.body.Read(p)
+	v.hash.Write(p[:n])
+	if err == io.EOF && hex.EncodeToString(v.hash.Sum(nil)) != v.expected {
+		return n, fmt.Errorf("body of request does not match %s header", amzContentSHA256Header)
+	}
+	return n, err
+}
+
+func (v *payloadVerifier) Close() error {
+	return v.body.Close()
+}
+
+// signedPayloadHash returns hash of the body which is a part of the signature. Clients which don't pass it
+// in X-Amz-Content-Sha256 header (e.g. older curl) sign the hash of the whole body, so the body is read
+// into memory in this case, only if its Content-Length is small. On error http status is returned too
+func signedPayloadHash(req *http.Request) (string, int, error) {
+	payload_hash := req.Header.Get(amzContentSHA256Header)
+	if strings.HasPrefix(payload_hash, streamingPayloadPrefix) {
+		return "", http.StatusNotImplemented, errors.New("chunked signing of body is not supported, sign the whole body or pass " + unsignedPayload)
+	}
+	if payload_hash != "" {
+		return payload_hash, 0, nil
+	}
+
+	if req.ContentLength < 0 {
+		return "", http.StatusLengthRequired, fmt.Errorf("body of unknown length must be passed with %s header", amzContentSHA256Header)
+	}
+	if req.ContentLength > maxHashedBodySize {
+		return "", http.StatusBadRequest, fmt.Errorf("body larger than %d bytes must be passed with %s header", maxHashedBodySize, amzContentSHA256Header)
+	}
+	body, err := io.ReadAll(io.LimitReader(req.Body, req.ContentLength))
+	if err != nil {
+		return "", http.StatusBadRequest, fmt.Errorf("failed to read body of request: %v", err)
+	}
+	req.Body.Close()
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	body_hash := sha256.Sum256(body)
+	return hex.EncodeToString(body_hash[:]), 0, nil
+}
+
+// lookupSecret returns secret of active access key and identity of its user
+func (s *apiServer) lookupSecret(ctx context.Context, access_key_id string) (string, common.Identity, int, error) {
+	resp, err := s.grpc_client.GetAccessKey(ctx, &metapb.GetAccessKeyReq{AccessKeyId: access_key_id})
+	if err != nil {
+		switch status.Code(err) {
+		case codes.NotFound:
+			return "", common.Identity{}, http.StatusForbidden, fmt.Errorf("access key %s does not exist", access_key_id)
+		default:
+			error_status, err := unexpectedError(ctx, "lookupSecret", err)
+			return "", common.Identity{}, error_status, err
+		}
+	}
+	if resp.Key.RevokedAt != 0 {
+		return "", common.Identity{}, http.StatusForbidden, fmt.Errorf("access key %s is revoked", access_key_id)
+	}
+	return resp.Key.SecretAccessKey, common.Identity{User: resp.Key.User, Groups: resp.Key.Groups}, 0, nil
+}
+
+// verifySignature returns identity of the user who signed the request and id of the access key it is signed with,
+// unsigned requests are anonymous. On error http status is returned too
+func (s *apiServer) verifySignature(req *http.Request) (common.Identity, string, int, error) {
+	authorization := req.Header.Get("Authorization")
+	if req.URL.Query().Has(amzSignatureParam) {
+		if authorization != "" {
+			return common.Identity{}, "", http.StatusBadRequest, errors.New("request must be signed either in Authorization header or in query, not both")
+		}
+		return s.verifyPresignedURL(req)
+	}
+	if authorization == "" {
+		return common.Identity{}, "", 0, nil
+	}
+	credential, signed_headers, signature, err := parseAuthorization(authorization)
+	if err != nil {
+		return common.Identity{}, "", http.StatusBadRequest, err
+	}
+	if !sort.StringsAreSorted(signed_headers) || !containsString(signed_headers, "host") {
+		return common.Identity{}, "", http.StatusBadRequest, errors.New("SignedHeaders must be sorted and include host")
+	}
+
+	amz_date := req.Header.Get(amzDateHeader)
+	error_status, err := checkRequestTime(amz_date, credential, maxRequestClockSkew)
+	if err != nil {
+		return common.Identity{}, "", error_status, err
+	}
+
+	// the key is checked before the body is read to hash it
+	secret_access_key, identity, error_status, err := s.lookupSecret(req.Context(), credential.access_key_id)
+	if err != nil {
+		return common.Identity{}, "", error_status, err
+	}
+
+	payload_hash, error_status, err := signedPayloadHash(req)
+	if err != nil {
+		return common.Identity{}, "", error_status, err
+	}
+	// curl before 8.0 signs query string as is instead of sorted and encoded one, so both are accepted
+	signature_matches := false
+	for _, canonical_query := range []string{canonicalQuery(req.URL.Query()), req.URL.RawQuery} {
+		string_to_sign := stringToSign(req, credential, signed_headers, amz_date, canonical_query, payload_hash)
+		if hmac.Equal([]byte(computeSignature(secret_access_key, credential, string_to_sign)), []byte(signature)) {
+			signature_matches = true
+			break
+		}
+	}
+	if !signature_matches {
+		return common.Identity{}, "", http.StatusForbidden, errors.New("signature of request does not match")
+	}
+
+	if payload_hash != unsignedPayload && req.Header.Get(amzContentSHA256Header) != "" {
+		req.Body = &payloadVerifier{body: req.Body, hash: sha256.New(), expected: payload_hash}
+	}
+	return identity, credential.access_key_id, 0, nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, cur := range values {
+		if cur == value {
+			return true
+		}
+	}
+	return false
+}
+
+// authenticate is a middleware which rejects requests with invalid signatures if auth is enabled in config.
+// Whether the identity of the request may do what it asks is checked by policies of buckets
+func (s *apiServer) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !s.config.Auth {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		identity, access_key_id, error_status, err := s.verifySignature(req)
+		if err != nil {
+			w.WriteHeader(error_status)
+			fmt.Fprintf(w, "Access denied: %v\n", err)
+			slog.WarnContext(req.Context(), "Rejected request", "method", req.Method, "path", req.URL.Path, "error", err)
+			return
+		}
+		ctx := context.WithValue(req.Context(), identityContextKey, identity)
+		ctx = context.WithValue(ctx, accessKeyContextKey, access_key_id)
+		next.ServeHTTP(w, req.WithContext(ctx))
+	})
+}
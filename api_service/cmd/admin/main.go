@@ -0,0 +1,233 @@
+// admin is a command line tool for maintenance of the storage. It must be run from the directory
+// of api service (or inside its container), so it reads the same config.json and keyfile.
+//
+//	go run ./cmd/admin rotate-key                                     - add new master key, make it current and rewrap all data keys with it
+//	go run ./cmd/admin rewrap                                         - rewrap data keys which are still wrapped with old master keys
+//	go run ./cmd/admin create-user <user>                             - create user which access keys can be issued for
+//	go run ./cmd/admin create-key <user>                              - issue access key for the user, its secret is printed only once
+//	go run ./cmd/admin revoke-key <access key>                        - revoke access key, requests signed with it are rejected at once
+//	go run ./cmd/admin list-keys [user]                               - list access keys of the user or of all users
+//	go run ./cmd/admin add-to-group <group> <user>                    - add user to group, members of group admins may do everything
+//	go run ./cmd/admin remove-from-group <group> <user>               - remove user from group
+//	go run ./cmd/admin set-quota bucket|user <name> <bytes> <objects> - limit bucket or all buckets of user, 0 is unlimited
+package main
+
+import (
+	"common"
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	metapb "meta/proto"
+	"os"
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+const rewrapBatchSize = 1000
+
+// number of arguments of every command
+var commands = map[string][]int{
+	"rotate-key":        {0},
+	"rewrap":            {0},
+	"create-user":       {1},
+	"create-key":        {1},
+	"revoke-key":        {1},
+	"list-keys":         {0, 1},
+	"add-to-group":      {2},
+	"remove-from-group": {2},
+	"set-quota":         {4},
+}
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] rotate-key|rewrap|create-user|create-key|revoke-key|list-keys|add-to-group|remove-from-group|set-quota [args]\n", os.Args[0])
+	flag.PrintDefaults()
+}
+
+func validArgs(command string, args int) bool {
+	for _, expected := range commands[command] {
+		if expected == args {
+			return true
+		}
+	}
+	return false
+}
+
+// rewrapKeys rewraps all data keys of encrypted files with the current master key. Files which are
+// uploaded meanwhile already get keys wrapped with the current master key, so one pass is enough
+func rewrapKeys(client metapb.ApiWithMetaServiceClient, key_manager common.KeyManager) (int, error) {
+	current, err := key_manager.CurrentKeyID()
+	if err != nil {
+		return 0, err
+	}
+
+	rewrapped := 0
+	var after_id int64
+	for {
+		resp, err := client.ListWrappedKeys(context.Background(), &metapb.ListWrappedKeysReq{ExcludeKeyId: current, AfterId: after_id, Limit: rewrapBatchSize})
+		if err != nil {
+			return rewrapped, err
+		}
+		if len(resp.Keys) == 0 {
+			return rewrapped, nil
+		}
+
+		for _, key := range resp.Keys {
+			after_id = key.Id
+			data_key, err := key_manager.UnwrapKey(key.KeyId, key.WrappedKey)
+			if err != nil {
+				return rewrapped, fmt.Errorf("failed to unwrap data key of file %s in bucket %s: %v", key.File, key.Bucket, err)
+			}
+			new_wrapped_key, err := key_manager.WrapKey(current, data_key)
+			if err != nil {
+				return rewrapped, err
+			}
+
+			rewrap_resp, err := client.RewrapKey(context.Background(), &metapb.RewrapKeyReq{
+				Id: key.Id, KeyId: key.KeyId, WrappedKey: key.WrappedKey, NewKeyId: current, NewWrappedKey: new_wrapped_key})
+			if err != nil {
+				return rewrapped, err
+			}
+			// file was deleted or replaced meanwhile, the new one has a fresh data key
+			if rewrap_resp.Rewrapped {
+				rewrapped++
+			}
+		}
+	}
+}
+
+func rotateKey(client metapb.ApiWithMetaServiceClient, config common.Config, rotate bool) {
+	if config.Encryption_keyfile == "" {
+		log.Fatalln("encryption_keyfile is not set in config")
+	}
+	if rotate {
+		key_id, err := common.RotateKeyfile(config.Encryption_keyfile)
+		if err != nil {
+			log.Fatalf("Failed to rotate master key: %v\n", err)
+		}
+		log.Printf("Master key %s is current now\n", key_id)
+	}
+
+	key_manager, err := common.NewLocalKeyManager(config.Encryption_keyfile)
+	if err != nil {
+		log.Fatalf("Failed to open keyfile: %v\n", err)
+	}
+	rewrapped, err := rewrapKeys(client, key_manager)
+	if err != nil {
+		log.Fatalf("Failed to rewrap data keys after rewrapping %d of them: %v\n", rewrapped, err)
+	}
+	log.Printf("Rewrapped %d data keys\n", rewrapped)
+}
+
+func listKeys(client metapb.ApiWithMetaServiceClient, user string) {
+	resp, err := client.ListAccessKeys(context.Background(), &metapb.ListAccessKeysReq{User: user})
+	if err != nil {
+		log.Fatalf("Failed to list access keys: %v\n", err)
+	}
+	for _, key := range resp.Keys {
+		state := "active"
+		if key.RevokedAt != 0 {
+			state = "revoked at " + time.Unix(key.RevokedAt, 0).UTC().Format(time.RFC3339)
+		}
+		fmt.Printf("%s\tuser: %s\tcreated at: %s\t%s\n", key.AccessKeyId, key.User, time.Unix(key.CreatedAt, 0).UTC().Format(time.RFC3339), state)
+	}
+}
+
+func setQuota(client metapb.ApiWithMetaServiceClient, kind, name, quota_bytes, quota_objects string) {
+	req := &metapb.SetQuotaReq{}
+	switch kind {
+	case "bucket":
+		req.Bucket = name
+	case "user":
+		req.User = name
+	default:
+		log.Fatalf("Quota can be set for bucket or user, got %q\n", kind)
+	}
+	var err error
+	req.QuotaBytes, err = strconv.ParseInt(quota_bytes, 10, 64)
+	if err != nil {
+		log.Fatalf("Invalid quota of bytes %q: %v\n", quota_bytes, err)
+	}
+	req.QuotaObjects, err = strconv.ParseInt(quota_objects, 10, 64)
+	if err != nil {
+		log.Fatalf("Invalid quota of objects %q: %v\n", quota_objects, err)
+	}
+
+	_, err = client.SetQuota(context.Background(), req)
+	if err != nil {
+		log.Fatalf("Failed to set quota: %v\n", err)
+	}
+	log.Printf("Set quota of %s %s to %d bytes and %d objects\n", kind, name, req.QuotaBytes, req.QuotaObjects)
+}
+
+func main() {
+	config := common.ReadConfig()
+	meta_addr := flag.String("meta", "dns:///meta_service:"+strconv.Itoa(config.Meta_port), "address of meta service")
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() == 0 || !validArgs(flag.Arg(0), flag.NArg()-1) {
+		usage()
+		os.Exit(2)
+	}
+	command := flag.Arg(0)
+
+	// meta service accepts only certificate of api service, so the tool uses it too
+	certs, err := common.NewCertReloader(config, common.ApiServiceName)
+	if err != nil {
+		log.Fatalf("Failed to load certificate: %v\n", err)
+	}
+	meta_credentials := insecure.NewCredentials()
+	if certs != nil {
+		meta_credentials = credentials.NewTLS(certs.ClientConfig())
+	}
+	conn, err := grpc.Dial(*meta_addr, grpc.WithTransportCredentials(meta_credentials))
+	if err != nil {
+		log.Fatalf("Failed to dial server: %v\n", err)
+	}
+	defer conn.Close()
+	client := metapb.NewApiWithMetaServiceClient(conn)
+
+	switch command {
+	case "rotate-key", "rewrap":
+		rotateKey(client, config, command == "rotate-key")
+	case "create-user":
+		_, err = client.CreateUser(context.Background(), &metapb.CreateUserReq{User: flag.Arg(1)})
+		if err != nil {
+			log.Fatalf("Failed to create user: %v\n", err)
+		}
+		log.Printf("Created user %s\n", flag.Arg(1))
+	case "create-key":
+		resp, err := client.CreateAccessKey(context.Background(), &metapb.CreateAccessKeyReq{User: flag.Arg(1)})
+		if err != nil {
+			log.Fatalf("Failed to create access key: %v\n", err)
+		}
+		// secret can't be shown again, so it goes to stdout and the rest goes to log
+		fmt.Printf("Access key id: %s\nSecret access key: %s\n", resp.Key.AccessKeyId, resp.Key.SecretAccessKey)
+	case "revoke-key":
+		_, err = client.RevokeAccessKey(context.Background(), &metapb.RevokeAccessKeyReq{AccessKeyId: flag.Arg(1)})
+		if err != nil {
+			log.Fatalf("Failed to revoke access key: %v\n", err)
+		}
+		log.Printf("Revoked access key %s\n", flag.Arg(1))
+	case "list-keys":
+		listKeys(client, flag.Arg(1))
+	case "add-to-group":
+		_, err = client.AddUserToGroup(context.Background(), &metapb.AddUserToGroupReq{GroupName: flag.Arg(1), User: flag.Arg(2)})
+		if err != nil {
+			log.Fatalf("Failed to add user to group: %v\n", err)
+		}
+		log.Printf("Added user %s to group %s\n", flag.Arg(2), flag.Arg(1))
+	case "remove-from-group":
+		_, err = client.RemoveUserFromGroup(context.Background(), &metapb.RemoveUserFromGroupReq{GroupName: flag.Arg(1), User: flag.Arg(2)})
+		if err != nil {
+			log.Fatalf("Failed to remove user from group: %v\n", err)
+		}
+		log.Printf("Removed user %s from group %s\n", flag.Arg(2), flag.Arg(1))
+	case "set-quota":
+		setQuota(client, flag.Arg(1), flag.Arg(2), flag.Arg(3), flag.Arg(4))
+	}
+}
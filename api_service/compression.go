@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"common"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"mime"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// compression of bucket is set on creation by X-Bucket-Setting-Compression header
+const (
+	compressionSetting = "compression"
+	compressionNone    = "none"
+)
+
+// encoder and decoder are safe for concurrent use by EncodeAll and DecodeAll
+var (
+	zstdEncoder, _ = zstd.NewWriter(nil)
+	zstdDecoder, _ = zstd.NewReader(nil)
+)
+
+// compressing these types is a waste of CPU, their data is already compressed
+var compressedContentTypes = map[string]bool{
+	"application/gzip":             true,
+	"application/x-gzip":           true,
+	"application/zstd":             true,
+	"application/zip":              true,
+	"application/x-7z-compressed":  true,
+	"application/x-rar-compressed": true,
+	"application/x-bzip2":          true,
+	"application/x-xz":             true,
+	"application/pdf":              true,
+}
+
+func isValidCompression(compression string) bool {
+	return compression == compressionNone || (compression != "" && common.IsKnownCodec(compression))
+}
+
+// chooseCodec returns codec for the uploaded file by compression of its bucket. Files which are
+// already compressed (by their content type or content encoding) are stored raw
+func chooseCodec(bucket_settings map[string]string, content_type, content_encoding string) string {
+	compression := bucket_settings[compressionSetting]
+	if compression == "" || compression == compressionNone {
+		return ""
+	}
+	if content_encoding != "" && content_encoding != "identity" {
+		return ""
+	}
+
+	media_type, _, err := mime.ParseMediaType(content_type)
+	if err == nil {
+		if compressedContentTypes[media_type] {
+			return ""
+		}
+		// images, audio and video are compressed by their formats, svg is the only text among them
+		if media_type != "image/svg+xml" && (strings.HasPrefix(media_type, "image/") ||
+			strings.HasPrefix(media_type, "audio/") || strings.HasPrefix(media_type, "video/")) {
+			return ""
+		}
+	}
+	return compression
+}
+
+// compressChunk compresses every chunk independently, so chunks can be decompressed one by one while reading
+func compressChunk(codec string, data []byte) ([]byte, error) {
+	switch codec {
+	case "":
+		return data, nil
+	case common.CodecZstd:
+		return zstdEncoder.EncodeAll(data, nil), nil
+	case common.CodecGzip:
+		var buf bytes.Buffer
+		writer := gzip.NewWriter(&buf)
+		_, err := writer.Write(data)
+		if err != nil {
+			return nil, err
+		}
+		err = writer.Close()
+		return buf.Bytes(), err
+	default:
+		return nil, fmt.Errorf("unknown codec %s", codec)
+	}
+}
+
+func decompressChunk(codec string, data []byte) ([]byte, error) {
+	switch codec {
+	case "":
+		return data, nil
+	case common.CodecZstd:
+		return zstdDecoder.DecodeAll(data, nil)
+	case common.CodecGzip:
+		reader, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+		return io.ReadAll(reader)
+	default:
+		return nil, fmt.Errorf("unknown codec %s", codec)
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"common"
+	metapb "meta/proto"
+	"net/http"
+	"time"
+)
+
+// checkReadPreconditions evaluates conditional headers of GET and HEAD requests in the order
+// defined by RFC 9110. It returns status which must be sent instead of the file, or 0 if the file must be sent
+func checkReadPreconditions(req *http.Request, resp *metapb.GetFileChunksResp) int {
+	modified_at := time.Unix(resp.CreatedAt, 0)
+
+	if if_match := req.Header.Get("If-Match"); if_match != "" {
+		if !common.ETagMatches(if_match, resp.Etag) {
+			return http.StatusPreconditionFailed
+		}
+	} else if if_unmodified_since, err := http.ParseTime(req.Header.Get("If-Unmodified-Since")); err == nil {
+		if modified_at.After(if_unmodified_since) {
+			return http.StatusPreconditionFailed
+		}
+	}
+
+	if if_none_match := req.Header.Get("If-None-Match"); if_none_match != "" {
+		if common.ETagMatches(if_none_match, resp.Etag) {
+			return http.StatusNotModified
+		}
+	} else if if_modified_since, err := http.ParseTime(req.Header.Get("If-Modified-Since")); err == nil {
+		if !modified_at.After(if_modified_since) {
+			return http.StatusNotModified
+		}
+	}
+
+	return 0
+}
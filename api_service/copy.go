@@ -0,0 +1,105 @@
+package main
+
+import (
+	"common"
+	"fmt"
+	"log/slog"
+	metapb "meta/proto"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	copySourceHeader = "X-Copy-Source"
+	moveSourceHeader = "X-Move-Source"
+)
+
+// parseSourceHeader parses value of X-Copy-Source or X-Move-Source header,
+// which looks like /bucket/file (like x-amz-copy-source, it may be URL-encoded)
+func parseSourceHeader(value string) (string, string, error) {
+	unescaped, err := url.PathUnescape(value)
+	if err != nil {
+		return "", "", err
+	}
+
+	bucket, file, found := strings.Cut(strings.TrimPrefix(unescaped, "/"), "/")
+	if !found || bucket == "" || file == "" {
+		return "", "", fmt.Errorf("source must look like /<bucket>/<file>, got %q", value)
+	}
+	return bucket, file, nil
+}
+
+// copyFile handles PUT with X-Copy-Source or X-Move-Source header. Chunks of the copy are created by shards
+// themselves, and moving the file only rewrites its metadata
+func (s *apiServer) copyFile(w http.ResponseWriter, req *http.Request) {
+	bucket := mux.Vars(req)["bucket"]
+	file := mux.Vars(req)["file"]
+
+	move := false
+	source := req.Header.Get(copySourceHeader)
+	if source == "" {
+		move = true
+		source = req.Header.Get(moveSourceHeader)
+	}
+
+	src_bucket, src_file, err := parseSourceHeader(source)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Invalid source of copy: %v\n", err)
+		return
+	}
+
+	// moved file disappears from the source, so it must be allowed to be deleted there too
+	src_actions := []string{common.ActionRead}
+	if move {
+		src_actions = append(src_actions, common.ActionDelete)
+	}
+	for _, action := range src_actions {
+		error_status, err := s.checkAccess(req, src_bucket, src_file, action)
+		if err != nil {
+			w.WriteHeader(error_status)
+			fmt.Fprintf(w, "Access denied to source of copy: %v\n", err)
+			return
+		}
+	}
+
+	_, err = s.grpc_client.CopyFile(req.Context(), &metapb.CopyFileReq{
+		SrcBucket: src_bucket,
+		SrcFile:   src_file,
+		DstBucket: bucket,
+		DstFile:   file,
+		Move:      move,
+	})
+	if err != nil {
+		switch status.Code(err) {
+		case codes.NotFound:
+			w.WriteHeader(http.StatusNotFound)
+		case codes.InvalidArgument:
+			w.WriteHeader(http.StatusBadRequest)
+		case codes.AlreadyExists:
+			w.WriteHeader(http.StatusPreconditionFailed)
+		case codes.FailedPrecondition:
+			w.WriteHeader(http.StatusConflict)
+		case codes.ResourceExhausted:
+			w.WriteHeader(http.StatusInsufficientStorage)
+		default:
+			writeUnexpectedCode(req.Context(), w, "copyFile", err)
+			return
+		}
+		fmt.Fprintf(w, "Received error: %v\n", err)
+		return
+	}
+
+	if move {
+		fmt.Fprintf(w, "Successfully moved file %s from bucket %s into file %s in bucket %s\n", src_file, src_bucket, file, bucket)
+		slog.InfoContext(req.Context(), "Move file", "src_bucket", src_bucket, "src_file", src_file, "bucket", bucket, "file", file)
+	} else {
+		fmt.Fprintf(w, "Successfully copied file %s from bucket %s into file %s in bucket %s\n", src_file, src_bucket, file, bucket)
+		slog.InfoContext(req.Context(), "Copy file", "src_bucket", src_bucket, "src_file", src_file, "bucket", bucket, "file", file)
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"common"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log/slog"
+	metapb "meta/proto"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/mux"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	maxKeysInDeleteRequest = 1000
+	// body with 1000 keys of reasonable length fits into this limit with a big margin
+	maxDeleteRequestSize = 4 << 20
+)
+
+// deleteObjectsRequest is compatible with body of S3 DeleteObjects request,
+// the same structure is accepted in JSON
+type deleteObjectsRequest struct {
+	XMLName xml.Name `xml:"Delete" json:"-"`
+	Quiet   bool     `xml:"Quiet" json:"quiet"`
+	Objects []struct {
+		Key string `xml:"Key" json:"key"`
+	} `xml:"Object" json:"objects"`
+}
+
+type deletedObject struct {
+	Key string `xml:"Key" json:"key"`
+}
+
+type deleteObjectError struct {
+	Key     string `xml:"Key" json:"key"`
+	Code    string `xml:"Code" json:"code"`
+	Message string `xml:"Message" json:"message"`
+}
+
+type deleteObjectsResult struct {
+	XMLName xml.Name            `xml:"DeleteResult" json:"-"`
+	Deleted []deletedObject     `xml:"Deleted" json:"deleted"`
+	Errors  []deleteObjectError `xml:"Error" json:"errors"`
+}
+
+func isXMLRequest(req *http.Request, body []byte) bool {
+	content_type := req.Header.Get("Content-Type")
+	if strings.Contains(content_type, "xml") {
+		return true
+	}
+	if strings.Contains(content_type, "json") {
+		return false
+	}
+	return strings.HasPrefix(strings.TrimSpace(string(body)), "<")
+}
+
+// deleteFiles removes up to 1000 files of the bucket in one request, like S3 DeleteObjects does.
+// Metadata of all files is removed in one transaction, chunks are removed from all shards in parallel
+func (s *apiServer) deleteFiles(w http.ResponseWriter, req *http.Request) {
+	bucket := mux.Vars(req)["bucket"]
+
+	body, err := io.ReadAll(io.LimitReader(req.Body, maxDeleteRequestSize+1))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Received unexpected error while reading data from request: %s\n", err)
+		return
+	}
+	if len(body) > maxDeleteRequestSize {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		fmt.Fprintf(w, "Body of request must not be larger than %d bytes\n", maxDeleteRequestSize)
+		return
+	}
+
+	is_xml := isXMLRequest(req, body)
+	var delete_req deleteObjectsRequest
+	if is_xml {
+		err = xml.Unmarshal(body, &delete_req)
+	} else {
+		err = json.Unmarshal(body, &delete_req)
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Malformed body of request: %v\n", err)
+		return
+	}
+	if len(delete_req.Objects) == 0 || len(delete_req.Objects) > maxKeysInDeleteRequest {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Request must contain from 1 to %d keys, got %d\n", maxKeysInDeleteRequest, len(delete_req.Objects))
+		return
+	}
+
+	// like in S3, files which are not allowed to be deleted are reported as errors, the rest is deleted
+	bucket_info, error_status, err := s.bucketForAccess(req.Context(), bucket)
+	if err != nil {
+		w.WriteHeader(error_status)
+		fmt.Fprintf(w, "Received error: %v\n", err)
+		return
+	}
+	denied := make([]deleteObjectError, 0)
+	req_to_meta := &metapb.DeleteFilesReq{Bucket: bucket, Files: make([]string, 0, len(delete_req.Objects))}
+	for _, object := range delete_req.Objects {
+		if bucket_info != nil {
+			if _, err := s.bucketAccess(req, bucket_info, object.Key, common.ActionDelete); err != nil {
+				denied = append(denied, deleteObjectError{Key: object.Key, Code: "AccessDenied", Message: err.Error()})
+				continue
+			}
+		}
+		req_to_meta.Files = append(req_to_meta.Files, object.Key)
+	}
+	if len(req_to_meta.Files) == 0 {
+		writeDeleteObjectsResult(w, is_xml, deleteObjectsResult{Deleted: make([]deletedObject, 0), Errors: denied})
+		slog.InfoContext(req.Context(), "Deleted files", "bucket", bucket, "deleted", 0, "denied", len(denied))
+		return
+	}
+
+	resp, err := s.grpc_client.DeleteFiles(req.Context(), req_to_meta)
+	if err != nil {
+		switch status.Code(err) {
+		case codes.NotFound:
+			w.WriteHeader(http.StatusNotFound)
+		case codes.InvalidArgument:
+			w.WriteHeader(http.StatusBadRequest)
+		default:
+			writeUnexpectedCode(req.Context(), w, "deleteFiles", err)
+			return
+		}
+		fmt.Fprintf(w, "Received error: %v\n", err)
+		return
+	}
+
+	chunk_errors := s.deleteChunksOfFiles(req.Context(), resp.Results)
+
+	result := deleteObjectsResult{Deleted: make([]deletedObject, 0), Errors: denied}
+	for _, file_result := range resp.Results {
+		if file_result.ErrorCode != "" {
+			result.Errors = append(result.Errors, deleteObjectError{Key: file_result.File, Code: file_result.ErrorCode, Message: file_result.ErrorMessage})
+		} else if err, failed := chunk_errors[file_result.File]; failed {
+			result.Errors = append(result.Errors, deleteObjectError{
+				Key:     file_result.File,
+				Code:    "InternalError",
+				Message: fmt.Sprintf("metadata of file is deleted, but some of its chunks were not removed from shards: %v", err),
+			})
+		} else if !delete_req.Quiet {
+			result.Deleted = append(result.Deleted, deletedObject{Key: file_result.File})
+		}
+	}
+
+	writeDeleteObjectsResult(w, is_xml, result)
+	slog.InfoContext(req.Context(), "Deleted files", "bucket", bucket, "deleted", len(resp.Results), "failed", len(result.Errors))
+}
+
+func writeDeleteObjectsResult(w http.ResponseWriter, is_xml bool, result deleteObjectsResult) {
+	if is_xml {
+		w.Header().Set("Content-Type", "application/xml")
+		io.WriteString(w, xml.Header)
+		xml.NewEncoder(w).Encode(result)
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	}
+}
+
+// deleteChunksOfFiles removes chunks of deleted files, every shard is processed by its own goroutine.
+// It returns the first error for each file which chunks were not removed
+func (s *apiServer) deleteChunksOfFiles(ctx context.Context, results []*metapb.DeleteFileResult) map[string]error {
+	type fileChunk struct {
+		file  string
+		chunk *metapb.ChunkFilenameWithShard
+	}
+
+	chunks_by_shard := make(map[string][]fileChunk)
+	for _, file_result := range results {
+		for _, chunk := range file_result.Chunks {
+			chunks_by_shard[chunk.Shard] = append(chunks_by_shard[chunk.Shard], fileChunk{file: file_result.File, chunk: chunk})
+		}
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	chunk_errors := make(map[string]error)
+
+	for _, shard_chunks := range chunks_by_shard {
+		wg.Add(1)
+		go func(shard_chunks []fileChunk) {
+			defer wg.Done()
+			for _, file_chunk := range shard_chunks {
+				err := s.deleteChunk(ctx, file_chunk.chunk)
+				if err != nil {
+					slog.ErrorContext(ctx, "Failed to delete chunk", "file", file_chunk.file, "chunk", file_chunk.chunk.Filename, "shard", file_chunk.chunk.Shard, "error", err)
+					mu.Lock()
+					if _, exists := chunk_errors[file_chunk.file]; !exists {
+						chunk_errors[file_chunk.file] = err
+					}
+					mu.Unlock()
+				}
+			}
+		}(shard_chunks)
+	}
+
+	wg.Wait()
+	return chunk_errors
+}
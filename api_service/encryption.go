@@ -0,0 +1,164 @@
+package main
+
+import (
+	"common"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	metapb "meta/proto"
+	"net/http"
+)
+
+const (
+	// like x-amz-server-side-encryption, the only supported value is AES256
+	sseHeader = "X-Server-Side-Encryption"
+	// like x-amz-server-side-encryption-customer-*, the key of the client is passed with every upload and read
+	sseCustomerAlgorithmHeader = "X-Server-Side-Encryption-Customer-Algorithm"
+	sseCustomerKeyHeader       = "X-Server-Side-Encryption-Customer-Key"
+	sseCustomerKeyMD5Header    = "X-Server-Side-Encryption-Customer-Key-MD5"
+
+	// encryption of bucket is set on creation by X-Bucket-Setting-Encryption header, files of the
+	// bucket are encrypted even without X-Server-Side-Encryption header
+	encryptionSetting = "encryption"
+	encryptionNone    = "none"
+)
+
+func isValidEncryption(encryption string) bool {
+	return encryption == common.EncryptionAES256 || encryption == encryptionNone
+}
+
+// readCustomerKey returns nil key if the client did not pass its key
+func readCustomerKey(header http.Header) ([]byte, string, error) {
+	algorithm := header.Get(sseCustomerAlgorithmHeader)
+	encoded_key := header.Get(sseCustomerKeyHeader)
+	if algorithm == "" && encoded_key == "" {
+		return nil, "", nil
+	}
+	if algorithm != common.EncryptionAES256 {
+		return nil, "", fmt.Errorf("%s must be %s, got %q", sseCustomerAlgorithmHeader, common.EncryptionAES256, algorithm)
+	}
+
+	key, err := base64.StdEncoding.DecodeString(encoded_key)
+	if err != nil || len(key) != common.DataKeySize {
+		return nil, "", fmt.Errorf("%s must be a base64-encoded %d-byte key", sseCustomerKeyHeader, common.DataKeySize)
+	}
+	key_hash := md5.Sum(key)
+	key_md5 := base64.StdEncoding.EncodeToString(key_hash[:])
+	if expected_md5 := header.Get(sseCustomerKeyMD5Header); expected_md5 != "" && expected_md5 != key_md5 {
+		return nil, "", fmt.Errorf("%s does not match the key", sseCustomerKeyMD5Header)
+	}
+	return key, key_md5, nil
+}
+
+// chooseEncryption fills encryption of the uploaded file and returns the key which its chunks are
+// encrypted with, nil key means that chunks are stored in plaintext. On error http status is returned too
+func (s *apiServer) chooseEncryption(header http.Header, bucket_settings map[string]string, req_to_meta *metapb.CreateFileReq) ([]byte, int, error) {
+	customer_key, customer_key_md5, err := readCustomerKey(header)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	if customer_key != nil {
+		if header.Get(sseHeader) != "" {
+			return nil, http.StatusBadRequest, fmt.Errorf("%s can't be used together with the key of the client", sseHeader)
+		}
+		req_to_meta.Encryption = common.EncryptionCustomer
+		req_to_meta.CustomerKeyMd5 = customer_key_md5
+		return customer_key, 0, nil
+	}
+
+	encryption := header.Get(sseHeader)
+	if encryption == "" && bucket_settings[encryptionSetting] == common.EncryptionAES256 {
+		encryption = common.EncryptionAES256
+	}
+	if encryption == "" {
+		return nil, 0, nil
+	}
+	if encryption != common.EncryptionAES256 {
+		return nil, http.StatusBadRequest, fmt.Errorf("%s must be %s, got %q", sseHeader, common.EncryptionAES256, encryption)
+	}
+	if s.key_manager == nil {
+		return nil, http.StatusNotImplemented, errors.New("encryption_keyfile is not set in config, so files can't be encrypted")
+	}
+
+	// every file gets its own data key, only the wrapped data key is stored in meta service
+	key_id, err := s.key_manager.CurrentKeyID()
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	data_key, err := common.NewDataKey()
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	wrapped_key, err := s.key_manager.WrapKey(key_id, data_key)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	req_to_meta.Encryption = common.EncryptionAES256
+	req_to_meta.KeyId = key_id
+	req_to_meta.WrappedKey = wrapped_key
+	return data_key, 0, nil
+}
+
+// fileDataKey returns the key which chunks of the read file are encrypted with. On error http status is returned too
+func (s *apiServer) fileDataKey(header http.Header, resp *metapb.GetFileChunksResp) ([]byte, int, error) {
+	switch resp.Encryption {
+	case "":
+		return nil, 0, nil
+	case common.EncryptionCustomer:
+		customer_key, customer_key_md5, err := readCustomerKey(header)
+		if err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+		if customer_key == nil {
+			return nil, http.StatusBadRequest, fmt.Errorf("file is encrypted with the key of the client, pass it in %s header", sseCustomerKeyHeader)
+		}
+		if customer_key_md5 != resp.CustomerKeyMd5 {
+			return nil, http.StatusForbidden, errors.New("file is encrypted with another key")
+		}
+		return customer_key, 0, nil
+	case common.EncryptionAES256:
+		if s.key_manager == nil {
+			return nil, http.StatusInternalServerError, errors.New("file is encrypted, but encryption_keyfile is not set in config")
+		}
+		data_key, err := s.key_manager.UnwrapKey(resp.KeyId, resp.WrappedKey)
+		if err != nil {
+			return nil, http.StatusInternalServerError, fmt.Errorf("failed to unwrap data key of the file: %v", err)
+		}
+		return data_key, 0, nil
+	default:
+		return nil, http.StatusInternalServerError, fmt.Errorf("unknown encryption %s of the file", resp.Encryption)
+	}
+}
+
+// number of chunk is authenticated, so chunks of the file can't be reordered on shards
+func chunkAdditionalData(seqnum int) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(seqnum))
+}
+
+// encryptChunk must be called after compressChunk, encrypted data can't be compressed
+func encryptChunk(data_key []byte, seqnum int, data []byte) ([]byte, error) {
+	if data_key == nil {
+		return data, nil
+	}
+	return common.Seal(data_key, data, chunkAdditionalData(seqnum))
+}
+
+func decryptChunk(data_key []byte, seqnum int, data []byte) ([]byte, error) {
+	if data_key == nil {
+		return data, nil
+	}
+	return common.Open(data_key, data, chunkAdditionalData(seqnum))
+}
+
+// setEncryptionHeaders tells how the file is encrypted, the key of the client is identified only by its md5
+func setEncryptionHeaders(header http.Header, resp *metapb.GetFileChunksResp) {
+	switch resp.Encryption {
+	case common.EncryptionAES256:
+		header.Set(sseHeader, common.EncryptionAES256)
+	case common.EncryptionCustomer:
+		header.Set(sseCustomerAlgorithmHeader, common.EncryptionAES256)
+		header.Set(sseCustomerKeyMD5Header, resp.CustomerKeyMd5)
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"common"
+	"context"
+	"fmt"
+)
+
+// checkShards requires quorum of shards of the default tier to be ready, new files are written there
+func (s *apiServer) checkShards(ctx context.Context) error {
+	tier := s.config.GetDefaultTier()
+	shards := s.config.GetTierShards(tier)
+
+	ready := make(chan bool, len(shards))
+	for shard, port := range shards {
+		go func() {
+			readiness, err := common.RemoteReadiness(ctx, s.health_client, s.config.GetServiceURL(shard, port))
+			ready <- err == nil && readiness.Ready
+		}()
+	}
+	ready_shards := 0
+	for range shards {
+		if <-ready {
+			ready_shards++
+		}
+	}
+
+	if ready_shards*2 <= len(shards) {
+		return fmt.Errorf("only %d of %d shards of tier %s are ready", ready_shards, len(shards), tier)
+	}
+	return nil
+}
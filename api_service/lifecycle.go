@@ -0,0 +1,337 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log/slog"
+	metapb "meta/proto"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	lifecycleStatusEnabled  = "Enabled"
+	lifecycleStatusDisabled = "Disabled"
+
+	// 1000 rules with filters of reasonable size fit into this limit
+	maxLifecycleRequestSize = 4 << 20
+)
+
+type lifecycleFilterAnd struct {
+	Prefix string    `xml:"Prefix,omitempty"`
+	Tags   []fileTag `xml:"Tag"`
+}
+
+// lifecycleFilter is written in XML like in S3 (Prefix, Tag or And with both of them)
+// and in JSON as prefix and list of tags
+type lifecycleFilter struct {
+	Prefix string              `xml:"Prefix,omitempty" json:"prefix,omitempty"`
+	Tag    *fileTag            `xml:"Tag" json:"-"`
+	And    *lifecycleFilterAnd `xml:"And" json:"-"`
+	Tags   []fileTag           `xml:"-" json:"tags,omitempty"`
+}
+
+type lifecycleExpiration struct {
+	Days int32 `xml:"Days" json:"days"`
+}
+
+// lifecycleTransition moves files into another tier of config, which is called storage class in S3
+type lifecycleTransition struct {
+	Days int32  `xml:"Days" json:"days"`
+	Tier string `xml:"StorageClass" json:"tier"`
+}
+
+type lifecycleRule struct {
+	ID         string               `xml:"ID" json:"id"`
+	Status     string               `xml:"Status" json:"status"`
+	Filter     *lifecycleFilter     `xml:"Filter" json:"filter,omitempty"`
+	Expiration *lifecycleExpiration `xml:"Expiration" json:"expiration,omitempty"`
+	Transition *lifecycleTransition `xml:"Transition" json:"transition,omitempty"`
+	// there are neither versions of files nor multipart uploads in the storage, these actions are only recognized to be rejected
+	NoncurrentVersionExpiration    *struct{} `xml:"NoncurrentVersionExpiration" json:"noncurrent_version_expiration,omitempty"`
+	AbortIncompleteMultipartUpload *struct{} `xml:"AbortIncompleteMultipartUpload" json:"abort_incomplete_multipart_upload,omitempty"`
+}
+
+// lifecycleConfiguration is compatible with body of S3 PutBucketLifecycleConfiguration request,
+// the same structure is accepted in JSON
+type lifecycleConfiguration struct {
+	XMLName xml.Name        `xml:"LifecycleConfiguration" json:"-"`
+	Rules   []lifecycleRule `xml:"Rule" json:"rules"`
+}
+
+// lifecycleRuleToMeta returns http status of the response together with error if the rule can't be applied
+func lifecycleRuleToMeta(rule lifecycleRule) (*metapb.LifecycleRule, int, error) {
+	if rule.NoncurrentVersionExpiration != nil {
+		return nil, http.StatusNotImplemented, fmt.Errorf("rule %s: files have no versions, so NoncurrentVersionExpiration is not supported", rule.ID)
+	}
+	if rule.AbortIncompleteMultipartUpload != nil {
+		return nil, http.StatusNotImplemented, fmt.Errorf("rule %s: there are no multipart uploads, so AbortIncompleteMultipartUpload is not supported", rule.ID)
+	}
+	if rule.Expiration == nil && rule.Transition == nil {
+		return nil, http.StatusBadRequest, fmt.Errorf("rule %s must contain Expiration or Transition", rule.ID)
+	}
+	if rule.Status != lifecycleStatusEnabled && rule.Status != lifecycleStatusDisabled {
+		return nil, http.StatusBadRequest, fmt.Errorf("status of rule %s must be %s or %s, got %q", rule.ID, lifecycleStatusEnabled, lifecycleStatusDisabled, rule.Status)
+	}
+
+	meta_rule := &metapb.LifecycleRule{
+		Id:      rule.ID,
+		Enabled: rule.Status == lifecycleStatusEnabled,
+		Tags:    make(map[string]string),
+	}
+	if rule.Expiration != nil {
+		if rule.Expiration.Days <= 0 {
+			return nil, http.StatusBadRequest, fmt.Errorf("rule %s: days of Expiration must be positive", rule.ID)
+		}
+		meta_rule.ExpirationDays = rule.Expiration.Days
+	}
+	if rule.Transition != nil {
+		if rule.Transition.Days <= 0 {
+			return nil, http.StatusBadRequest, fmt.Errorf("rule %s: days of Transition must be positive", rule.ID)
+		}
+		meta_rule.TransitionDays = rule.Transition.Days
+		meta_rule.TransitionTier = rule.Transition.Tier
+	}
+	if rule.Filter == nil {
+		return meta_rule, http.StatusOK, nil
+	}
+
+	tags := append([]fileTag{}, rule.Filter.Tags...)
+	if rule.Filter.Tag != nil {
+		tags = append(tags, *rule.Filter.Tag)
+	}
+	meta_rule.Prefix = rule.Filter.Prefix
+	if rule.Filter.And != nil {
+		if meta_rule.Prefix != "" && rule.Filter.And.Prefix != "" {
+			return nil, http.StatusBadRequest, fmt.Errorf("rule %s: prefix must be set either in Filter or in And", rule.ID)
+		}
+		if rule.Filter.And.Prefix != "" {
+			meta_rule.Prefix = rule.Filter.And.Prefix
+		}
+		tags = append(tags, rule.Filter.And.Tags...)
+	}
+
+	for _, tag := range tags {
+		if _, exists := meta_rule.Tags[tag.Key]; exists {
+			return nil, http.StatusBadRequest, fmt.Errorf("rule %s: tag %s is set more than once", rule.ID, tag.Key)
+		}
+		meta_rule.Tags[tag.Key] = tag.Value
+	}
+	return meta_rule, http.StatusOK, nil
+}
+
+func lifecycleRuleFromMeta(meta_rule *metapb.LifecycleRule, for_xml bool) lifecycleRule {
+	rule := lifecycleRule{
+		ID:     meta_rule.Id,
+		Status: lifecycleStatusDisabled,
+		Filter: &lifecycleFilter{},
+	}
+	if meta_rule.Enabled {
+		rule.Status = lifecycleStatusEnabled
+	}
+	if meta_rule.ExpirationDays > 0 {
+		rule.Expiration = &lifecycleExpiration{Days: meta_rule.ExpirationDays}
+	}
+	if meta_rule.TransitionDays > 0 {
+		rule.Transition = &lifecycleTransition{Days: meta_rule.TransitionDays, Tier: meta_rule.TransitionTier}
+	}
+
+	tags := make([]fileTag, 0, len(meta_rule.Tags))
+	for key, value := range meta_rule.Tags {
+		tags = append(tags, fileTag{Key: key, Value: value})
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i].Key < tags[j].Key })
+
+	switch {
+	case !for_xml:
+		rule.Filter.Prefix = meta_rule.Prefix
+		rule.Filter.Tags = tags
+	case len(tags) == 0:
+		rule.Filter.Prefix = meta_rule.Prefix
+	case len(tags) == 1 && meta_rule.Prefix == "":
+		rule.Filter.Tag = &tags[0]
+	default:
+		// S3 requires several conditions to be combined by And
+		rule.Filter.And = &lifecycleFilterAnd{Prefix: meta_rule.Prefix, Tags: tags}
+	}
+	return rule
+}
+
+// readLifecycleConfiguration parses body of request, in case of error response is already written
+func readLifecycleConfiguration(w http.ResponseWriter, req *http.Request) ([]*metapb.LifecycleRule, bool) {
+	body, err := io.ReadAll(io.LimitReader(req.Body, maxLifecycleRequestSize+1))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Received unexpected error while reading data from request: %s\n", err)
+		return nil, false
+	}
+	if len(body) > maxLifecycleRequestSize {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		fmt.Fprintf(w, "Body of request must not be larger than %d bytes\n", maxLifecycleRequestSize)
+		return nil, false
+	}
+
+	var configuration lifecycleConfiguration
+	if isXMLRequest(req, body) {
+		err = xml.Unmarshal(body, &configuration)
+	} else {
+		err = json.Unmarshal(body, &configuration)
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Malformed body of request: %v\n", err)
+		return nil, false
+	}
+
+	rules := make([]*metapb.LifecycleRule, 0, len(configuration.Rules))
+	for _, rule := range configuration.Rules {
+		meta_rule, http_status, err := lifecycleRuleToMeta(rule)
+		if err != nil {
+			w.WriteHeader(http_status)
+			fmt.Fprintf(w, "Invalid lifecycle configuration: %v\n", err)
+			return nil, false
+		}
+		rules = append(rules, meta_rule)
+	}
+	return rules, true
+}
+
+func writeLifecycleError(w http.ResponseWriter, req *http.Request, handler string, err error) {
+	switch status.Code(err) {
+	case codes.NotFound:
+		w.WriteHeader(http.StatusNotFound)
+	case codes.InvalidArgument:
+		w.WriteHeader(http.StatusBadRequest)
+	case codes.FailedPrecondition:
+		w.WriteHeader(http.StatusConflict)
+	default:
+		writeUnexpectedCode(req.Context(), w, handler, err)
+		return
+	}
+	fmt.Fprintf(w, "Received error: %v\n", err)
+}
+
+// putBucketLifecycle replaces all lifecycle rules of the bucket, files are expired by background jobs of meta service
+func (s *apiServer) putBucketLifecycle(w http.ResponseWriter, req *http.Request) {
+	bucket := mux.Vars(req)["bucket"]
+
+	rules, ok := readLifecycleConfiguration(w, req)
+	if !ok {
+		return
+	}
+	if len(rules) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(w, "Lifecycle configuration must contain at least one rule, use DELETE to remove all of them")
+		return
+	}
+
+	_, err := s.grpc_client.PutBucketLifecycle(req.Context(), &metapb.PutBucketLifecycleReq{Bucket: bucket, Rules: rules})
+	if err != nil {
+		writeLifecycleError(w, req, "putBucketLifecycle", err)
+		return
+	}
+
+	fmt.Fprintf(w, "Successfully set %d lifecycle rules of bucket %s\n", len(rules), bucket)
+	slog.InfoContext(req.Context(), "Put lifecycle configuration", "bucket", bucket)
+}
+
+// getBucketLifecycle returns rules in XML if it is accepted by client and in JSON otherwise
+func (s *apiServer) getBucketLifecycle(w http.ResponseWriter, req *http.Request) {
+	bucket := mux.Vars(req)["bucket"]
+
+	resp, err := s.grpc_client.GetBucketLifecycle(req.Context(), &metapb.GetBucketLifecycleReq{Bucket: bucket})
+	if err != nil {
+		writeLifecycleError(w, req, "getBucketLifecycle", err)
+		return
+	}
+	if len(resp.Rules) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "Bucket %s has no lifecycle configuration\n", bucket)
+		return
+	}
+
+	for_xml := strings.Contains(req.Header.Get("Accept"), "xml")
+	configuration := lifecycleConfiguration{Rules: make([]lifecycleRule, 0, len(resp.Rules))}
+	for _, rule := range resp.Rules {
+		configuration.Rules = append(configuration.Rules, lifecycleRuleFromMeta(rule, for_xml))
+	}
+
+	if for_xml {
+		w.Header().Set("Content-Type", "application/xml")
+		io.WriteString(w, xml.Header)
+		xml.NewEncoder(w).Encode(configuration)
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(configuration)
+	}
+	slog.InfoContext(req.Context(), "Served lifecycle configuration", "bucket", bucket)
+}
+
+func (s *apiServer) deleteBucketLifecycle(w http.ResponseWriter, req *http.Request) {
+	bucket := mux.Vars(req)["bucket"]
+
+	_, err := s.grpc_client.PutBucketLifecycle(req.Context(), &metapb.PutBucketLifecycleReq{Bucket: bucket})
+	if err != nil {
+		writeLifecycleError(w, req, "deleteBucketLifecycle", err)
+		return
+	}
+
+	fmt.Fprintf(w, "Successfully deleted lifecycle configuration of bucket %s\n", bucket)
+	slog.InfoContext(req.Context(), "Delete lifecycle configuration", "bucket", bucket)
+}
+
+// previewBucketLifecycle lists files which would be expired now. GET checks stored rules of the bucket,
+// PUT checks rules from the body without saving them
+func (s *apiServer) previewBucketLifecycle(w http.ResponseWriter, req *http.Request) {
+	bucket := mux.Vars(req)["bucket"]
+
+	req_to_meta := &metapb.PreviewLifecycleReq{Bucket: bucket}
+	if req.Method == http.MethodPut {
+		rules, ok := readLifecycleConfiguration(w, req)
+		if !ok {
+			return
+		}
+		if len(rules) == 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintln(w, "Lifecycle configuration must contain at least one rule")
+			return
+		}
+		req_to_meta.Rules = rules
+	}
+
+	query := req.URL.Query()
+	if query.Has("limit") {
+		limit, err := strconv.ParseInt(query.Get("limit"), 10, 32)
+		if err != nil || limit <= 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "Limit must be a positive number, got %q\n", query.Get("limit"))
+			return
+		}
+		req_to_meta.Limit = int32(limit)
+	}
+
+	resp, err := s.grpc_client.PreviewLifecycle(req.Context(), req_to_meta)
+	if err != nil {
+		writeLifecycleError(w, req, "previewBucketLifecycle", err)
+		return
+	}
+
+	slog.InfoContext(req.Context(), "Served lifecycle preview", "bucket", bucket)
+	fmt.Fprintf(w, "Lifecycle rules would expire %d files of bucket %s now:\n", len(resp.Files), bucket)
+	for _, file := range resp.Files {
+		fmt.Fprintf(w, "> %s (rule: %s, bytes: %d, created at: %s)\n",
+			file.File, file.RuleId, file.Size, time.Unix(file.CreatedAt, 0).UTC().Format(time.RFC3339))
+	}
+	if resp.Truncated {
+		fmt.Fprintln(w, "Result is truncated, pass bigger limit to see the rest")
+	}
+}
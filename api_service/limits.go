@@ -0,0 +1,283 @@
+package main
+
+import (
+	"common"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// config is checked for changed limits this often
+	limitsReloadInterval = 5 * time.Second
+	defaultQueueTimeout  = 10 * time.Second
+)
+
+var (
+	errTransferQueueFull    = errors.New("too many uploads and downloads are in progress and queued")
+	errTransferQueueTimeout = errors.New("upload or download has waited in queue for too long")
+)
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// keyedLimiter keeps token bucket for every key (access key, bucket or ip)
+type keyedLimiter struct {
+	mutex   sync.Mutex
+	limit   common.RateLimit
+	buckets map[string]*tokenBucket
+}
+
+func (l *keyedLimiter) refill(bucket *tokenBucket, now time.Time) {
+	bucket.tokens = min(float64(l.limit.Burst), bucket.tokens+now.Sub(bucket.updated).Seconds()*l.limit.Rate)
+	bucket.updated = now
+}
+
+// take takes token of the key, if there is none it returns how long to wait for it
+func (l *keyedLimiter) take(key string, now time.Time) (bool, time.Duration) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.limit.Rate == 0 {
+		return true, 0
+	}
+	bucket, found := l.buckets[key]
+	if !found {
+		bucket = &tokenBucket{tokens: float64(l.limit.Burst), updated: now}
+		l.buckets[key] = bucket
+	}
+	l.refill(bucket, now)
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - bucket.tokens) / l.limit.Rate * float64(time.Second))
+}
+
+// setLimit resets all buckets if the limit is changed
+func (l *keyedLimiter) setLimit(limit common.RateLimit) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.buckets == nil || l.limit != limit {
+		l.limit = limit
+		l.buckets = make(map[string]*tokenBucket)
+	}
+}
+
+// forgetIdle removes buckets which are full, they are the same as absent ones
+func (l *keyedLimiter) forgetIdle(now time.Time) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	for key, bucket := range l.buckets {
+		l.refill(bucket, now)
+		if bucket.tokens >= float64(l.limit.Burst) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// transferQueue caps the number of uploads and downloads which are served at once,
+// others wait for their turn in FIFO order
+type transferQueue struct {
+	mutex         sync.Mutex
+	in_flight     int
+	waiters       []chan struct{}
+	max_in_flight int
+	max_queued    int
+	timeout       time.Duration
+}
+
+func (q *transferQueue) hasSlot() bool {
+	return q.max_in_flight == 0 || q.in_flight < q.max_in_flight
+}
+
+// wakeWaiters must be called under the mutex
+func (q *transferQueue) wakeWaiters() {
+	for len(q.waiters) > 0 && q.hasSlot() {
+		close(q.waiters[0])
+		q.waiters = q.waiters[1:]
+		q.in_flight++
+	}
+}
+
+// acquire waits for a free slot, release must be called after the transfer if it returns nil
+func (q *transferQueue) acquire(ctx context.Context) error {
+	q.mutex.Lock()
+	if len(q.waiters) == 0 && q.hasSlot() {
+		q.in_flight++
+		q.mutex.Unlock()
+		return nil
+	}
+	if len(q.waiters) >= q.max_queued {
+		q.mutex.Unlock()
+		return errTransferQueueFull
+	}
+	waiter := make(chan struct{})
+	q.waiters = append(q.waiters, waiter)
+	timer := time.NewTimer(q.timeout)
+	defer timer.Stop()
+	q.mutex.Unlock()
+
+	var err error
+	select {
+	case <-waiter:
+		return nil
+	case <-timer.C:
+		err = errTransferQueueTimeout
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	for i, cur := range q.waiters {
+		if cur == waiter {
+			q.waiters = append(q.waiters[:i], q.waiters[i+1:]...)
+			return err
+		}
+	}
+	// the slot was given right before the wait was over
+	return nil
+}
+
+func (q *transferQueue) release() {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	q.in_flight--
+	q.wakeWaiters()
+}
+
+func (q *transferQueue) setLimits(max_in_flight, max_queued int, timeout time.Duration) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	q.max_in_flight = max_in_flight
+	q.max_queued = max_queued
+	q.timeout = timeout
+	q.wakeWaiters()
+}
+
+// requestLimits are limits of config which are applied to requests, they are reloaded when config changes
+type requestLimits struct {
+	access_keys keyedLimiter
+	buckets     keyedLimiter
+	ips         keyedLimiter
+	transfers   transferQueue
+}
+
+func newRequestLimits(limits common.Limits) *requestLimits {
+	l := &requestLimits{}
+	l.apply(limits)
+	return l
+}
+
+func (l *requestLimits) apply(limits common.Limits) {
+	l.access_keys.setLimit(limits.Access_key)
+	l.buckets.setLimit(limits.Bucket)
+	l.ips.setLimit(limits.Ip)
+
+	queue_timeout := defaultQueueTimeout
+	if limits.Queue_timeout_ms > 0 {
+		queue_timeout = time.Duration(limits.Queue_timeout_ms) * time.Millisecond
+	}
+	l.transfers.setLimits(limits.Max_transfers, limits.Max_queued_transfers, queue_timeout)
+}
+
+// watchConfig applies limits from config when it changes and forgets idle clients, it never returns
+func (l *requestLimits) watchConfig(config_path string) {
+	var mod_time time.Time
+	if file_info, err := os.Stat(config_path); err == nil {
+		mod_time = file_info.ModTime()
+	}
+
+	for range time.Tick(limitsReloadInterval) {
+		now := time.Now()
+		l.access_keys.forgetIdle(now)
+		l.buckets.forgetIdle(now)
+		l.ips.forgetIdle(now)
+
+		file_info, err := os.Stat(config_path)
+		if err != nil || file_info.ModTime().Equal(mod_time) {
+			continue
+		}
+		mod_time = file_info.ModTime()
+		config, err := common.LoadConfig(config_path)
+		if err != nil {
+			slog.Warn("Failed to reload limits, old ones are used", "error", err)
+			continue
+		}
+		l.apply(config.Limits)
+		slog.Info("Reloaded limits", "limits", config.Limits)
+	}
+}
+
+func writeTooManyRequests(w http.ResponseWriter, retry_after time.Duration, reason string) {
+	w.Header().Set("Retry-After", strconv.Itoa(max(1, int(math.Ceil(retry_after.Seconds())))))
+	w.WriteHeader(http.StatusTooManyRequests)
+	fmt.Fprintf(w, "Too many requests: %s\n", reason)
+}
+
+// limitSourceIP is a middleware which is applied before requests are authenticated, so floods of requests
+// with invalid signatures are limited too
+func (s *apiServer) limitSourceIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ip, _, err := net.SplitHostPort(req.RemoteAddr)
+		if err != nil {
+			ip = req.RemoteAddr
+		}
+		if allowed, retry_after := s.limits.ips.take(ip, time.Now()); !allowed {
+			writeTooManyRequests(w, retry_after, "rate limit of address "+ip+" is exceeded")
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// limitAccessKeyAndBucket is a middleware which is applied after requests are authenticated,
+// anonymous requests are limited only by their addresses and buckets
+func (s *apiServer) limitAccessKeyAndBucket(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		now := time.Now()
+		if access_key_id := requestAccessKey(req); access_key_id != "" {
+			if allowed, retry_after := s.limits.access_keys.take(access_key_id, now); !allowed {
+				writeTooManyRequests(w, retry_after, "rate limit of access key "+access_key_id+" is exceeded")
+				return
+			}
+		}
+		if bucket := mux.Vars(req)["bucket"]; bucket != "" {
+			if allowed, retry_after := s.limits.buckets.take(bucket, now); !allowed {
+				writeTooManyRequests(w, retry_after, "rate limit of bucket "+bucket+" is exceeded")
+				return
+			}
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// transfer admits upload or download only if there is a free slot for it, waiting in queue if needed
+func (s *apiServer) transfer(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		err := s.limits.transfers.acquire(req.Context())
+		if err != nil {
+			writeTooManyRequests(w, time.Second, err.Error())
+			return
+		}
+		defer s.limits.transfers.release()
+		handler(w, req)
+	}
+}
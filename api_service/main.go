@@ -4,35 +4,62 @@ import (
 	"bytes"
 	"common"
 	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	metapb "meta/proto"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"bitbucket.org/pcastools/hash"
 
 	"github.com/gorilla/mux"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/status"
 )
 
+// settings of bucket can be passed on creation as X-Bucket-Setting-<Name>: <value> headers
+const bucketSettingHeaderPrefix = "X-Bucket-Setting-"
+
+// these names are taken by routes which are not related to buckets
+var reservedBucketNames = map[string]bool{
+	"jobs":    true,
+	"metrics": true,
+	"healthz": true,
+	"readyz":  true,
+}
+
 type apiServer struct {
 	conn        *grpc.ClientConn
 	grpc_client metapb.ApiWithMetaServiceClient
 	config      common.Config
+	// nil if encryption_keyfile is not set in config
+	key_manager common.KeyManager
+	// presents certificate of api service to shards if TLS is enabled
+	http_client *http.Client
+	// checks readiness of shards, so checks are not mixed with requests of chunks in metrics
+	health_client *http.Client
+	limits        *requestLimits
+	metrics       *common.Metrics
 }
 
-// rendezvous hashing
-func (s *apiServer) getShard(chunk []byte) (string, int) {
+// getShard chooses one of shards of the tier for the chunk by rendezvous hashing of its data
+func (s *apiServer) getShard(chunk []byte, shards map[string]int) (string, int) {
 	var best uint32
 	var best_shard_name string
 	var best_shard_port int
-	for shard, port := range s.config.Shards {
+	for shard, port := range shards {
 		cur := hash.ByteSlice(chunk) ^ hash.String(shard)
 		if best < cur {
 			best = cur
@@ -43,6 +70,34 @@ func (s *apiServer) getShard(chunk []byte) (string, int) {
 	return best_shard_name, best_shard_port
 }
 
+// statusClientClosedRequest is written when the client goes away before meta service answers, as in nginx
+const statusClientClosedRequest = 499
+
+// unexpectedError returns status and error for the client of the error of meta service which the handler has no case for.
+// Calls are cancelled and time out together with requests of clients, other errors are failures of meta service.
+// Their text may contain internals of meta service and its database, so it's only logged, the client gets id of the request
+func unexpectedError(ctx context.Context, handler string, err error) (int, error) {
+	switch status.Code(err) {
+	case codes.Canceled:
+		return statusClientClosedRequest, errors.New("request is cancelled")
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout, errors.New("meta service did not answer in time")
+	case codes.Unavailable:
+		slog.WarnContext(ctx, "Meta service is unavailable", "handler", handler, "error", err)
+		return http.StatusServiceUnavailable, fmt.Errorf("meta service is unavailable, id of request: %s", common.RequestID(ctx))
+	default:
+		slog.ErrorContext(ctx, "Received unknown error", "handler", handler, "error", err)
+		return http.StatusInternalServerError, fmt.Errorf("internal error, id of request: %s", common.RequestID(ctx))
+	}
+}
+
+// writeUnexpectedCode answers with the error of meta service which the handler has no case for
+func writeUnexpectedCode(ctx context.Context, w http.ResponseWriter, handler string, err error) {
+	error_status, err := unexpectedError(ctx, handler, err)
+	w.WriteHeader(error_status)
+	fmt.Fprintf(w, "Received error: %v\n", err)
+}
+
 func (s *apiServer) createBucket(w http.ResponseWriter, req *http.Request) {
 	bucket := mux.Vars(req)["bucket"]
 
@@ -56,67 +111,208 @@ func (s *apiServer) createBucket(w http.ResponseWriter, req *http.Request) {
 		fmt.Fprintln(w, "Name of bucket must be non-empty")
 		return
 	}
+	if reservedBucketNames[bucket] {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Name %s is reserved and can't be used for bucket\n", bucket)
+		return
+	}
 
-	_, err := s.grpc_client.CreateBucket(context.Background(), &metapb.CreateBucketReq{Bucket: bucket})
+	settings := make(map[string]string)
+	for header, values := range req.Header {
+		setting, found := strings.CutPrefix(header, bucketSettingHeaderPrefix)
+		if found && setting != "" {
+			settings[strings.ToLower(setting)] = values[0]
+		}
+	}
+	if compression, found := settings[compressionSetting]; found && !isValidCompression(compression) {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Compression of bucket must be %s, %s or %s, got %q\n", common.CodecZstd, common.CodecGzip, compressionNone, compression)
+		return
+	}
+	if encryption, found := settings[encryptionSetting]; found {
+		if !isValidEncryption(encryption) {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "Encryption of bucket must be %s or %s, got %q\n", common.EncryptionAES256, encryptionNone, encryption)
+			return
+		}
+		if encryption == common.EncryptionAES256 && s.key_manager == nil {
+			w.WriteHeader(http.StatusNotImplemented)
+			fmt.Fprintln(w, "Encryption_keyfile is not set in config, so files can't be encrypted")
+			return
+		}
+	}
+
+	_, err := s.grpc_client.CreateBucket(req.Context(), &metapb.CreateBucketReq{Bucket: bucket, Owner: requestUser(req), Settings: settings})
 
 	if err != nil {
 		switch status.Code(err) {
 		case codes.AlreadyExists:
 			w.WriteHeader(http.StatusPreconditionFailed)
-		case codes.Unavailable:
-			w.WriteHeader(http.StatusServiceUnavailable)
 		default:
-			log.Fatalf("Received unknown error in createBucket: %v\n", err)
+			writeUnexpectedCode(req.Context(), w, "createBucket", err)
+			return
 		}
 		fmt.Fprintf(w, "Received error: %v\n", err)
 		return
 	}
 
 	fmt.Fprintf(w, "Successfuly created bucket: %s\n", bucket)
-	log.Printf("Created bucket: %s\n", bucket)
+	slog.InfoContext(req.Context(), "Created bucket", "bucket", bucket)
 }
 
 func (s *apiServer) deleteBucket(w http.ResponseWriter, req *http.Request) {
 	bucket := mux.Vars(req)["bucket"]
 
-	_, err := s.grpc_client.DeleteBucket(context.Background(), &metapb.DeleteBucketReq{Bucket: bucket})
+	recursive := false
+	if req.URL.Query().Has("recursive") {
+		var err error
+		recursive, err = strconv.ParseBool(req.URL.Query().Get("recursive"))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "Invalid value of recursive parameter: %v\n", err)
+			return
+		}
+	}
+
+	resp, err := s.grpc_client.DeleteBucket(req.Context(), &metapb.DeleteBucketReq{Bucket: bucket, Recursive: recursive})
 	if err != nil {
 		switch status.Code(err) {
 		case codes.NotFound:
 			w.WriteHeader(http.StatusNotFound)
 		case codes.FailedPrecondition:
 			w.WriteHeader(http.StatusPreconditionFailed)
-		case codes.Unavailable:
-			w.WriteHeader(http.StatusServiceUnavailable)
 		default:
-			log.Fatalf("Received unknown error in deleteBucket: %v\n", err)
+			writeUnexpectedCode(req.Context(), w, "deleteBucket", err)
+			return
 		}
 		fmt.Fprintf(w, "Received error: %v\n", err)
 		return
 	}
 
+	if recursive {
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprintf(w, "Started deleting bucket %s in background, id of the job: %d\n", bucket, resp.JobId)
+		fmt.Fprintf(w, "Status of the job is available at /jobs/%d\n", resp.JobId)
+		slog.InfoContext(req.Context(), "Started job deleting bucket", "bucket", bucket, "job_id", resp.JobId)
+		return
+	}
+
 	fmt.Fprintf(w, "Successfuly deleted bucket: %s\n", bucket)
-	log.Printf("Deleted bucket: %s\n", bucket)
+	slog.InfoContext(req.Context(), "Deleted bucket", "bucket", bucket)
+}
+
+func (s *apiServer) listBuckets(w http.ResponseWriter, req *http.Request) {
+	resp, err := s.grpc_client.ListBuckets(req.Context(), &metapb.ListBucketsReq{})
+	if err != nil {
+		writeUnexpectedCode(req.Context(), w, "listBuckets", err)
+		return
+	}
+
+	if s.config.Auth {
+		// only buckets which files the user may list are shown
+		visible := resp.Buckets[:0]
+		for _, info := range resp.Buckets {
+			if error_status, _ := s.bucketAccess(req, info, "", common.ActionList); error_status == 0 {
+				visible = append(visible, info)
+			}
+		}
+		resp.Buckets = visible
+	}
+
+	slog.InfoContext(req.Context(), "Served listBuckets response")
+	fmt.Fprintf(w, "There are %d buckets:\n", len(resp.Buckets))
+	for _, info := range resp.Buckets {
+		fmt.Fprintf(w, "> %s (objects: %d, bytes: %d, stored bytes: %d, created at: %s, owner: %q)\n",
+			info.Bucket, info.Objects, info.Bytes, info.StoredBytes, time.Unix(info.CreatedAt, 0).UTC().Format(time.RFC3339), info.Owner)
+	}
+}
+
+func (s *apiServer) headBucket(w http.ResponseWriter, req *http.Request) {
+	bucket := mux.Vars(req)["bucket"]
+
+	resp, err := s.grpc_client.GetBucket(req.Context(), &metapb.GetBucketReq{Bucket: bucket})
+	if err != nil {
+		switch status.Code(err) {
+		case codes.NotFound:
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			writeUnexpectedCode(req.Context(), w, "headBucket", err)
+		}
+		return
+	}
+
+	info := resp.Bucket
+	w.Header().Set("X-Bucket-Created-At", time.Unix(info.CreatedAt, 0).UTC().Format(http.TimeFormat))
+	w.Header().Set("X-Bucket-Owner", info.Owner)
+	w.Header().Set("X-Bucket-Object-Count", strconv.FormatInt(info.Objects, 10))
+	w.Header().Set("X-Bucket-Bytes-Used", strconv.FormatInt(info.Bytes, 10))
+	w.Header().Set("X-Bucket-Stored-Bytes", strconv.FormatInt(info.StoredBytes, 10))
+	for setting, value := range info.Settings {
+		w.Header().Set(bucketSettingHeaderPrefix+setting, value)
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *apiServer) getJob(w http.ResponseWriter, req *http.Request) {
+	job_id, err := strconv.ParseInt(mux.Vars(req)["job_id"], 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Invalid id of job: %v\n", err)
+		return
+	}
+
+	resp, err := s.grpc_client.GetJob(req.Context(), &metapb.GetJobReq{Id: job_id})
+	if err != nil {
+		switch status.Code(err) {
+		case codes.NotFound:
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			writeUnexpectedCode(req.Context(), w, "getJob", err)
+			return
+		}
+		fmt.Fprintf(w, "Received error: %v\n", err)
+		return
+	}
+
+	job := resp.Job
+	// job of deleted bucket can be seen only by admins, its owner is not known anymore
+	if error_status, err := s.checkAccess(req, job.Bucket, "", common.ActionAdmin); err != nil {
+		if error_status == http.StatusNotFound {
+			error_status = http.StatusForbidden
+		}
+		w.WriteHeader(error_status)
+		fmt.Fprintf(w, "Access denied: %v\n", err)
+		return
+	}
+	fmt.Fprintf(w, "Job %d (%s of bucket %s) is %s\n", job.Id, job.Kind, job.Bucket, job.State)
+	fmt.Fprintf(w, "Created at: %s\n", time.Unix(job.CreatedAt, 0).UTC().Format(time.RFC3339))
+	fmt.Fprintf(w, "Updated at: %s\n", time.Unix(job.UpdatedAt, 0).UTC().Format(time.RFC3339))
+	fmt.Fprintf(w, "Deleted objects: %d, deleted chunks: %d\n", job.DeletedObjects, job.DeletedChunks)
+	if job.MovedObjects > 0 || job.MovedChunks > 0 {
+		fmt.Fprintf(w, "Moved objects: %d, moved chunks: %d\n", job.MovedObjects, job.MovedChunks)
+	}
+	if job.Error != "" {
+		fmt.Fprintf(w, "Last error (job will be retried): %s\n", job.Error)
+	}
 }
 
 func (s *apiServer) getFilesFromBucket(w http.ResponseWriter, req *http.Request) {
 	bucket := mux.Vars(req)["bucket"]
 
-	resp, err := s.grpc_client.GetFiles(context.Background(), &metapb.GetFilesReq{Bucket: bucket})
+	resp, err := s.grpc_client.GetFiles(req.Context(), &metapb.GetFilesReq{Bucket: bucket})
 	if err != nil {
 		switch status.Code(err) {
 		case codes.NotFound:
 			w.WriteHeader(http.StatusNotFound)
-		case codes.Unavailable:
-			w.WriteHeader(http.StatusServiceUnavailable)
 		default:
-			log.Fatalf("Received unknown error in getFilesFromBucket: %v\n", err)
+			writeUnexpectedCode(req.Context(), w, "getFilesFromBucket", err)
+			return
 		}
 		fmt.Fprintf(w, "Received error: %v\n", err)
 		return
 	}
 
-	log.Println("Served getFilesFromBucket response")
+	slog.InfoContext(req.Context(), "Served getFilesFromBucket response", "bucket", bucket)
 	fmt.Fprintf(w, "Bucket %s consists from files:\n", bucket)
 	for i := 0; i < len(resp.Files); i++ {
 		fmt.Fprintf(w, "> %s\n", resp.Files[i])
@@ -140,129 +336,226 @@ func (s *apiServer) createFile(w http.ResponseWriter, req *http.Request) {
 	}
 
 	chunk := make([]byte, s.config.Chunk_size)
+	object_id := common.NewObjectID()
 	seqnum := 0
+	var size int64
+	content_hash := md5.New()
 	req_to_meta := &metapb.CreateFileReq{Bucket: bucket, File: file, ContentType: content_type, Chunks: make([]*metapb.ChunkFilenameWithShard, 0)}
+	readFileMetadata(req, req_to_meta)
+	tags, err := parseTaggingHeader(req.Header.Get(taggingHeader))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Invalid %s header: %v\n", taggingHeader, err)
+		return
+	}
+	req_to_meta.Tags = tags
+
+	req_to_meta.Tier = req.Header.Get(storageTierHeader)
+	if req_to_meta.Tier == "" {
+		req_to_meta.Tier = s.config.GetDefaultTier()
+	}
+	tier_shards := s.config.GetTierShards(req_to_meta.Tier)
+	if tier_shards == nil || s.config.IsArchiveTier(req_to_meta.Tier) {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Files can't be uploaded into tier %q, it is unknown or archive\n", req_to_meta.Tier)
+		return
+	}
+	req_to_meta.IfMatch = req.Header.Get("If-Match")
+	req_to_meta.IfNoneMatch = req.Header.Get("If-None-Match")
+
+	// settings of bucket are needed to choose codec before the first chunk is sent
+	bucket_info, err := s.grpc_client.GetBucket(req.Context(), &metapb.GetBucketReq{Bucket: bucket})
+	if err != nil {
+		switch status.Code(err) {
+		case codes.NotFound:
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			writeUnexpectedCode(req.Context(), w, "createFile", err)
+			return
+		}
+		fmt.Fprintf(w, "Received error: %v\n", err)
+		return
+	}
+	req_to_meta.Codec = chooseCodec(bucket_info.Bucket.Settings, content_type, req_to_meta.ContentEncoding)
+	data_key, error_status, err := s.chooseEncryption(req.Header, bucket_info.Bucket.Settings, req_to_meta)
+	if err != nil {
+		w.WriteHeader(error_status)
+		fmt.Fprintf(w, "Can't encrypt file: %v\n", err)
+		return
+	}
+	bytes_left, error_status, err := s.uploadHeadroom(req, bucket, file)
+	if err != nil {
+		w.WriteHeader(error_status)
+		fmt.Fprintf(w, "Can't upload file: %v\n", err)
+		return
+	}
 
 	n, err := req.Body.Read(chunk)
 	for ; !(err != nil && err != io.EOF); n, err = req.Body.Read(chunk) {
-		shard_name, shard_port := s.getShard(chunk)
-		chunk_name := common.GetChunkName(bucket, file, seqnum)
+		// size of streamed body is not known in advance, so the upload is aborted once it exceeds quota
+		if bytes_left != unlimitedHeadroom && size+int64(n) > bytes_left {
+			s.deleteUnusedChunks(req.Context(), req_to_meta.Chunks)
+			w.WriteHeader(http.StatusInsufficientStorage)
+			fmt.Fprintf(w, "Upload is aborted: file is larger than %d bytes which are left in quota\n", bytes_left)
+			return
+		}
+		shard_name, shard_port := s.getShard(chunk, tier_shards)
+		chunk_name := common.GetChunkName(object_id, seqnum)
 		req_to_meta.Chunks = append(req_to_meta.Chunks, &metapb.ChunkFilenameWithShard{Filename: chunk_name, Shard: shard_name})
 
-		_, http_err := http.Post(s.getStorageHandler(shard_name, shard_port, chunk_name), "application/octet-stream", bytes.NewBuffer(chunk[:n]))
+		chunk_ctx, span := startChunkSpan(req.Context(), "put chunk", seqnum, chunk_name, shard_name)
+		stored_chunk, http_err := compressChunk(req_to_meta.Codec, chunk[:n])
+		if http_err == nil {
+			stored_chunk, http_err = encryptChunk(data_key, seqnum, stored_chunk)
+		}
+		if http_err == nil {
+			http_err = s.putChunk(chunk_ctx, shard_name, shard_port, chunk_name, stored_chunk)
+		}
+		common.EndSpan(span, http_err)
 		if http_err != nil {
+			s.deleteUnusedChunks(req.Context(), req_to_meta.Chunks)
 			w.WriteHeader(http.StatusInternalServerError)
 			fmt.Fprintf(w, "Received unexpected error while sending data to shards: %v\n", http_err)
 			return
 		}
 		seqnum++
+		size += int64(n)
+		req_to_meta.StoredSize += int64(len(stored_chunk))
+		content_hash.Write(chunk[:n])
 		if err == io.EOF {
 			break
 		}
 	}
 
 	if err != nil && err != io.EOF {
+		s.deleteUnusedChunks(req.Context(), req_to_meta.Chunks)
 		w.WriteHeader(http.StatusInternalServerError)
 		fmt.Fprintf(w, "Received unexpected error while reading data from request: %s\n", err)
 		return
 	}
 
-	_, err = s.grpc_client.CreateFile(context.Background(), req_to_meta)
+	req_to_meta.Size = size
+	req_to_meta.Etag = hex.EncodeToString(content_hash.Sum(nil))
+	resp, err := s.grpc_client.CreateFile(req.Context(), req_to_meta)
 	if err != nil {
+		// file was not created, so its chunks are not needed anymore
+		s.deleteUnusedChunks(req.Context(), req_to_meta.Chunks)
+
 		switch status.Code(err) {
 		case codes.NotFound:
 			w.WriteHeader(http.StatusNotFound)
-		case codes.Unavailable:
-			w.WriteHeader(http.StatusServiceUnavailable)
 		case codes.AlreadyExists:
 			w.WriteHeader(http.StatusPreconditionFailed)
-			fmt.Fprintln(w, "Updating files is not supported")
+			fmt.Fprintln(w, "File already exists, pass its ETag in If-Match header to replace it")
 			return
+		case codes.Aborted:
+			w.WriteHeader(http.StatusPreconditionFailed)
+		case codes.FailedPrecondition:
+			w.WriteHeader(http.StatusConflict)
+		case codes.InvalidArgument:
+			w.WriteHeader(http.StatusBadRequest)
+		case codes.ResourceExhausted:
+			w.WriteHeader(http.StatusInsufficientStorage)
 		default:
-			log.Fatalf("Received unknown error createFile: %v\n", err)
+			writeUnexpectedCode(req.Context(), w, "createFile", err)
+			return
 		}
 		fmt.Fprintf(w, "Received error: %v\n", err)
 		return
 	}
 
+	s.deleteUnusedChunks(req.Context(), resp.ReplacedChunks)
+
+	w.Header().Set("ETag", `"`+req_to_meta.Etag+`"`)
 	fmt.Fprintf(w, "Successfully created file %s in bucket %s\n", file, bucket)
-	log.Printf("Create file %s in bucket %s\n", file, bucket)
+	slog.InfoContext(req.Context(), "Create file", "bucket", bucket, "file", file, "size", size)
 }
 
 func (s *apiServer) deleteFile(w http.ResponseWriter, req *http.Request) {
 	bucket := mux.Vars(req)["bucket"]
 	file := mux.Vars(req)["file"]
 
-	chunks, err := s.grpc_client.DeleteFile(context.Background(), &metapb.DeleteFileReq{Bucket: bucket, File: file})
+	chunks, err := s.grpc_client.DeleteFile(req.Context(), &metapb.DeleteFileReq{Bucket: bucket, File: file, IfMatch: req.Header.Get("If-Match")})
 	if err != nil {
 		switch status.Code(err) {
 		case codes.NotFound:
 			w.WriteHeader(http.StatusNotFound)
-		case codes.Unavailable:
-			w.WriteHeader(http.StatusServiceUnavailable)
+		case codes.Aborted:
+			w.WriteHeader(http.StatusPreconditionFailed)
 		default:
-			log.Fatalf("Received unknown error in deleteFile: %v\n", err)
+			writeUnexpectedCode(req.Context(), w, "deleteFile", err)
+			return
 		}
 		fmt.Fprintf(w, "Received error: %v\n", err)
 		return
 	}
 
 	for i := 0; i < len(chunks.Chunks); i++ {
-		chunk_name := chunks.Chunks[i].Filename
-		shard_name := chunks.Chunks[i].Shard
-		shard_port := s.config.Shards[shard_name]
-		delete_req, err := http.NewRequest("DELETE", s.getStorageHandler(shard_name, shard_port, chunk_name), nil)
+		err = s.deleteChunk(req.Context(), chunks.Chunks[i])
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			fmt.Fprintf(w, "Received unexpected error while writing data to shards: %s\n", err)
 			return
 		}
-		client := &http.Client{}
-		_, err = client.Do(delete_req)
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			fmt.Fprintf(w, "Received unexpected error while writing data to shards: %s\n", err)
-			return
-		}
-
 	}
 
 	fmt.Fprintf(w, "Successfully deleted file %s in bucket %s\n", file, bucket)
-	log.Printf("Delete file %s in bucket %s\n", file, bucket)
+	slog.InfoContext(req.Context(), "Delete file", "bucket", bucket, "file", file)
 }
 
 func (s *apiServer) getFile(w http.ResponseWriter, req *http.Request) {
 	bucket := mux.Vars(req)["bucket"]
 	file := mux.Vars(req)["file"]
 
-	resp, err := s.grpc_client.GetFileChunks(context.Background(), &metapb.GetFileChunksReq{Bucket: bucket, File: file})
+	resp, err := s.grpc_client.GetFileChunks(req.Context(), &metapb.GetFileChunksReq{Bucket: bucket, File: file})
 	if err != nil {
 		switch status.Code(err) {
 		case codes.NotFound:
 			w.WriteHeader(http.StatusNotFound)
-		case codes.Internal:
-			w.WriteHeader(http.StatusInternalServerError)
-		case codes.Unavailable:
-			w.WriteHeader(http.StatusServiceUnavailable)
 		default:
-			log.Fatalf("Received unknown error in getFile: %v\n", err)
+			writeUnexpectedCode(req.Context(), w, "getFile", err)
+			return
 		}
 		fmt.Fprintf(w, "Received error: %v\n", err)
 		return
 	}
 
+	setFileHeaders(w, resp)
+	if precondition_status := checkReadPreconditions(req, resp); precondition_status != 0 {
+		w.Header().Del("Content-Length")
+		w.WriteHeader(precondition_status)
+		return
+	}
+	if resp.Archived {
+		w.Header().Del("Content-Length")
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprintf(w, "File %s is in archive tier %s, restore it with POST /%s/%s?restore before reading\n", file, resp.Tier, bucket, file)
+		return
+	}
+	data_key, error_status, err := s.fileDataKey(req.Header, resp)
+	if err != nil {
+		w.Header().Del("Content-Length")
+		w.WriteHeader(error_status)
+		fmt.Fprintf(w, "Can't decrypt file: %v\n", err)
+		return
+	}
+
 	for i := 0; i < len(resp.Chunks); i++ {
 		chunk_name := resp.Chunks[i].Filename
 		shard_name := resp.Chunks[i].Shard
 		shard_port := s.config.Shards[shard_name]
-		data_req, err := http.Get(s.getStorageHandler(shard_name, shard_port, chunk_name))
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			fmt.Fprintf(w, "Received unexpected error while reading data from chunks: %v\n", err)
-			return
+		chunk_ctx, span := startChunkSpan(req.Context(), "get chunk", i, chunk_name, shard_name)
+		body, err := s.getChunk(chunk_ctx, shard_name, shard_port, chunk_name)
+		if err == nil {
+			body, err = decryptChunk(data_key, i, body)
+		}
+		if err == nil {
+			body, err = decompressChunk(resp.Codec, body)
 		}
-		body, err := io.ReadAll(data_req.Body)
+		common.EndSpan(span, err)
 		if err != nil {
+			// headers may be not sent yet, then length of the file must not be declared
+			w.Header().Del("Content-Length")
 			w.WriteHeader(http.StatusInternalServerError)
 			fmt.Fprintf(w, "Received unexpected error while reading data from chunks: %v\n", err)
 			return
@@ -270,8 +563,7 @@ func (s *apiServer) getFile(w http.ResponseWriter, req *http.Request) {
 		w.Write(body)
 	}
 
-	w.Header().Add("Content-Type", resp.ContentType)
-	log.Printf("Read file %s in bucket %s\n", file, bucket)
+	slog.InfoContext(req.Context(), "Read file", "bucket", bucket, "file", file)
 }
 
 func (s *apiServer) getMetaAddr() string {
@@ -280,37 +572,190 @@ func (s *apiServer) getMetaAddr() string {
 
 func (s *apiServer) getAPIAddr() string {
 	if s.config.Api_port == 0 {
-		log.Fatalln("You must specify port for API service")
+		common.Fatal("You must specify port for API service")
 	}
 
 	return ":" + strconv.Itoa(s.config.Api_port)
 }
 
+// startChunkSpan starts span of reading or writing one chunk of file, requests to shards are its children
+func startChunkSpan(ctx context.Context, name string, seqnum int, chunk_name, shard_name string) (context.Context, trace.Span) {
+	return common.Tracer.Start(ctx, name, trace.WithAttributes(
+		attribute.Int("chunk.seqnum", seqnum),
+		attribute.String("chunk.name", chunk_name),
+		attribute.String("chunk.shard", shard_name),
+	))
+}
+
+// putChunk writes data of the chunk onto the shard
+func (s *apiServer) putChunk(ctx context.Context, shard_name string, shard_port int, chunk_name string, data []byte) error {
+	put_req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.getStorageHandler(shard_name, shard_port, chunk_name), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	put_req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err := s.http_client.Do(put_req)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("shard %s responded with status %s while writing chunk %s", shard_name, resp.Status, chunk_name)
+	}
+	return nil
+}
+
+// getChunk reads data of the chunk from the shard
+func (s *apiServer) getChunk(ctx context.Context, shard_name string, shard_port int, chunk_name string) ([]byte, error) {
+	get_req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.getStorageHandler(shard_name, shard_port, chunk_name), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.http_client.Do(get_req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err == nil && resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("shard %s responded with status %s for chunk %s", shard_name, resp.Status, chunk_name)
+	}
+	return body, err
+}
+
 func (s *apiServer) getStorageHandler(shard_name string, shard_port int, chunk_name string) string {
-	return "http://" + shard_name + ":" + strconv.Itoa(shard_port) + "/" + chunk_name
+	return s.config.GetStorageURL(shard_name, shard_port, chunk_name)
+}
+
+// deleteChunk removes chunk from its shard, chunk which is already absent is not an error.
+// It's not cancelled with the request, otherwise the chunk could be left on the shard
+func (s *apiServer) deleteChunk(ctx context.Context, chunk *metapb.ChunkFilenameWithShard) error {
+	shard_port := s.config.Shards[chunk.Shard]
+	delete_req, err := http.NewRequestWithContext(context.WithoutCancel(ctx), "DELETE", s.getStorageHandler(chunk.Shard, shard_port, chunk.Filename), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.http_client.Do(delete_req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("shard %s responded with status %s while deleting chunk %s", chunk.Shard, resp.Status, chunk.Filename)
+	}
+	return nil
+}
+
+// deleteUnusedChunks removes chunks which are not referenced by any file, errors are only logged
+func (s *apiServer) deleteUnusedChunks(ctx context.Context, chunks []*metapb.ChunkFilenameWithShard) {
+	for _, chunk := range chunks {
+		err := s.deleteChunk(ctx, chunk)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to delete unused chunk", "chunk", chunk.Filename, "shard", chunk.Shard, "error", err)
+		}
+	}
 }
 
 func main() {
-	log.Println("api service is started")
+	common.InitLogging(common.ApiServiceName)
+	slog.Info("api service is started")
+	ctx, stop := common.ShutdownContext()
+	defer stop()
 	r := mux.NewRouter()
 
 	var api_server apiServer
 	var err error
 	api_server.config = common.ReadConfig()
-	api_server.conn, err = grpc.Dial(api_server.getMetaAddr(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	certs, err := common.NewCertReloader(api_server.config, common.ApiServiceName)
 	if err != nil {
-		log.Fatalf("Failed to dial server: %v", err)
+		common.Fatal("Failed to load certificate", "error", err)
+	}
+	shutdown_tracing, err := common.InitTracing(api_server.config, common.ApiServiceName)
+	if err != nil {
+		common.Fatal("Failed to set up tracing", "error", err)
+	}
+	defer shutdown_tracing(context.Background())
+	api_server.metrics = common.NewMetrics(common.ApiServiceName)
+	// latencies of requests to shards are the latencies of fan-out of chunks
+	api_server.http_client = api_server.metrics.InstrumentShardClient(common.NewHTTPClient(certs, 0))
+	api_server.health_client = common.NewHTTPClient(certs, 0)
+	meta_credentials := insecure.NewCredentials()
+	if certs != nil {
+		meta_credentials = credentials.NewTLS(certs.ClientConfig())
+	}
+	api_server.conn, err = grpc.Dial(api_server.getMetaAddr(), grpc.WithTransportCredentials(meta_credentials),
+		grpc.WithStatsHandler(otelgrpc.NewClientHandler()), grpc.WithUnaryInterceptor(common.RequestIDClientInterceptor))
+	if err != nil {
+		common.Fatal("Failed to dial meta service", "error", err)
 	}
 	defer api_server.conn.Close()
 
 	api_server.grpc_client = metapb.NewApiWithMetaServiceClient(api_server.conn)
 
-	r.HandleFunc("/{bucket}", api_server.createBucket).Methods("POST")
-	r.HandleFunc("/{bucket}", api_server.deleteBucket).Methods("DELETE")
-	r.HandleFunc("/{bucket}", api_server.getFilesFromBucket).Methods("GET")
-	r.HandleFunc("/{bucket}/{file}", api_server.createFile).Methods("POST")
-	r.HandleFunc("/{bucket}/{file}", api_server.deleteFile).Methods("DELETE")
-	r.HandleFunc("/{bucket}/{file}", api_server.getFile).Methods("GET")
+	if api_server.config.Encryption_keyfile != "" {
+		key_manager, err := common.NewLocalKeyManager(api_server.config.Encryption_keyfile)
+		if err != nil {
+			common.Fatal("Failed to open keyfile", "error", err)
+		}
+		api_server.key_manager = key_manager
+	}
 
-	http.ListenAndServe(api_server.getAPIAddr(), r)
+	api_server.limits = newRequestLimits(api_server.config.Limits)
+	go api_server.limits.watchConfig(common.ConfigPath)
+
+	// ids of requests are generated here, ids which are passed by clients are not trusted
+	r.Use(common.LoggingMiddleware(true))
+	r.Use(common.TracingMiddleware)
+	r.Use(api_server.metrics.Middleware)
+	r.Use(api_server.limitSourceIP)
+	r.Use(api_server.authenticate)
+	r.Use(api_server.limitAccessKeyAndBucket)
+	r.Handle(common.MetricsPath, api_server.metrics.Handler()).Methods("GET")
+	common.HandleHealth(r, map[string]common.ReadinessCheck{
+		common.MetaServiceName: common.GrpcHealthCheck(api_server.conn),
+		"shards":               api_server.checkShards,
+	})
+	// files found by tags, jobs and files deleted in batch are checked by their handlers
+	r.HandleFunc("/", api_server.findFiles).Methods("GET").Queries("tags", "{tags}")
+	r.HandleFunc("/", api_server.authenticated(api_server.listBuckets)).Methods("GET")
+	r.HandleFunc("/jobs/{job_id}", api_server.getJob).Methods("GET")
+	r.HandleFunc("/{bucket}", api_server.deleteFiles).Methods("POST").Queries("delete", "")
+	r.HandleFunc("/{bucket}", api_server.authorized(common.ActionAdmin, api_server.previewBucketLifecycle)).Methods("GET", "PUT").Queries("lifecycle", "", "dry-run", "")
+	r.HandleFunc("/{bucket}", api_server.authorized(common.ActionAdmin, api_server.putBucketLifecycle)).Methods("PUT").Queries("lifecycle", "")
+	r.HandleFunc("/{bucket}", api_server.authorized(common.ActionAdmin, api_server.getBucketLifecycle)).Methods("GET").Queries("lifecycle", "")
+	r.HandleFunc("/{bucket}", api_server.authorized(common.ActionAdmin, api_server.deleteBucketLifecycle)).Methods("DELETE").Queries("lifecycle", "")
+	r.HandleFunc("/{bucket}", api_server.authorized(common.ActionAdmin, api_server.putBucketPolicy)).Methods("PUT").Queries("policy", "")
+	r.HandleFunc("/{bucket}", api_server.authorized(common.ActionAdmin, api_server.getBucketPolicy)).Methods("GET").Queries("policy", "")
+	r.HandleFunc("/{bucket}", api_server.authorized(common.ActionAdmin, api_server.deleteBucketPolicy)).Methods("DELETE").Queries("policy", "")
+	r.HandleFunc("/{bucket}", api_server.authenticated(api_server.createBucket)).Methods("POST")
+	r.HandleFunc("/{bucket}", api_server.authorized(common.ActionAdmin, api_server.deleteBucket)).Methods("DELETE")
+	r.HandleFunc("/{bucket}", api_server.authorized(common.ActionList, api_server.getFilesFromBucket)).Methods("GET")
+	r.HandleFunc("/{bucket}", api_server.authorized(common.ActionList, api_server.headBucket)).Methods("HEAD")
+	r.HandleFunc("/{bucket}/{file}", api_server.authorized(common.ActionWrite, api_server.putFileTags)).Methods("PUT").Queries("tagging", "")
+	r.HandleFunc("/{bucket}/{file}", api_server.authenticated(api_server.presignFile)).Methods("GET").Queries("presign", "")
+	r.HandleFunc("/{bucket}/{file}", api_server.authorized(common.ActionRead, api_server.getFileTags)).Methods("GET").Queries("tagging", "")
+	r.HandleFunc("/{bucket}/{file}", api_server.authorized(common.ActionWrite, api_server.deleteFileTags)).Methods("DELETE").Queries("tagging", "")
+	r.HandleFunc("/{bucket}/{file}", api_server.authorized(common.ActionWrite, api_server.restoreFile)).Methods("POST").Queries("restore", "")
+	r.HandleFunc("/{bucket}/{file}", api_server.authorized(common.ActionWrite, api_server.transfer(api_server.createFile))).Methods("POST")
+	r.HandleFunc("/{bucket}/{file}", api_server.authorized(common.ActionWrite, api_server.copyFile)).Methods("PUT").Headers(copySourceHeader, "")
+	r.HandleFunc("/{bucket}/{file}", api_server.authorized(common.ActionWrite, api_server.copyFile)).Methods("PUT").Headers(moveSourceHeader, "")
+	r.HandleFunc("/{bucket}/{file}", api_server.authorized(common.ActionWrite, api_server.transfer(api_server.createFile))).Methods("PUT")
+	r.HandleFunc("/{bucket}/{file}", api_server.authorized(common.ActionDelete, api_server.deleteFile)).Methods("DELETE")
+	r.HandleFunc("/{bucket}/{file}", api_server.authorized(common.ActionRead, api_server.transfer(api_server.getFile))).Methods("GET")
+	r.HandleFunc("/{bucket}/{file}", api_server.authorized(common.ActionRead, api_server.headFile)).Methods("HEAD")
+
+	// api is public, so clients are not asked for certificates, they are authenticated by signatures
+	err = common.ListenAndServe(ctx, api_server.getAPIAddr(), r, api_server.config.ShutdownTimeout(), certs)
+	if ctx.Err() == nil {
+		common.Fatal("Api service failed", "error", err)
+	}
+	// uploads which are not finished leave chunks on shards, they are not referenced by meta service
+	if err != nil {
+		slog.Error("Failed to drain requests", "error", err)
+	}
+	slog.Info("api service is stopped")
 }
@@ -0,0 +1,87 @@
+package main
+
+import (
+	metapb "meta/proto"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// user metadata of file is passed on creation as X-Meta-<Key>: <value> headers and is returned the same way
+const userMetadataHeaderPrefix = "X-Meta-"
+
+// readFileMetadata fills metadata of created file from headers of request
+func readFileMetadata(req *http.Request, req_to_meta *metapb.CreateFileReq) {
+	req_to_meta.ContentEncoding = req.Header.Get("Content-Encoding")
+	req_to_meta.ContentDisposition = req.Header.Get("Content-Disposition")
+	req_to_meta.CacheControl = req.Header.Get("Cache-Control")
+
+	req_to_meta.UserMetadata = make(map[string]string)
+	for header, values := range req.Header {
+		key, found := strings.CutPrefix(header, userMetadataHeaderPrefix)
+		if found && key != "" {
+			req_to_meta.UserMetadata[strings.ToLower(key)] = strings.Join(values, ",")
+		}
+	}
+}
+
+// setFileHeaders must be called before the body of response is written
+func setFileHeaders(w http.ResponseWriter, resp *metapb.GetFileChunksResp) {
+	header := w.Header()
+	if resp.ContentType != "" {
+		header.Set("Content-Type", resp.ContentType)
+	}
+	header.Set("Content-Length", strconv.FormatInt(resp.Size, 10))
+	header.Set("Last-Modified", time.Unix(resp.CreatedAt, 0).UTC().Format(http.TimeFormat))
+	if resp.Etag != "" {
+		header.Set("ETag", `"`+resp.Etag+`"`)
+	}
+	if resp.ContentEncoding != "" {
+		header.Set("Content-Encoding", resp.ContentEncoding)
+	}
+	if resp.ContentDisposition != "" {
+		header.Set("Content-Disposition", resp.ContentDisposition)
+	}
+	if resp.CacheControl != "" {
+		header.Set("Cache-Control", resp.CacheControl)
+	}
+	for key, value := range resp.UserMetadata {
+		header.Set(userMetadataHeaderPrefix+key, value)
+	}
+	if resp.Tier != "" {
+		header.Set(storageTierHeader, resp.Tier)
+	}
+	if resp.RestoredUntil != 0 {
+		header.Set(restoredUntilHeader, time.Unix(resp.RestoredUntil, 0).UTC().Format(http.TimeFormat))
+	}
+	setEncryptionHeaders(header, resp)
+}
+
+// headFile answers only from metadata, shards are not touched
+func (s *apiServer) headFile(w http.ResponseWriter, req *http.Request) {
+	bucket := mux.Vars(req)["bucket"]
+	file := mux.Vars(req)["file"]
+
+	resp, err := s.grpc_client.GetFileChunks(req.Context(), &metapb.GetFileChunksReq{Bucket: bucket, File: file})
+	if err != nil {
+		switch status.Code(err) {
+		case codes.NotFound:
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			writeUnexpectedCode(req.Context(), w, "headFile", err)
+		}
+		return
+	}
+
+	setFileHeaders(w, resp)
+	if precondition_status := checkReadPreconditions(req, resp); precondition_status != 0 {
+		w.WriteHeader(precondition_status)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"common"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	metapb "meta/proto"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// as in S3, policy of bucket must not be larger than 20 KB
+const maxPolicySize = 20 << 10
+
+// bucketAccess checks that the identity of the request may perform action on the file of the bucket whose info
+// is already fetched, file is empty for actions on the whole bucket. Tags of the file are fetched only if the policy
+// has conditions on them. On error http status is returned too
+func (s *apiServer) bucketAccess(req *http.Request, info *metapb.BucketInfo, file, action string) (int, error) {
+	policy, err := common.ParsePolicy(info.Policy)
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("policy of bucket %s is broken: %v", info.Bucket, err)
+	}
+
+	var tags map[string]string
+	if file != "" && policy.HasTagConditions() {
+		resp, err := s.grpc_client.GetFileTags(req.Context(), &metapb.GetFileTagsReq{Bucket: info.Bucket, File: file})
+		if err != nil && status.Code(err) != codes.NotFound {
+			return unexpectedError(req.Context(), "bucketAccess", err)
+		}
+		// file which does not exist yet has no tags
+		tags = resp.GetTags()
+	}
+
+	identity := requestIdentity(req)
+	if policy.IsAllowed(info.Owner, identity, action, file, tags) {
+		return 0, nil
+	}
+	who := "anonymous user"
+	if identity.User != "" {
+		who = "user " + identity.User
+	}
+	if file != "" {
+		return http.StatusForbidden, fmt.Errorf("%s is not allowed to %s file %s in bucket %s", who, action, file, info.Bucket)
+	}
+	return http.StatusForbidden, fmt.Errorf("%s is not allowed to %s bucket %s", who, action, info.Bucket)
+}
+
+// bucketForAccess fetches info of the bucket to check access to it, nil info means that auth is disabled
+// and everything is allowed. On error http status is returned too
+func (s *apiServer) bucketForAccess(ctx context.Context, bucket string) (*metapb.BucketInfo, int, error) {
+	if !s.config.Auth {
+		return nil, 0, nil
+	}
+
+	resp, err := s.grpc_client.GetBucket(ctx, &metapb.GetBucketReq{Bucket: bucket})
+	if err != nil {
+		switch status.Code(err) {
+		case codes.NotFound:
+			return nil, http.StatusNotFound, err
+		default:
+			error_status, err := unexpectedError(ctx, "bucketForAccess", err)
+			return nil, error_status, err
+		}
+	}
+	return resp.Bucket, 0, nil
+}
+
+// checkAccess is like bucketAccess, but fetches info of the bucket itself. Everything is allowed if auth is disabled
+func (s *apiServer) checkAccess(req *http.Request, bucket, file, action string) (int, error) {
+	info, error_status, err := s.bucketForAccess(req.Context(), bucket)
+	if err != nil || info == nil {
+		return error_status, err
+	}
+	return s.bucketAccess(req, info, file, action)
+}
+
+// authorized lets the request through only if its identity may perform action on the bucket
+// (and the file, if the route has it). Policies are checked before meta service is asked to do anything
+func (s *apiServer) authorized(action string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		error_status, err := s.checkAccess(req, mux.Vars(req)["bucket"], mux.Vars(req)["file"], action)
+		if err != nil {
+			w.WriteHeader(error_status)
+			fmt.Fprintf(w, "Access denied: %v\n", err)
+			return
+		}
+		handler(w, req)
+	}
+}
+
+// authenticated lets through only signed requests, it is used for routes which are not related to one bucket
+func (s *apiServer) authenticated(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if s.config.Auth && requestUser(req) == "" {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprintln(w, "Access denied: request must be signed")
+			return
+		}
+		handler(w, req)
+	}
+}
+
+// listableBuckets returns buckets which files the identity of the request may list, nil means that all
+// buckets are allowed. If bucket is passed, only access to it is checked. On error http status is returned too
+func (s *apiServer) listableBuckets(req *http.Request, bucket string) (map[string]bool, int, error) {
+	if !s.config.Auth {
+		return nil, 0, nil
+	}
+	if bucket != "" {
+		error_status, err := s.checkAccess(req, bucket, "", common.ActionList)
+		if err != nil {
+			return nil, error_status, err
+		}
+		return map[string]bool{bucket: true}, 0, nil
+	}
+
+	resp, err := s.grpc_client.ListBuckets(req.Context(), &metapb.ListBucketsReq{})
+	if err != nil {
+		error_status, err := unexpectedError(req.Context(), "listableBuckets", err)
+		return nil, error_status, err
+	}
+	allowed := make(map[string]bool)
+	for _, info := range resp.Buckets {
+		if error_status, _ := s.bucketAccess(req, info, "", common.ActionList); error_status == 0 {
+			allowed[info.Bucket] = true
+		}
+	}
+	return allowed, 0, nil
+}
+
+func (s *apiServer) putBucketPolicy(w http.ResponseWriter, req *http.Request) {
+	bucket := mux.Vars(req)["bucket"]
+
+	body, err := io.ReadAll(io.LimitReader(req.Body, maxPolicySize+1))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Received unexpected error while reading data from request: %s\n", err)
+		return
+	}
+	if len(body) > maxPolicySize {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		fmt.Fprintf(w, "Policy must not be larger than %d bytes\n", maxPolicySize)
+		return
+	}
+	if len(body) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(w, "Policy must be non-empty, use DELETE to remove policy of bucket")
+		return
+	}
+
+	s.setBucketPolicy(req.Context(), w, bucket, string(body))
+}
+
+func (s *apiServer) deleteBucketPolicy(w http.ResponseWriter, req *http.Request) {
+	s.setBucketPolicy(req.Context(), w, mux.Vars(req)["bucket"], "")
+}
+
+func (s *apiServer) setBucketPolicy(ctx context.Context, w http.ResponseWriter, bucket, policy string) {
+	_, err := s.grpc_client.PutBucketPolicy(ctx, &metapb.PutBucketPolicyReq{Bucket: bucket, Policy: policy})
+	if err != nil {
+		switch status.Code(err) {
+		case codes.NotFound:
+			w.WriteHeader(http.StatusNotFound)
+		case codes.InvalidArgument:
+			w.WriteHeader(http.StatusBadRequest)
+		default:
+			writeUnexpectedCode(ctx, w, "setBucketPolicy", err)
+			return
+		}
+		fmt.Fprintf(w, "Received error: %v\n", err)
+		return
+	}
+
+	if policy == "" {
+		fmt.Fprintf(w, "Successfully deleted policy of bucket %s\n", bucket)
+		slog.InfoContext(ctx, "Delete policy of bucket", "bucket", bucket)
+		return
+	}
+	fmt.Fprintf(w, "Successfully put policy of bucket %s\n", bucket)
+	slog.InfoContext(ctx, "Put policy of bucket", "bucket", bucket)
+}
+
+func (s *apiServer) getBucketPolicy(w http.ResponseWriter, req *http.Request) {
+	bucket := mux.Vars(req)["bucket"]
+
+	resp, err := s.grpc_client.GetBucket(req.Context(), &metapb.GetBucketReq{Bucket: bucket})
+	if err != nil {
+		switch status.Code(err) {
+		case codes.NotFound:
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			writeUnexpectedCode(req.Context(), w, "getBucketPolicy", err)
+			return
+		}
+		fmt.Fprintf(w, "Received error: %v\n", err)
+		return
+	}
+
+	if resp.Bucket.Policy == "" {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "Bucket %s has no policy\n", bucket)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	io.WriteString(w, resp.Bucket.Policy)
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"common"
+	"crypto/hmac"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// presigned URLs carry the signature in query parameters, as S3 presigned URLs do, so they can be
+// generated by S3 SDKs too. Body of request made by presigned URL is not signed
+const (
+	amzAlgorithmParam     = "X-Amz-Algorithm"
+	amzCredentialParam    = "X-Amz-Credential"
+	amzDateParam          = "X-Amz-Date"
+	amzExpiresParam       = "X-Amz-Expires"
+	amzSignedHeadersParam = "X-Amz-SignedHeaders"
+	amzSignatureParam     = "X-Amz-Signature"
+	// region is not checked, so URLs generated by api service are scoped to the default region of AWS
+	presignRegion         = "us-east-1"
+	defaultPresignExpires = time.Hour
+	// as in S3, presigned URL can't be valid for more than a week
+	maxPresignExpires = 7 * 24 * time.Hour
+)
+
+// methods which URLs can be presigned for and actions they need
+var presignActions = map[string]string{
+	http.MethodGet:  common.ActionRead,
+	http.MethodHead: common.ActionRead,
+	http.MethodPut:  common.ActionWrite,
+}
+
+// presignURL signs request with method to the path for expires starting from now
+func presignURL(scheme, host, path, method, access_key_id, secret_access_key string, expires time.Duration, now time.Time) string {
+	now = now.UTC()
+	credential := sigV4Credential{access_key_id: access_key_id, date: now.Format(amzDateFormat)[:credentialScopeDateSize], region: presignRegion, service: sigV4Service}
+	amz_date := now.Format(amzDateFormat)
+	query := url.Values{
+		amzAlgorithmParam:     {sigV4Algorithm},
+		amzCredentialParam:    {access_key_id + "/" + credential.scope()},
+		amzDateParam:          {amz_date},
+		amzExpiresParam:       {strconv.FormatInt(int64(expires/time.Second), 10)},
+		amzSignedHeadersParam: {"host"},
+	}
+	canonical_query := canonicalQuery(query)
+
+	req := &http.Request{Method: method, URL: &url.URL{Path: path}, Host: host}
+	string_to_sign := stringToSign(req, credential, []string{"host"}, amz_date, canonical_query, unsignedPayload)
+	signature := computeSignature(secret_access_key, credential, string_to_sign)
+
+	return scheme + "://" + host + uriEncode(path, false) + "?" + canonical_query + "&" + amzSignatureParam + "=" + signature
+}
+
+// verifyPresignedURL is like verifySignature, but for requests signed in query. On error http status is returned too
+func (s *apiServer) verifyPresignedURL(req *http.Request) (common.Identity, string, int, error) {
+	query := req.URL.Query()
+	if query.Get(amzAlgorithmParam) != sigV4Algorithm {
+		return common.Identity{}, "", http.StatusBadRequest, fmt.Errorf("only %s signatures are supported", sigV4Algorithm)
+	}
+	credential, err := parseCredential(query.Get(amzCredentialParam))
+	if err != nil {
+		return common.Identity{}, "", http.StatusBadRequest, err
+	}
+	signed_headers := strings.Split(query.Get(amzSignedHeadersParam), ";")
+	if !sort.StringsAreSorted(signed_headers) || !containsString(signed_headers, "host") {
+		return common.Identity{}, "", http.StatusBadRequest, errors.New(amzSignedHeadersParam + " must be sorted and include host")
+	}
+	expires, err := strconv.ParseInt(query.Get(amzExpiresParam), 10, 64)
+	if err != nil || expires <= 0 || time.Duration(expires)*time.Second > maxPresignExpires {
+		return common.Identity{}, "", http.StatusBadRequest, fmt.Errorf("%s must be a number of seconds from 1 to %d", amzExpiresParam, int64(maxPresignExpires/time.Second))
+	}
+
+	amz_date := query.Get(amzDateParam)
+	error_status, err := checkRequestTime(amz_date, credential, time.Duration(expires)*time.Second)
+	if err != nil {
+		if error_status == http.StatusForbidden {
+			err = errors.New("presigned URL has expired")
+		}
+		return common.Identity{}, "", error_status, err
+	}
+
+	secret_access_key, identity, error_status, err := s.lookupSecret(req.Context(), credential.access_key_id)
+	if err != nil {
+		return common.Identity{}, "", error_status, err
+	}
+	signature := query.Get(amzSignatureParam)
+	query.Del(amzSignatureParam)
+	string_to_sign := stringToSign(req, credential, signed_headers, amz_date, canonicalQuery(query), unsignedPayload)
+	if !hmac.Equal([]byte(computeSignature(secret_access_key, credential, string_to_sign)), []byte(signature)) {
+		return common.Identity{}, "", http.StatusForbidden, errors.New("signature of presigned URL does not match")
+	}
+	return identity, credential.access_key_id, 0, nil
+}
+
+// presignFile handles GET /<bucket>/<file>?presign[&method=<GET|HEAD|PUT>][&expires=<seconds>]. The URL is signed
+// with the same access key as the request, so it stops working once the key is revoked, and policies of bucket
+// are applied to the user when the URL is used
+func (s *apiServer) presignFile(w http.ResponseWriter, req *http.Request) {
+	bucket := mux.Vars(req)["bucket"]
+	file := mux.Vars(req)["file"]
+	query := req.URL.Query()
+
+	if !s.config.Auth {
+		w.WriteHeader(http.StatusNotImplemented)
+		fmt.Fprintln(w, "Auth is disabled in config, so URLs can't be presigned and anyone may access files anyway")
+		return
+	}
+
+	method := http.MethodGet
+	if query.Has("method") {
+		method = strings.ToUpper(query.Get("method"))
+	}
+	action, ok := presignActions[method]
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Only GET, HEAD and PUT URLs can be presigned, got %q\n", query.Get("method"))
+		return
+	}
+	expires := defaultPresignExpires
+	if query.Has("expires") {
+		seconds, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+		if err != nil || seconds <= 0 || time.Duration(seconds)*time.Second > maxPresignExpires {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "Expires must be a number of seconds from 1 to %d, got %q\n", int64(maxPresignExpires/time.Second), query.Get("expires"))
+			return
+		}
+		expires = time.Duration(seconds) * time.Second
+	}
+
+	// it's checked now to not hand out URLs which won't work, but it's checked again when the URL is used
+	error_status, err := s.checkAccess(req, bucket, file, action)
+	if err != nil {
+		w.WriteHeader(error_status)
+		fmt.Fprintf(w, "Access denied: %v\n", err)
+		return
+	}
+	access_key_id := requestAccessKey(req)
+	secret_access_key, _, error_status, err := s.lookupSecret(req.Context(), access_key_id)
+	if err != nil {
+		w.WriteHeader(error_status)
+		fmt.Fprintf(w, "Received error: %v\n", err)
+		return
+	}
+
+	scheme := "http"
+	if req.TLS != nil {
+		scheme = "https"
+	}
+	fmt.Fprintln(w, presignURL(scheme, req.Host, "/"+bucket+"/"+file, method, access_key_id, secret_access_key, expires, time.Now()))
+	slog.InfoContext(req.Context(), "Presigned URL", "method", method, "bucket", bucket, "file", file, "user", requestUser(req), "expires_in", expires.String())
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	metapb "meta/proto"
+	"net/http"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// unlimitedHeadroom is returned by meta service if there is no quota
+const unlimitedHeadroom = -1
+
+// uploadHeadroom returns how many bytes may be uploaded into the file according to quotas of the bucket and its owner.
+// Upload is rejected before its chunks are written if it does not fit by Content-Length. On error http status is returned too
+func (s *apiServer) uploadHeadroom(req *http.Request, bucket, file string) (int64, int, error) {
+	resp, err := s.grpc_client.GetQuotaHeadroom(req.Context(), &metapb.GetQuotaHeadroomReq{Bucket: bucket, File: file})
+	if err != nil {
+		switch status.Code(err) {
+		case codes.NotFound:
+			return 0, http.StatusNotFound, err
+		default:
+			error_status, err := unexpectedError(req.Context(), "uploadHeadroom", err)
+			return 0, error_status, err
+		}
+	}
+
+	if resp.Objects == 0 {
+		return 0, http.StatusInsufficientStorage, fmt.Errorf("quota of objects in bucket %s or of its owner is exhausted", bucket)
+	}
+	if resp.Bytes != unlimitedHeadroom && req.ContentLength > resp.Bytes {
+		return 0, http.StatusInsufficientStorage, fmt.Errorf("file of %d bytes does not fit into quota, only %d bytes are left", req.ContentLength, resp.Bytes)
+	}
+	return resp.Bytes, 0, nil
+}
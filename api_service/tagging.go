@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log/slog"
+	metapb "meta/proto"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// tags of uploaded file are passed like x-amz-tagging: k1=v1&k2=v2
+	taggingHeader = "X-Tagging"
+	// 10 tags of maximal length fit into this limit with a big margin
+	maxTaggingRequestSize = 64 << 10
+)
+
+type fileTag struct {
+	Key   string `xml:"Key" json:"key"`
+	Value string `xml:"Value" json:"value"`
+}
+
+// tagging is compatible with body of S3 PutObjectTagging request and GetObjectTagging response,
+// the same structure is accepted in JSON
+type tagging struct {
+	XMLName xml.Name  `xml:"Tagging" json:"-"`
+	TagSet  []fileTag `xml:"TagSet>Tag" json:"tag_set"`
+}
+
+func parseTaggingHeader(value string) (map[string]string, error) {
+	tags := make(map[string]string)
+	if value == "" {
+		return tags, nil
+	}
+
+	query, err := url.ParseQuery(value)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range query {
+		if len(values) > 1 {
+			return nil, fmt.Errorf("tag %s is set more than once", key)
+		}
+		tags[key] = values[0]
+	}
+	return tags, nil
+}
+
+func writeTagsError(w http.ResponseWriter, req *http.Request, handler string, err error) {
+	switch status.Code(err) {
+	case codes.NotFound:
+		w.WriteHeader(http.StatusNotFound)
+	case codes.InvalidArgument:
+		w.WriteHeader(http.StatusBadRequest)
+	default:
+		writeUnexpectedCode(req.Context(), w, handler, err)
+		return
+	}
+	fmt.Fprintf(w, "Received error: %v\n", err)
+}
+
+// putFileTags replaces all tags of the file, like S3 PutObjectTagging does
+func (s *apiServer) putFileTags(w http.ResponseWriter, req *http.Request) {
+	bucket := mux.Vars(req)["bucket"]
+	file := mux.Vars(req)["file"]
+
+	body, err := io.ReadAll(io.LimitReader(req.Body, maxTaggingRequestSize+1))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Received unexpected error while reading data from request: %s\n", err)
+		return
+	}
+	if len(body) > maxTaggingRequestSize {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		fmt.Fprintf(w, "Body of request must not be larger than %d bytes\n", maxTaggingRequestSize)
+		return
+	}
+
+	var tagging_req tagging
+	if isXMLRequest(req, body) {
+		err = xml.Unmarshal(body, &tagging_req)
+	} else {
+		err = json.Unmarshal(body, &tagging_req)
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Malformed body of request: %v\n", err)
+		return
+	}
+
+	tags := make(map[string]string, len(tagging_req.TagSet))
+	for _, tag := range tagging_req.TagSet {
+		if _, exists := tags[tag.Key]; exists {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "Tag %s is set more than once\n", tag.Key)
+			return
+		}
+		tags[tag.Key] = tag.Value
+	}
+
+	_, err = s.grpc_client.PutFileTags(req.Context(), &metapb.PutFileTagsReq{Bucket: bucket, File: file, Tags: tags})
+	if err != nil {
+		writeTagsError(w, req, "putFileTags", err)
+		return
+	}
+
+	fmt.Fprintf(w, "Successfully set %d tags of file %s in bucket %s\n", len(tags), file, bucket)
+	slog.InfoContext(req.Context(), "Put tags of file", "bucket", bucket, "file", file)
+}
+
+// getFileTags returns tags in XML if it is accepted by client and in JSON otherwise
+func (s *apiServer) getFileTags(w http.ResponseWriter, req *http.Request) {
+	bucket := mux.Vars(req)["bucket"]
+	file := mux.Vars(req)["file"]
+
+	resp, err := s.grpc_client.GetFileTags(req.Context(), &metapb.GetFileTagsReq{Bucket: bucket, File: file})
+	if err != nil {
+		writeTagsError(w, req, "getFileTags", err)
+		return
+	}
+
+	result := tagging{TagSet: make([]fileTag, 0, len(resp.Tags))}
+	for key, value := range resp.Tags {
+		result.TagSet = append(result.TagSet, fileTag{Key: key, Value: value})
+	}
+	sort.Slice(result.TagSet, func(i, j int) bool { return result.TagSet[i].Key < result.TagSet[j].Key })
+
+	if strings.Contains(req.Header.Get("Accept"), "xml") {
+		w.Header().Set("Content-Type", "application/xml")
+		io.WriteString(w, xml.Header)
+		xml.NewEncoder(w).Encode(result)
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	}
+	slog.InfoContext(req.Context(), "Served tags of file", "bucket", bucket, "file", file)
+}
+
+func (s *apiServer) deleteFileTags(w http.ResponseWriter, req *http.Request) {
+	bucket := mux.Vars(req)["bucket"]
+	file := mux.Vars(req)["file"]
+
+	_, err := s.grpc_client.PutFileTags(req.Context(), &metapb.PutFileTagsReq{Bucket: bucket, File: file})
+	if err != nil {
+		writeTagsError(w, req, "deleteFileTags", err)
+		return
+	}
+
+	fmt.Fprintf(w, "Successfully deleted tags of file %s in bucket %s\n", file, bucket)
+	slog.InfoContext(req.Context(), "Delete tags of file", "bucket", bucket, "file", file)
+}
+
+// findFiles handles GET /?tags=<expression>[&bucket=<bucket>][&limit=<n>]
+func (s *apiServer) findFiles(w http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+
+	req_to_meta := &metapb.FindFilesReq{Expression: query.Get("tags"), Bucket: query.Get("bucket")}
+	if query.Has("limit") {
+		limit, err := strconv.ParseInt(query.Get("limit"), 10, 32)
+		if err != nil || limit <= 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "Limit must be a positive number, got %q\n", query.Get("limit"))
+			return
+		}
+		req_to_meta.Limit = int32(limit)
+	}
+
+	allowed_buckets, error_status, err := s.listableBuckets(req, req_to_meta.Bucket)
+	if err != nil {
+		w.WriteHeader(error_status)
+		fmt.Fprintf(w, "Access denied: %v\n", err)
+		return
+	}
+	// meta service filters files before it applies the limit, so pages are full while there are files to read
+	if allowed_buckets != nil {
+		req_to_meta.Restricted = true
+		for bucket := range allowed_buckets {
+			req_to_meta.AllowedBuckets = append(req_to_meta.AllowedBuckets, bucket)
+		}
+	}
+
+	resp, err := s.grpc_client.FindFiles(req.Context(), req_to_meta)
+	if err != nil {
+		writeTagsError(w, req, "findFiles", err)
+		return
+	}
+
+	slog.InfoContext(req.Context(), "Served findFiles response", "expression", req_to_meta.Expression)
+	fmt.Fprintf(w, "Found %d files:\n", len(resp.Files))
+	for _, file := range resp.Files {
+		tags := make(url.Values, len(file.Tags))
+		for key, value := range file.Tags {
+			tags.Set(key, value)
+		}
+		fmt.Fprintf(w, "> %s/%s (tags: %s)\n", file.Bucket, file.File, tags.Encode())
+	}
+	if resp.Truncated {
+		fmt.Fprintln(w, "Result is truncated, pass bigger limit or more specific expression to see the rest")
+	}
+}
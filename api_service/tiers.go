@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	metapb "meta/proto"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// like x-amz-storage-class, tier is chosen on upload and returned by GET and HEAD
+	storageTierHeader   = "X-Storage-Tier"
+	restoredUntilHeader = "X-Restored-Until"
+)
+
+// restoreFile handles POST /{bucket}/{file}?restore[&days=N]. Chunks of archived file are moved
+// into the default tier before the response is sent
+func (s *apiServer) restoreFile(w http.ResponseWriter, req *http.Request) {
+	bucket := mux.Vars(req)["bucket"]
+	file := mux.Vars(req)["file"]
+
+	req_to_meta := &metapb.RestoreFileReq{Bucket: bucket, File: file}
+	if days := req.URL.Query().Get("days"); days != "" {
+		parsed, err := strconv.ParseInt(days, 10, 32)
+		if err != nil || parsed <= 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "Days must be a positive number, got %q\n", days)
+			return
+		}
+		req_to_meta.Days = int32(parsed)
+	}
+
+	resp, err := s.grpc_client.RestoreFile(req.Context(), req_to_meta)
+	if err != nil {
+		switch status.Code(err) {
+		case codes.NotFound:
+			w.WriteHeader(http.StatusNotFound)
+		case codes.InvalidArgument:
+			w.WriteHeader(http.StatusBadRequest)
+		case codes.FailedPrecondition:
+			w.WriteHeader(http.StatusConflict)
+		case codes.Aborted:
+			w.WriteHeader(http.StatusConflict)
+		default:
+			writeUnexpectedCode(req.Context(), w, "restoreFile", err)
+			return
+		}
+		fmt.Fprintf(w, "Received error: %v\n", err)
+		return
+	}
+
+	restored_until := time.Unix(resp.RestoredUntil, 0).UTC()
+	w.Header().Set(restoredUntilHeader, restored_until.Format(http.TimeFormat))
+	fmt.Fprintf(w, "Successfully restored file %s in bucket %s till %s\n", file, bucket, restored_until.Format(time.RFC3339))
+	slog.InfoContext(req.Context(), "Restore file", "bucket", bucket, "file", file)
+}
@@ -1,9 +1,13 @@
 package common
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"os"
 	"strconv"
+	"strings"
 )
 
 const (
@@ -16,35 +20,133 @@ type Config struct {
 	Meta_port  int            `json:"meta_port"`
 	Stat_port  int            `json:"stat_port"`
 	Shards     map[string]int `json:"storage_port"`
+	// meta service serves only gRPC on meta_port, so its metrics are served on this port,
+	// other services serve them at /metrics on their ports
+	Meta_metrics_port int `json:"meta_metrics_port"`
+	// tiers are optional, without them all shards form one tier
+	Tiers        map[string]Tier `json:"tiers"`
+	Default_tier string          `json:"default_tier"`
+	// master keys which data keys of encrypted files are wrapped with, encryption by keys of
+	// the api service is disabled without it
+	Encryption_keyfile string `json:"encryption_keyfile"`
+	// all requests to the api service must be signed with access keys if auth is enabled
+	Auth bool `json:"auth"`
+	// services talk to each other over mutual TLS if it is set
+	Tls TLSConfig `json:"tls"`
+	// limits of requests to the api service, they are reloaded without restart
+	Limits Limits `json:"limits"`
+	// spans of requests are exported if it is set
+	Tracing TracingConfig `json:"tracing"`
+	// usage of the cluster is sampled by stat service if it is set
+	History HistoryConfig `json:"history"`
+	// requests in flight are drained for this long on shutdown, 25 seconds if it's not set
+	Shutdown_timeout_seconds int `json:"shutdown_timeout_seconds"`
 }
 
+// ConfigPath is relative to the working directory of services
+const ConfigPath = "../config.json"
+
 func ReadConfig() Config {
+	config, err := LoadConfig(ConfigPath)
+	if err != nil {
+		panic(err.Error())
+	}
+	return config
+}
+
+// LoadConfig is like ReadConfig, but returns error instead of panic, so config can be reloaded
+// by running services
+func LoadConfig(config_path string) (Config, error) {
 	var config Config
-	config_path := "../config.json"
-	fd, err := os.Open(config_path)
+	config_raw, err := os.ReadFile(config_path)
 	if err != nil {
-		panic("can't open config file")
+		return config, errors.New("can't open config file")
 	}
-	defer fd.Close()
 
-	file_info, err := fd.Stat()
+	err = json.Unmarshal(config_raw, &config)
 	if err != nil {
-		panic("can't get stat from config file")
+		return config, errors.New("can't read config file")
 	}
 
-	config_raw := make([]byte, file_info.Size())
-	_, err = fd.Read(config_raw)
+	err = config.validateTiers()
+	if err != nil {
+		return config, errors.New("invalid tiers in config file: " + err.Error())
+	}
+	err = config.Limits.validate()
 	if err != nil {
-		panic("can't read config file")
+		return config, errors.New("invalid limits in config file: " + err.Error())
 	}
+	err = config.Tracing.validate()
+	if err != nil {
+		return config, errors.New("invalid tracing in config file: " + err.Error())
+	}
+	err = config.History.validate()
+	if err != nil {
+		return config, errors.New("invalid history in config file: " + err.Error())
+	}
+	err = config.validateShutdown()
+	if err != nil {
+		return config, errors.New("invalid shutdown timeout in config file: " + err.Error())
+	}
+	return config, nil
+}
 
-	err = json.Unmarshal(config_raw, &config)
+// NewObjectID returns random id which is used as prefix of names of all chunks of one object,
+// so the object can be renamed without touching its chunks
+func NewObjectID() string {
+	id := make([]byte, 16)
+	_, err := rand.Read(id)
 	if err != nil {
-		panic("can't read config file")
+		panic("can't generate random object id")
 	}
-	return config
+	return hex.EncodeToString(id)
 }
 
-func GetChunkName(bucket, file string, seqnum int) string {
-	return bucket + "_" + strconv.Itoa(seqnum) + "_" + file
+func GetChunkName(object_id string, seqnum int) string {
+	return object_id + "_" + strconv.Itoa(seqnum)
+}
+
+// ETagMatches checks whether etag is listed in value of If-Match or If-None-Match header.
+// "*" matches any etag, weak validators are compared as strong ones
+func ETagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		candidate = strings.Trim(strings.TrimPrefix(candidate, "W/"), `"`)
+		if candidate != "" && candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// GetServiceURL is the base url of service which is reachable by its host name in docker network
+func (c Config) GetServiceURL(host string, port int) string {
+	return c.Scheme() + "://" + host + ":" + strconv.Itoa(port)
+}
+
+func (c Config) GetStorageURL(shard_name string, shard_port int, chunk_name string) string {
+	return c.GetServiceURL(shard_name, shard_port) + "/" + chunk_name
+}
+
+// codecs which chunks can be compressed with, empty codec means that chunks are stored raw
+const (
+	CodecZstd = "zstd"
+	CodecGzip = "gzip"
+)
+
+func IsKnownCodec(codec string) bool {
+	return codec == "" || codec == CodecZstd || codec == CodecGzip
+}
+
+// server-side encryption of chunks, empty encryption means that chunks are stored in plaintext
+const (
+	EncryptionAES256   = "AES256"
+	EncryptionCustomer = "SSE-C"
+)
+
+func IsKnownEncryption(encryption string) bool {
+	return encryption == "" || encryption == EncryptionAES256 || encryption == EncryptionCustomer
 }
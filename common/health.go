@@ -0,0 +1,126 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// HealthzPath answers while the process is alive, ReadyzPath answers with 200 only if dependencies
+// of the service work, so it can take requests
+const (
+	HealthzPath = "/healthz"
+	ReadyzPath  = "/readyz"
+)
+
+// readinessTimeout bounds all checks of one readiness request, health checkers give up quickly
+const readinessTimeout = 3 * time.Second
+
+// ReadinessCheck checks one dependency of the service, nil means that the dependency works
+type ReadinessCheck func(ctx context.Context) error
+
+type CheckResult struct {
+	Ok    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// Readiness is the response of ReadyzPath
+type Readiness struct {
+	Ready  bool                   `json:"ready"`
+	Checks map[string]CheckResult `json:"checks"`
+}
+
+// CheckReadiness runs checks in parallel, the service is ready if all of them pass
+func CheckReadiness(ctx context.Context, checks map[string]ReadinessCheck) Readiness {
+	// dependencies are checked every few seconds, so their checks are not traced
+	ctx, cancel := context.WithTimeout(untracedContext(ctx), readinessTimeout)
+	defer cancel()
+
+	readiness := Readiness{Ready: true, Checks: make(map[string]CheckResult, len(checks))}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, check := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := check(ctx)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				readiness.Ready = false
+				readiness.Checks[name] = CheckResult{Error: err.Error()}
+			} else {
+				readiness.Checks[name] = CheckResult{Ok: true}
+			}
+		}()
+	}
+	wg.Wait()
+	return readiness
+}
+
+// HandleHealth registers HealthzPath and ReadyzPath, readiness runs checks on every request
+func HandleHealth(r *mux.Router, checks map[string]ReadinessCheck) {
+	r.HandleFunc(HealthzPath, func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprintln(w, "ok")
+	}).Methods("GET", "HEAD")
+	r.HandleFunc(ReadyzPath, func(w http.ResponseWriter, req *http.Request) {
+		readiness := CheckReadiness(req.Context(), checks)
+		w.Header().Set("Content-Type", "application/json")
+		if !readiness.Ready {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(readiness)
+	}).Methods("GET", "HEAD")
+}
+
+// GrpcHealthCheck checks over gRPC health protocol that the service behind conn is serving
+func GrpcHealthCheck(conn grpc.ClientConnInterface) ReadinessCheck {
+	client := healthpb.NewHealthClient(conn)
+	return func(ctx context.Context) error {
+		resp, err := client.Check(ctx, &healthpb.HealthCheckRequest{})
+		if err != nil {
+			return err
+		}
+		if resp.Status != healthpb.HealthCheckResponse_SERVING {
+			return fmt.Errorf("service is %s", resp.Status)
+		}
+		return nil
+	}
+}
+
+// RemoteReadiness asks the service at base_url whether it's ready, error means that the service
+// didn't answer
+func RemoteReadiness(ctx context.Context, client *http.Client, base_url string) (Readiness, error) {
+	var readiness Readiness
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, base_url+ReadyzPath, nil)
+	if err != nil {
+		return readiness, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return readiness, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusServiceUnavailable {
+		return readiness, fmt.Errorf("service responded with status %s", resp.Status)
+	}
+	err = json.NewDecoder(resp.Body).Decode(&readiness)
+	if err != nil {
+		return readiness, fmt.Errorf("invalid readiness of service: %v", err)
+	}
+	return readiness, nil
+}
+
+// isProbe tells requests of health checkers and Prometheus apart, they come every few seconds and
+// are not worth logging or tracing
+func isProbe(req *http.Request) bool {
+	return req.URL.Path == MetricsPath || req.URL.Path == HealthzPath || req.URL.Path == ReadyzPath
+}
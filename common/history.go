@@ -0,0 +1,21 @@
+package common
+
+import "errors"
+
+// HistoryConfig sets how stat service samples usage of shards and buckets to keep its history,
+// history is not collected if interval is not set
+type HistoryConfig struct {
+	Interval_seconds int `json:"interval_seconds"`
+	// older samples are dropped, 30 days if it's not set
+	Retention_days int `json:"retention_days"`
+	// file which samples are appended to, relative to the working directory of stat service,
+	// history.jsonl if it's not set
+	Path string `json:"path"`
+}
+
+func (c HistoryConfig) validate() error {
+	if c.Interval_seconds < 0 || c.Retention_days < 0 {
+		return errors.New("interval_seconds and retention_days must not be negative")
+	}
+	return nil
+}
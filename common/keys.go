@@ -0,0 +1,246 @@
+package common
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DataKeySize is the size of AES-256 keys, both data keys of files and master keys
+const DataKeySize = 32
+
+// KeyManager wraps data keys of files with master keys. Master keys never leave the key manager,
+// so it may be backed by a local keyfile as well as by an external KMS
+type KeyManager interface {
+	// CurrentKeyID returns version of master key which new data keys are wrapped with
+	CurrentKeyID() (string, error)
+	WrapKey(key_id string, data_key []byte) ([]byte, error)
+	UnwrapKey(key_id string, wrapped_key []byte) ([]byte, error)
+}
+
+// keyfile contains all versions of master key, old versions are kept to unwrap data keys
+// which were not rewrapped yet
+type keyfile struct {
+	Current string            `json:"current"`
+	Keys    map[string]string `json:"keys"`
+}
+
+// LocalKeyManager keeps master keys in a json keyfile. The keyfile is reread when it changes,
+// so keys rotated by the admin tool are picked up without restart
+type LocalKeyManager struct {
+	path string
+
+	mutex    sync.Mutex
+	mod_time time.Time
+	current  string
+	keys     map[string][]byte
+}
+
+// NewLocalKeyManager opens the keyfile, it is created with one random master key if it does not exist
+func NewLocalKeyManager(path string) (*LocalKeyManager, error) {
+	_, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		_, err = RotateKeyfile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	manager := &LocalKeyManager{path: path}
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	err = manager.reload()
+	if err != nil {
+		return nil, err
+	}
+	return manager, nil
+}
+
+// reload must be called under the mutex
+func (m *LocalKeyManager) reload() error {
+	file_info, err := os.Stat(m.path)
+	if err != nil {
+		return err
+	}
+	if m.keys != nil && file_info.ModTime().Equal(m.mod_time) {
+		return nil
+	}
+
+	content, err := readKeyfile(m.path)
+	if err != nil {
+		return err
+	}
+	keys := make(map[string][]byte, len(content.Keys))
+	for key_id, encoded := range content.Keys {
+		keys[key_id], err = base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(keys[key_id]) != DataKeySize {
+			return fmt.Errorf("master key %s in keyfile %s is not a base64-encoded %d-byte key", key_id, m.path, DataKeySize)
+		}
+	}
+	if _, found := keys[content.Current]; !found {
+		return fmt.Errorf("current master key %q is absent in keyfile %s", content.Current, m.path)
+	}
+
+	m.mod_time = file_info.ModTime()
+	m.current = content.Current
+	m.keys = keys
+	return nil
+}
+
+func (m *LocalKeyManager) getKey(key_id string) ([]byte, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	err := m.reload()
+	if err != nil {
+		return nil, err
+	}
+	key, found := m.keys[key_id]
+	if !found {
+		return nil, fmt.Errorf("master key %q is absent in keyfile %s", key_id, m.path)
+	}
+	return key, nil
+}
+
+func (m *LocalKeyManager) CurrentKeyID() (string, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	err := m.reload()
+	if err != nil {
+		return "", err
+	}
+	return m.current, nil
+}
+
+func (m *LocalKeyManager) WrapKey(key_id string, data_key []byte) ([]byte, error) {
+	master_key, err := m.getKey(key_id)
+	if err != nil {
+		return nil, err
+	}
+	// id of master key is authenticated, so the wrapped key can't be attributed to another version
+	return Seal(master_key, data_key, []byte(key_id))
+}
+
+func (m *LocalKeyManager) UnwrapKey(key_id string, wrapped_key []byte) ([]byte, error) {
+	master_key, err := m.getKey(key_id)
+	if err != nil {
+		return nil, err
+	}
+	return Open(master_key, wrapped_key, []byte(key_id))
+}
+
+func readKeyfile(path string) (keyfile, error) {
+	var content keyfile
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return content, err
+	}
+	err = json.Unmarshal(raw, &content)
+	if err != nil {
+		return content, fmt.Errorf("can't parse keyfile %s: %v", path, err)
+	}
+	return content, nil
+}
+
+// RotateKeyfile adds new random master key into the keyfile and makes it current, the keyfile is
+// created if it does not exist. Ids of master keys are v1, v2 and so on
+func RotateKeyfile(path string) (string, error) {
+	content, err := readKeyfile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		content = keyfile{Keys: map[string]string{}}
+	} else if err != nil {
+		return "", err
+	}
+	if content.Keys == nil {
+		content.Keys = map[string]string{}
+	}
+
+	version := 0
+	for key_id := range content.Keys {
+		cur, err := strconv.Atoi(strings.TrimPrefix(key_id, "v"))
+		if err == nil && cur > version {
+			version = cur
+		}
+	}
+	key_id := "v" + strconv.Itoa(version+1)
+	master_key, err := NewDataKey()
+	if err != nil {
+		return "", err
+	}
+	content.Keys[key_id] = base64.StdEncoding.EncodeToString(master_key)
+	content.Current = key_id
+
+	raw, err := json.MarshalIndent(content, "", "    ")
+	if err != nil {
+		return "", err
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return "", err
+	}
+	// keyfile is replaced atomically, so readers never see it half-written
+	tmp_path := path + ".tmp"
+	err = os.WriteFile(tmp_path, raw, 0600)
+	if err != nil {
+		return "", err
+	}
+	err = os.Rename(tmp_path, path)
+	if err != nil {
+		return "", err
+	}
+	return key_id, nil
+}
+
+func NewDataKey() ([]byte, error) {
+	key := make([]byte, DataKeySize)
+	_, err := rand.Read(key)
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// Seal encrypts data with AES-GCM, random nonce is prepended to the result
+func Seal(key, data, additional_data []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(data)+aead.Overhead())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, data, additional_data), nil
+}
+
+// Open decrypts data sealed by Seal with the same key and additional data
+func Open(key, sealed, additional_data []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("encrypted data is too short")
+	}
+	return aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], additional_data)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,45 @@
+package common
+
+import "fmt"
+
+// RateLimit is a token bucket: Rate requests per second on average and at most Burst at once.
+// Zero rate means unlimited
+type RateLimit struct {
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+}
+
+// Limits protect the api service and shards behind it from noisy clients
+type Limits struct {
+	Access_key RateLimit `json:"access_key"`
+	Bucket     RateLimit `json:"bucket"`
+	Ip         RateLimit `json:"ip"`
+	// at most Max_transfers uploads and downloads are served at once, others wait in queue of
+	// Max_queued_transfers for Queue_timeout_ms. Zero Max_transfers means unlimited
+	Max_transfers        int `json:"max_transfers"`
+	Max_queued_transfers int `json:"max_queued_transfers"`
+	Queue_timeout_ms     int `json:"queue_timeout_ms"`
+}
+
+func (l RateLimit) validate(name string) error {
+	if l.Rate < 0 || l.Burst < 0 {
+		return fmt.Errorf("rate and burst of %s limit must not be negative", name)
+	}
+	if l.Rate > 0 && l.Burst == 0 {
+		return fmt.Errorf("burst of %s limit must be positive if rate is set", name)
+	}
+	return nil
+}
+
+func (l Limits) validate() error {
+	for name, limit := range map[string]RateLimit{"access_key": l.Access_key, "bucket": l.Bucket, "ip": l.Ip} {
+		err := limit.validate(name)
+		if err != nil {
+			return err
+		}
+	}
+	if l.Max_transfers < 0 || l.Max_queued_transfers < 0 || l.Queue_timeout_ms < 0 {
+		return fmt.Errorf("limits of transfers must not be negative")
+	}
+	return nil
+}
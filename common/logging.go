@@ -0,0 +1,140 @@
+package common
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// RequestIDHeader carries ID of request to clients and shards
+const RequestIDHeader = "X-Request-Id"
+
+// requestIDMetadataKey carries ID of request to meta service, keys of gRPC metadata are lowercase
+const requestIDMetadataKey = "x-request-id"
+
+type requestIDContextKey struct{}
+
+func NewRequestID() string {
+	id := make([]byte, 8)
+	_, err := rand.Read(id)
+	if err != nil {
+		panic("can't generate random request id")
+	}
+	return hex.EncodeToString(id)
+}
+
+func WithRequestID(ctx context.Context, request_id string) context.Context {
+	return context.WithValue(ctx, requestIDContextKey{}, request_id)
+}
+
+// RequestID returns ID of request which ctx belongs to, it's empty outside of requests
+func RequestID(ctx context.Context) string {
+	request_id, _ := ctx.Value(requestIDContextKey{}).(string)
+	return request_id
+}
+
+// contextHandler adds ID of request and trace to records which are logged with context of request
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h *contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if request_id := RequestID(ctx); request_id != "" {
+		record.AddAttrs(slog.String("request_id", request_id))
+	}
+	if span_context := trace.SpanContextFromContext(ctx); span_context.IsValid() {
+		record.AddAttrs(slog.String("trace_id", span_context.TraceID().String()))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h *contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h *contextHandler) WithGroup(name string) slog.Handler {
+	return &contextHandler{h.Handler.WithGroup(name)}
+}
+
+// InitLogging makes slog write JSON lines to stderr labeled with name of the service and attrs,
+// lines of log package are written as info records too
+func InitLogging(service string, attrs ...any) {
+	logger := slog.New(&contextHandler{slog.NewJSONHandler(os.Stderr, nil)})
+	slog.SetDefault(logger.With(LabelService, service).With(attrs...))
+}
+
+// Fatal logs error and exits like log.Fatal
+func Fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// LoggingMiddleware is mux middleware which puts ID of request into its context, returns it in X-Request-Id
+// header and logs the request when it's served. Public services generate new ID for every request,
+// internal ones continue ID which is passed by the caller. It must be added before other middlewares,
+// so requests which are rejected by them are logged too
+func LoggingMiddleware(public bool) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			request_id := req.Header.Get(RequestIDHeader)
+			if public || request_id == "" {
+				request_id = NewRequestID()
+			}
+			ctx := WithRequestID(req.Context(), request_id)
+			w.Header().Set(RequestIDHeader, request_id)
+
+			start := time.Now()
+			recorder := &statusRecorder{ResponseWriter: w, code: http.StatusOK}
+			next.ServeHTTP(recorder, req.WithContext(ctx))
+			if isProbe(req) {
+				return
+			}
+
+			level := slog.LevelInfo
+			if recorder.code >= http.StatusInternalServerError {
+				level = slog.LevelError
+			}
+			slog.Log(ctx, level, "Served request", LabelMethod, req.Method, "path", req.URL.Path, LabelRoute, routeLabel(req),
+				LabelCode, recorder.code, "sent_bytes", recorder.n, "duration_ms", time.Since(start).Milliseconds())
+		})
+	}
+}
+
+// requestIDTransport passes ID of request to other services in X-Request-Id header
+type requestIDTransport struct {
+	next http.RoundTripper
+}
+
+func (t *requestIDTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if request_id := RequestID(req.Context()); request_id != "" {
+		// request must not be modified by transport
+		req = req.Clone(req.Context())
+		req.Header.Set(RequestIDHeader, request_id)
+	}
+	return t.next.RoundTrip(req)
+}
+
+// RequestIDClientInterceptor passes ID of request to meta service in gRPC metadata
+func RequestIDClientInterceptor(ctx context.Context, method string, req, reply any, conn *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	if request_id := RequestID(ctx); request_id != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, requestIDMetadataKey, request_id)
+	}
+	return invoker(ctx, method, req, reply, conn, opts...)
+}
+
+// RequestIDServerInterceptor continues ID of request which is passed by the caller in gRPC metadata
+func RequestIDServerInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if values := metadata.ValueFromIncomingContext(ctx, requestIDMetadataKey); len(values) > 0 {
+		ctx = WithRequestID(ctx, values[0])
+	}
+	return handler(ctx, req)
+}
@@ -0,0 +1,179 @@
+package common
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// labels of metrics are the same in all services, so metrics of different services can be aggregated
+// together. Every metric is labeled with the name of its service
+const (
+	LabelService = "service"
+	LabelRoute   = "route"
+	LabelMethod  = "method"
+	LabelCode    = "code"
+	// shard is the storage service which stores chunks, or which is requested for them
+	LabelShard      = "shard"
+	LabelGrpcMethod = "grpc_method"
+	LabelGrpcCode   = "grpc_code"
+)
+
+// StorageServiceName labels metrics of all shards, they are told apart by instance label of Prometheus
+const StorageServiceName = "storage_service"
+
+// MetricsScraperName is the name in certificate of Prometheus. Services which require client
+// certificates let it read metrics
+const MetricsScraperName = "prometheus"
+
+const MetricsPath = "/metrics"
+
+// codeError is the code label of requests to shards which have failed without response
+const codeError = "error"
+
+// Metrics is registry of metrics of one service, it's served at MetricsPath in Prometheus format
+type Metrics struct {
+	registry   *prometheus.Registry
+	registerer prometheus.Registerer
+
+	request_duration *prometheus.HistogramVec
+	received_bytes   *prometheus.CounterVec
+	sent_bytes       *prometheus.CounterVec
+	shard_duration   *prometheus.HistogramVec
+}
+
+func NewMetrics(service string) *Metrics {
+	registry := prometheus.NewRegistry()
+	m := &Metrics{
+		registry:   registry,
+		registerer: prometheus.WrapRegistererWith(prometheus.Labels{LabelService: service}, registry),
+		request_duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "http_request_duration_seconds",
+			Help:    "Duration of HTTP requests served by the service, its count is the number of requests.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{LabelRoute, LabelMethod, LabelCode}),
+		received_bytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_received_bytes_total",
+			Help: "Bytes of bodies of HTTP requests read by the service.",
+		}, []string{LabelRoute, LabelMethod}),
+		sent_bytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_sent_bytes_total",
+			Help: "Bytes of bodies of HTTP responses written by the service.",
+		}, []string{LabelRoute, LabelMethod}),
+		shard_duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "shard_request_duration_seconds",
+			Help:    "Duration of requests of the service to shards until their response headers are received.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{LabelShard, LabelMethod, LabelCode}),
+	}
+	m.Register(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.request_duration, m.received_bytes, m.sent_bytes, m.shard_duration,
+	)
+	return m
+}
+
+// Register adds metrics which are specific to the service, it panics if they are already registered
+func (m *Metrics) Register(metrics ...prometheus.Collector) {
+	m.registerer.MustRegister(metrics...)
+}
+
+// Handler serves metrics in Prometheus format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// routeLabel is the path template of the matched route. Routes of one path are told apart by their queries,
+// e.g. /{bucket}?policy
+func routeLabel(req *http.Request) string {
+	route := mux.CurrentRoute(req)
+	if route == nil {
+		return "unknown"
+	}
+	template, err := route.GetPathTemplate()
+	if err != nil {
+		return "unknown"
+	}
+	queries, err := route.GetQueriesTemplates()
+	if err != nil || len(queries) == 0 {
+		return template
+	}
+	for i := range queries {
+		queries[i] = strings.TrimSuffix(queries[i], "=")
+	}
+	return template + "?" + strings.Join(queries, "&")
+}
+
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	code int
+	n    int64
+}
+
+func (w *statusRecorder) WriteHeader(code int) {
+	w.code = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusRecorder) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+// Middleware is mux middleware which records requests matched by routes of the router, so it must
+// be added before other middlewares to record requests which are rejected by them
+func (m *Metrics) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		route := routeLabel(req)
+		body := &countingReader{ReadCloser: req.Body}
+		req.Body = body
+		recorder := &statusRecorder{ResponseWriter: w, code: http.StatusOK}
+
+		next.ServeHTTP(recorder, req)
+
+		m.request_duration.WithLabelValues(route, req.Method, strconv.Itoa(recorder.code)).Observe(time.Since(start).Seconds())
+		m.received_bytes.WithLabelValues(route, req.Method).Add(float64(body.n))
+		m.sent_bytes.WithLabelValues(route, req.Method).Add(float64(recorder.n))
+	})
+}
+
+// InstrumentShardClient records latencies of requests of the client to shards by host of their urls,
+// as hosts of shards are their names
+func (m *Metrics) InstrumentShardClient(client *http.Client) *http.Client {
+	transport := client.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	client.Transport = promhttp.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		start := time.Now()
+		resp, err := transport.RoundTrip(req)
+		code := codeError
+		if err == nil {
+			code = strconv.Itoa(resp.StatusCode)
+		}
+		m.shard_duration.WithLabelValues(req.URL.Hostname(), req.Method, code).Observe(time.Since(start).Seconds())
+		return resp, err
+	})
+	return client
+}
@@ -0,0 +1,192 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// actions which policies grant on buckets, admin implies all other actions
+const (
+	// get and head files, get their tags
+	ActionRead = "read"
+	// upload, copy into, tag and restore files
+	ActionWrite = "write"
+	// list files of bucket, head bucket, find files by tags
+	ActionList = "list"
+	// delete files
+	ActionDelete = "delete"
+	// delete bucket, manage its policy and lifecycle, see its jobs
+	ActionAdmin = "admin"
+)
+
+const (
+	EffectAllow = "allow"
+	EffectDeny  = "deny"
+)
+
+// principals of statements look like user:<name> or group:<name>. PrincipalAnyone matches anonymous
+// requests too (e.g. for public-read buckets), PrincipalAuthenticated matches any signed request
+const (
+	PrincipalAnyone        = "*"
+	PrincipalAuthenticated = "user:*"
+	userPrincipalPrefix    = "user:"
+	groupPrincipalPrefix   = "group:"
+)
+
+// members of AdminGroup are allowed everything on all buckets
+const AdminGroup = "admins"
+
+var knownActions = map[string]bool{ActionRead: true, ActionWrite: true, ActionList: true, ActionDelete: true, ActionAdmin: true}
+
+type PolicyStatement struct {
+	Effect     string   `json:"effect"`
+	Principals []string `json:"principals"`
+	Actions    []string `json:"actions"`
+	// statement with prefix is applied only to files with this prefix, so it does not affect
+	// actions on the whole bucket (list and admin)
+	Prefix string `json:"prefix,omitempty"`
+	// statement with tags is applied only to files whose current tags match this tag expression,
+	// like prefix it does not affect actions on the whole bucket
+	Tags string `json:"tags,omitempty"`
+
+	// parsed Tags, it's set by ParsePolicy
+	tag_expr TagExpr
+}
+
+// Policy of bucket is evaluated deny-by-default: the request is allowed only if some statement allows
+// it and no statement denies it. Owner of the bucket is allowed everything regardless of the policy
+type Policy struct {
+	Statements []PolicyStatement `json:"statements"`
+}
+
+// Identity is who sent the request, User is empty for anonymous requests
+type Identity struct {
+	User   string
+	Groups []string
+}
+
+// ParsePolicy parses and validates policy document, empty document is an empty policy
+func ParsePolicy(document string) (Policy, error) {
+	var policy Policy
+	if document == "" {
+		return policy, nil
+	}
+
+	decoder := json.NewDecoder(strings.NewReader(document))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&policy)
+	if err != nil {
+		return policy, fmt.Errorf("malformed policy: %v", err)
+	}
+
+	for i, statement := range policy.Statements {
+		if statement.Effect != EffectAllow && statement.Effect != EffectDeny {
+			return policy, fmt.Errorf("effect of statement %d must be %s or %s, got %q", i, EffectAllow, EffectDeny, statement.Effect)
+		}
+		if len(statement.Principals) == 0 || len(statement.Actions) == 0 {
+			return policy, fmt.Errorf("statement %d must have at least one principal and one action", i)
+		}
+		for _, principal := range statement.Principals {
+			if principal != PrincipalAnyone && !strings.HasPrefix(principal, userPrincipalPrefix) && !strings.HasPrefix(principal, groupPrincipalPrefix) {
+				return policy, fmt.Errorf("principal of statement %d must be %s, user:<name> or group:<name>, got %q", i, PrincipalAnyone, principal)
+			}
+		}
+		for _, action := range statement.Actions {
+			if !knownActions[action] {
+				return policy, fmt.Errorf("unknown action %q in statement %d", action, i)
+			}
+		}
+		if statement.Tags != "" {
+			policy.Statements[i].tag_expr, err = ParseTagExpr(statement.Tags)
+			if err != nil {
+				return policy, fmt.Errorf("invalid tags of statement %d: %v", i, err)
+			}
+		}
+	}
+	return policy, nil
+}
+
+// HasTagConditions tells whether tags of the file are needed to evaluate the policy
+func (p *Policy) HasTagConditions() bool {
+	for i := range p.Statements {
+		if p.Statements[i].Tags != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func (i Identity) matches(principal string) bool {
+	if principal == PrincipalAnyone {
+		return true
+	}
+	if i.User == "" {
+		return false
+	}
+	if principal == PrincipalAuthenticated || principal == userPrincipalPrefix+i.User {
+		return true
+	}
+	for _, group := range i.Groups {
+		if principal == groupPrincipalPrefix+group {
+			return true
+		}
+	}
+	return false
+}
+
+func (i Identity) isAdmin() bool {
+	for _, group := range i.Groups {
+		if group == AdminGroup {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *PolicyStatement) applies(identity Identity, action, file string, tags map[string]string) bool {
+	if s.Prefix != "" && (file == "" || !strings.HasPrefix(file, s.Prefix)) {
+		return false
+	}
+	if s.Tags != "" && (file == "" || s.tag_expr == nil || !s.tag_expr.Match(tags)) {
+		return false
+	}
+
+	action_matches := false
+	for _, cur := range s.Actions {
+		if cur == action || cur == ActionAdmin {
+			action_matches = true
+			break
+		}
+	}
+	if !action_matches {
+		return false
+	}
+
+	for _, principal := range s.Principals {
+		if identity.matches(principal) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsAllowed decides whether identity may perform action on the file of bucket with given owner and policy.
+// File is empty for actions on the whole bucket, tags of the file are nil if it has no tags or does not exist yet
+func (p *Policy) IsAllowed(owner string, identity Identity, action, file string, tags map[string]string) bool {
+	if identity.isAdmin() || (identity.User != "" && identity.User == owner) {
+		return true
+	}
+
+	allowed := false
+	for i := range p.Statements {
+		if !p.Statements[i].applies(identity, action, file, tags) {
+			continue
+		}
+		if p.Statements[i].Effect == EffectDeny {
+			return false
+		}
+		allowed = true
+	}
+	return allowed
+}
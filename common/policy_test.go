@@ -0,0 +1,131 @@
+package common
+
+import "testing"
+
+func TestPolicyIsAllowed(t *testing.T) {
+	alice := Identity{User: "alice"}
+	bob := Identity{User: "bob", Groups: []string{"devs"}}
+	carol := Identity{User: "carol", Groups: []string{AdminGroup}}
+	anonymous := Identity{}
+
+	empty := Policy{}
+	public_read := Policy{Statements: []PolicyStatement{
+		{Effect: EffectAllow, Principals: []string{PrincipalAnyone}, Actions: []string{ActionRead, ActionList}},
+	}}
+	authenticated_read := Policy{Statements: []PolicyStatement{
+		{Effect: EffectAllow, Principals: []string{PrincipalAuthenticated}, Actions: []string{ActionRead}},
+	}}
+	user_and_group := Policy{Statements: []PolicyStatement{
+		{Effect: EffectAllow, Principals: []string{"user:alice"}, Actions: []string{ActionRead, ActionWrite}},
+		{Effect: EffectAllow, Principals: []string{"group:devs"}, Actions: []string{ActionList, ActionDelete}},
+	}}
+	bucket_admin := Policy{Statements: []PolicyStatement{
+		{Effect: EffectAllow, Principals: []string{"user:alice"}, Actions: []string{ActionAdmin}},
+	}}
+	prefixed := Policy{Statements: []PolicyStatement{
+		{Effect: EffectAllow, Principals: []string{"user:alice"}, Actions: []string{ActionRead, ActionWrite, ActionList}, Prefix: "shared/"},
+	}}
+	deny_over_allow := Policy{Statements: []PolicyStatement{
+		{Effect: EffectAllow, Principals: []string{PrincipalAnyone}, Actions: []string{ActionRead}},
+		{Effect: EffectDeny, Principals: []string{"group:devs"}, Actions: []string{ActionRead}, Prefix: "secret/"},
+	}}
+	// tag conditions are parsed by ParsePolicy
+	tagged, err := ParsePolicy(`{"statements": [
+		{"effect": "allow", "principals": ["user:alice"], "actions": ["read", "list"], "tags": "team=infra OR public"},
+		{"effect": "deny", "principals": ["user:alice"], "actions": ["read"], "tags": "classification=secret"}
+	]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	infra := map[string]string{"team": "infra"}
+	infra_secret := map[string]string{"team": "infra", "classification": "secret"}
+
+	tests := []struct {
+		name     string
+		policy   Policy
+		owner    string
+		identity Identity
+		action   string
+		file     string
+		tags     map[string]string
+		allowed  bool
+	}{
+		// deny by default
+		{"empty policy denies user read", empty, "bob", alice, ActionRead, "a.txt", nil, false},
+		{"empty policy denies user write", empty, "bob", alice, ActionWrite, "a.txt", nil, false},
+		{"empty policy denies user list", empty, "bob", alice, ActionList, "", nil, false},
+		{"empty policy denies user delete", empty, "bob", alice, ActionDelete, "a.txt", nil, false},
+		{"empty policy denies user admin", empty, "bob", alice, ActionAdmin, "", nil, false},
+		{"empty policy denies anonymous read", empty, "bob", anonymous, ActionRead, "a.txt", nil, false},
+
+		// owner and admins bypass the policy
+		{"owner reads", empty, "alice", alice, ActionRead, "a.txt", nil, true},
+		{"owner writes", empty, "alice", alice, ActionWrite, "a.txt", nil, true},
+		{"owner lists", empty, "alice", alice, ActionList, "", nil, true},
+		{"owner deletes", empty, "alice", alice, ActionDelete, "a.txt", nil, true},
+		{"owner administers", empty, "alice", alice, ActionAdmin, "", nil, true},
+		{"owner is not denied", deny_over_allow, "bob", bob, ActionRead, "secret/a.txt", nil, true},
+		{"admin administers", empty, "alice", carol, ActionAdmin, "", nil, true},
+		{"admin deletes", empty, "alice", carol, ActionDelete, "a.txt", nil, true},
+		{"anonymous is not owner of bucket without owner", empty, "", anonymous, ActionRead, "a.txt", nil, false},
+
+		// user and group principals
+		{"user principal allows read", user_and_group, "carol", alice, ActionRead, "a.txt", nil, true},
+		{"user principal allows write", user_and_group, "carol", alice, ActionWrite, "a.txt", nil, true},
+		{"user principal does not allow list", user_and_group, "carol", alice, ActionList, "", nil, false},
+		{"user principal does not allow other user", user_and_group, "carol", bob, ActionRead, "a.txt", nil, false},
+		{"group principal allows list", user_and_group, "carol", bob, ActionList, "", nil, true},
+		{"group principal allows delete", user_and_group, "carol", bob, ActionDelete, "a.txt", nil, true},
+		{"group principal does not allow admin", user_and_group, "carol", bob, ActionAdmin, "", nil, false},
+		{"group principal does not allow user out of group", user_and_group, "carol", alice, ActionDelete, "a.txt", nil, false},
+
+		// admin action of statement implies all actions
+		{"bucket admin reads", bucket_admin, "bob", alice, ActionRead, "a.txt", nil, true},
+		{"bucket admin writes", bucket_admin, "bob", alice, ActionWrite, "a.txt", nil, true},
+		{"bucket admin lists", bucket_admin, "bob", alice, ActionList, "", nil, true},
+		{"bucket admin deletes", bucket_admin, "bob", alice, ActionDelete, "a.txt", nil, true},
+		{"bucket admin administers", bucket_admin, "bob", alice, ActionAdmin, "", nil, true},
+
+		// wildcard principals
+		{"authenticated principal allows user", authenticated_read, "carol", alice, ActionRead, "a.txt", nil, true},
+		{"authenticated principal does not allow anonymous", authenticated_read, "carol", anonymous, ActionRead, "a.txt", nil, false},
+		{"authenticated principal allows only listed actions", authenticated_read, "carol", alice, ActionWrite, "a.txt", nil, false},
+
+		// public-read buckets
+		{"public read allows anonymous read", public_read, "bob", anonymous, ActionRead, "a.txt", nil, true},
+		{"public read allows anonymous list", public_read, "bob", anonymous, ActionList, "", nil, true},
+		{"public read allows user read", public_read, "bob", alice, ActionRead, "a.txt", nil, true},
+		{"public read denies anonymous write", public_read, "bob", anonymous, ActionWrite, "a.txt", nil, false},
+		{"public read denies anonymous delete", public_read, "bob", anonymous, ActionDelete, "a.txt", nil, false},
+		{"public read denies anonymous admin", public_read, "bob", anonymous, ActionAdmin, "", nil, false},
+
+		// prefix-scoped statements
+		{"prefix allows read of file with prefix", prefixed, "bob", alice, ActionRead, "shared/a.txt", nil, true},
+		{"prefix allows write of file with prefix", prefixed, "bob", alice, ActionWrite, "shared/a.txt", nil, true},
+		{"prefix does not allow file without prefix", prefixed, "bob", alice, ActionRead, "private/a.txt", nil, false},
+		{"prefix does not allow actions on bucket", prefixed, "bob", alice, ActionList, "", nil, false},
+
+		// deny wins over allow
+		{"deny applies to its prefix", deny_over_allow, "carol", bob, ActionRead, "secret/a.txt", nil, false},
+		{"deny does not apply out of its prefix", deny_over_allow, "carol", bob, ActionRead, "public/a.txt", nil, true},
+		{"deny does not apply to other principals", deny_over_allow, "carol", alice, ActionRead, "secret/a.txt", nil, true},
+
+		// tag-scoped statements
+		{"tags allow read of file with matching tags", tagged, "bob", alice, ActionRead, "a.txt", infra, true},
+		{"tags allow read of file with tag present", tagged, "bob", alice, ActionRead, "a.txt", map[string]string{"public": ""}, true},
+		{"tags do not allow file with other tags", tagged, "bob", alice, ActionRead, "a.txt", map[string]string{"team": "web"}, false},
+		{"tags do not allow file without tags", tagged, "bob", alice, ActionRead, "a.txt", nil, false},
+		{"tags do not allow actions on bucket", tagged, "bob", alice, ActionList, "", nil, false},
+		{"deny by tags wins over allow by tags", tagged, "bob", alice, ActionRead, "a.txt", infra_secret, false},
+		{"owner is not denied by tags", tagged, "alice", alice, ActionRead, "a.txt", infra_secret, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			allowed := test.policy.IsAllowed(test.owner, test.identity, test.action, test.file, test.tags)
+			if allowed != test.allowed {
+				t.Errorf("IsAllowed(%q, %+v, %q, %q, %v) = %v, want %v", test.owner, test.identity, test.action, test.file, test.tags, allowed, test.allowed)
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// defaultShutdownTimeout is how long requests in flight are drained on shutdown if
+// shutdown_timeout_seconds is not set. docker compose waits a bit longer before it kills services
+const defaultShutdownTimeout = 25 * time.Second
+
+// ShutdownTimeout bounds draining of requests in flight on shutdown, requests which are not finished
+// by then are aborted
+func (c Config) ShutdownTimeout() time.Duration {
+	if c.Shutdown_timeout_seconds > 0 {
+		return time.Duration(c.Shutdown_timeout_seconds) * time.Second
+	}
+	return defaultShutdownTimeout
+}
+
+func (c Config) validateShutdown() error {
+	if c.Shutdown_timeout_seconds < 0 {
+		return errors.New("shutdown_timeout_seconds must not be negative")
+	}
+	return nil
+}
+
+// ShutdownContext is cancelled on SIGTERM or SIGINT, services stop accepting requests then
+// and drain ones which are in flight. The second signal kills the service at once
+func ShutdownContext() (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	go func() {
+		<-ctx.Done()
+		slog.Info("shutting down")
+		// default handling of signals is restored, so the next one terminates the process
+		stop()
+	}()
+	return ctx, stop
+}
+
+// GracefulStop stops gRPC server after calls in flight are finished, they are cancelled
+// if they don't finish in timeout
+func GracefulStop(server *grpc.Server, timeout time.Duration) {
+	stopped := make(chan struct{})
+	go func() {
+		server.GracefulStop()
+		close(stopped)
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-stopped:
+	case <-timer.C:
+		slog.Warn("calls in flight are not finished in time, they are cancelled", "timeout", timeout)
+		server.Stop()
+		<-stopped
+	}
+}
@@ -0,0 +1,242 @@
+package common
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+const (
+	MaxTagsPerFile = 10
+	MaxTagKeyLen   = 128
+	MaxTagValueLen = 256
+)
+
+// TagExpr is a parsed tag expression like `team=infra AND (retention!=long OR NOT archived)`.
+// Besides AND, OR, NOT and parentheses, it consists of conditions of three kinds: key=value,
+// key!=value (true if the tag is absent too) and key (true if the tag is present with any value)
+type TagExpr interface {
+	Match(tags map[string]string) bool
+}
+
+type TagAnd struct {
+	Left, Right TagExpr
+}
+
+type TagOr struct {
+	Left, Right TagExpr
+}
+
+type TagNot struct {
+	Expr TagExpr
+}
+
+const (
+	TagOpEquals    = "="
+	TagOpNotEquals = "!="
+	TagOpExists    = ""
+)
+
+type TagCond struct {
+	Key   string
+	Op    string
+	Value string
+}
+
+func (e *TagAnd) Match(tags map[string]string) bool {
+	return e.Left.Match(tags) && e.Right.Match(tags)
+}
+
+func (e *TagOr) Match(tags map[string]string) bool {
+	return e.Left.Match(tags) || e.Right.Match(tags)
+}
+
+func (e *TagNot) Match(tags map[string]string) bool {
+	return !e.Expr.Match(tags)
+}
+
+func (e *TagCond) Match(tags map[string]string) bool {
+	value, exists := tags[e.Key]
+	switch e.Op {
+	case TagOpEquals:
+		return exists && value == e.Value
+	case TagOpNotEquals:
+		return !exists || value != e.Value
+	default:
+		return exists
+	}
+}
+
+// ValidateTags checks limits on tags of one file, they are the same as in S3
+func ValidateTags(tags map[string]string) error {
+	if len(tags) > MaxTagsPerFile {
+		return fmt.Errorf("file can have at most %d tags, got %d", MaxTagsPerFile, len(tags))
+	}
+	for key, value := range tags {
+		if key == "" || len(key) > MaxTagKeyLen {
+			return fmt.Errorf("length of tag key must be from 1 to %d, got %q", MaxTagKeyLen, key)
+		}
+		if len(value) > MaxTagValueLen {
+			return fmt.Errorf("length of value of tag %s must be at most %d", key, MaxTagValueLen)
+		}
+	}
+	return nil
+}
+
+type tagTokenizer struct {
+	tokens []string
+	pos    int
+}
+
+func isTagSpecialSymbol(r rune) bool {
+	return r == '(' || r == ')' || r == '=' || r == '!' || r == '"'
+}
+
+func tokenizeTagExpr(expr string) ([]string, error) {
+	tokens := make([]string, 0)
+	runes := []rune(expr)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(' || r == ')' || r == '=':
+			tokens = append(tokens, string(r))
+			i++
+		case r == '!':
+			if i+1 >= len(runes) || runes[i+1] != '=' {
+				return nil, fmt.Errorf("unexpected symbol ! at position %d", i)
+			}
+			tokens = append(tokens, "!=")
+			i += 2
+		case r == '"':
+			// quoted strings are kept with the leading quote, so they are never treated as keywords
+			end := i + 1
+			for end < len(runes) && runes[end] != '"' {
+				end++
+			}
+			if end == len(runes) {
+				return nil, fmt.Errorf("unterminated quoted string at position %d", i)
+			}
+			tokens = append(tokens, string(runes[i:end]))
+			i = end + 1
+		default:
+			end := i
+			for end < len(runes) && !unicode.IsSpace(runes[end]) && !isTagSpecialSymbol(runes[end]) {
+				end++
+			}
+			tokens = append(tokens, string(runes[i:end]))
+			i = end
+		}
+	}
+	return tokens, nil
+}
+
+func (t *tagTokenizer) peek() string {
+	if t.pos < len(t.tokens) {
+		return t.tokens[t.pos]
+	}
+	return ""
+}
+
+func (t *tagTokenizer) next() string {
+	token := t.peek()
+	t.pos++
+	return token
+}
+
+func isTagKeyword(token, keyword string) bool {
+	return strings.EqualFold(token, keyword)
+}
+
+func isTagOperand(token string) bool {
+	return token != "" && token != "(" && token != ")" && token != "=" && token != "!=" &&
+		!isTagKeyword(token, "AND") && !isTagKeyword(token, "OR") && !isTagKeyword(token, "NOT")
+}
+
+func (t *tagTokenizer) parseOr() (TagExpr, error) {
+	left, err := t.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for isTagKeyword(t.peek(), "OR") {
+		t.next()
+		right, err := t.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &TagOr{Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (t *tagTokenizer) parseAnd() (TagExpr, error) {
+	left, err := t.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for isTagKeyword(t.peek(), "AND") {
+		t.next()
+		right, err := t.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &TagAnd{Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (t *tagTokenizer) parseUnary() (TagExpr, error) {
+	token := t.next()
+	switch {
+	case isTagKeyword(token, "NOT"):
+		expr, err := t.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &TagNot{Expr: expr}, nil
+	case token == "(":
+		expr, err := t.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if t.next() != ")" {
+			return nil, fmt.Errorf("missing closing parenthesis")
+		}
+		return expr, nil
+	case isTagOperand(token):
+		cond := &TagCond{Key: strings.TrimPrefix(token, `"`), Op: TagOpExists}
+		if t.peek() == TagOpEquals || t.peek() == TagOpNotEquals {
+			cond.Op = t.next()
+			value := t.next()
+			if !isTagOperand(value) {
+				return nil, fmt.Errorf("expected value of tag %s after %s", cond.Key, cond.Op)
+			}
+			cond.Value = strings.TrimPrefix(value, `"`)
+		}
+		return cond, nil
+	case token == "":
+		return nil, fmt.Errorf("unexpected end of expression")
+	default:
+		return nil, fmt.Errorf("unexpected token %q", token)
+	}
+}
+
+// ParseTagExpr parses tag expression, keywords are case-insensitive.
+// Keys and values containing spaces or special symbols must be enclosed in double quotes
+func ParseTagExpr(expr string) (TagExpr, error) {
+	tokens, err := tokenizeTagExpr(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	tokenizer := &tagTokenizer{tokens: tokens}
+	parsed, err := tokenizer.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tokenizer.pos != len(tokens) {
+		return nil, fmt.Errorf("unexpected token %q", tokenizer.peek())
+	}
+	return parsed, nil
+}
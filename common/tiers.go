@@ -0,0 +1,86 @@
+package common
+
+import (
+	"fmt"
+	"sort"
+)
+
+// DefaultTierName is the name of the only tier when no tiers are configured
+const DefaultTierName = "standard"
+
+// Tier is a group of shards, e.g. fast SSD shards for hot files and large HDD shards for old ones.
+// Files in archive tier can't be read till they are restored into the default tier
+type Tier struct {
+	Shards  []string `json:"shards"`
+	Archive bool     `json:"archive"`
+}
+
+// GetTiers returns configured tiers. Without them all shards belong to one tier DefaultTierName
+func (c *Config) GetTiers() map[string]Tier {
+	if len(c.Tiers) > 0 {
+		return c.Tiers
+	}
+
+	shards := make([]string, 0, len(c.Shards))
+	for shard := range c.Shards {
+		shards = append(shards, shard)
+	}
+	sort.Strings(shards)
+	return map[string]Tier{DefaultTierName: {Shards: shards}}
+}
+
+// GetDefaultTier returns the tier where new files are stored
+func (c *Config) GetDefaultTier() string {
+	if len(c.Tiers) > 0 {
+		return c.Default_tier
+	}
+	return DefaultTierName
+}
+
+// GetTierShards returns ports of shards of the tier, nil means that there is no such tier
+func (c *Config) GetTierShards(tier string) map[string]int {
+	tier_info, ok := c.GetTiers()[tier]
+	if !ok {
+		return nil
+	}
+
+	shards := make(map[string]int, len(tier_info.Shards))
+	for _, shard := range tier_info.Shards {
+		shards[shard] = c.Shards[shard]
+	}
+	return shards
+}
+
+// IsArchiveTier returns false for unknown tiers
+func (c *Config) IsArchiveTier(tier string) bool {
+	return c.GetTiers()[tier].Archive
+}
+
+func (c *Config) validateTiers() error {
+	if len(c.Tiers) == 0 {
+		if c.Default_tier != "" && c.Default_tier != DefaultTierName {
+			return fmt.Errorf("default tier %s is set, but there are no tiers", c.Default_tier)
+		}
+		return nil
+	}
+
+	for name, tier := range c.Tiers {
+		if len(tier.Shards) == 0 {
+			return fmt.Errorf("tier %s has no shards", name)
+		}
+		for _, shard := range tier.Shards {
+			if _, ok := c.Shards[shard]; !ok {
+				return fmt.Errorf("unknown shard %s in tier %s", shard, name)
+			}
+		}
+	}
+
+	default_tier, ok := c.Tiers[c.Default_tier]
+	if !ok {
+		return fmt.Errorf("default tier %q is not one of tiers", c.Default_tier)
+	}
+	if default_tier.Archive {
+		return fmt.Errorf("default tier %s must not be archive", c.Default_tier)
+	}
+	return nil
+}
@@ -0,0 +1,240 @@
+package common
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// names of services are their hostnames in docker compose, certificates of services are issued for them.
+// Storage services are named by their shards
+const (
+	ApiServiceName  = "api_service"
+	MetaServiceName = "meta_service"
+	StatServiceName = "stat_service"
+)
+
+// servicePlaceholder in paths of certificate and key is replaced with the name of service,
+// so all services share one config
+const servicePlaceholder = "{service}"
+
+// TLSConfig holds paths of PEM files, TLS is disabled if CA is not set. Services verify each other
+// with certificates signed by this CA
+type TLSConfig struct {
+	Ca   string `json:"ca"`
+	Cert string `json:"cert"`
+	Key  string `json:"key"`
+}
+
+func (c Config) TLSEnabled() bool {
+	return c.Tls.Ca != ""
+}
+
+// Scheme is the scheme of urls of http services
+func (c Config) Scheme() string {
+	if c.TLSEnabled() {
+		return "https"
+	}
+	return "http"
+}
+
+// CertReloader serves certificate of the service and CA to TLS connections. The files are reread
+// when they change, so certificates can be renewed without restart
+type CertReloader struct {
+	ca_path   string
+	cert_path string
+	key_path  string
+
+	mutex     sync.Mutex
+	mod_times [3]time.Time
+	cert      *tls.Certificate
+	ca        *x509.CertPool
+}
+
+// NewCertReloader loads certificate of the service, it returns nil if TLS is disabled in config
+func NewCertReloader(config Config, service string) (*CertReloader, error) {
+	if !config.TLSEnabled() {
+		return nil, nil
+	}
+	if config.Tls.Cert == "" || config.Tls.Key == "" {
+		return nil, errors.New("cert and key must be set in tls section of config together with ca")
+	}
+
+	reloader := &CertReloader{
+		ca_path:   config.Tls.Ca,
+		cert_path: strings.ReplaceAll(config.Tls.Cert, servicePlaceholder, service),
+		key_path:  strings.ReplaceAll(config.Tls.Key, servicePlaceholder, service),
+	}
+	reloader.mutex.Lock()
+	defer reloader.mutex.Unlock()
+	err := reloader.reload()
+	if err != nil {
+		return nil, err
+	}
+	return reloader, nil
+}
+
+// reload must be called under the mutex
+func (r *CertReloader) reload() error {
+	var mod_times [3]time.Time
+	for i, path := range []string{r.ca_path, r.cert_path, r.key_path} {
+		file_info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		mod_times[i] = file_info.ModTime()
+	}
+	if r.cert != nil && mod_times == r.mod_times {
+		return nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.cert_path, r.key_path)
+	if err != nil {
+		return fmt.Errorf("failed to load certificate %s: %v", r.cert_path, err)
+	}
+	ca_pem, err := os.ReadFile(r.ca_path)
+	if err != nil {
+		return err
+	}
+	ca := x509.NewCertPool()
+	if !ca.AppendCertsFromPEM(ca_pem) {
+		return fmt.Errorf("there are no certificates in CA file %s", r.ca_path)
+	}
+
+	if r.cert != nil {
+		slog.Info("Reloaded certificate", "cert", r.cert_path, "ca", r.ca_path)
+	}
+	r.mod_times = mod_times
+	r.cert = &cert
+	r.ca = ca
+	return nil
+}
+
+// current returns the loaded certificate and CA. If the files are being replaced and can't be read,
+// the previous ones are used until the new ones are complete
+func (r *CertReloader) current() (*tls.Certificate, *x509.CertPool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	err := r.reload()
+	if err != nil {
+		slog.Warn("Failed to reload certificate, the old one is used", "cert", r.cert_path, "error", err)
+	}
+	return r.cert, r.ca
+}
+
+// verifyPeer checks certificate chain of the other side against current CA. If names are passed,
+// the certificate must be issued for one of them
+func (r *CertReloader) verifyPeer(chain []*x509.Certificate, server_name string, usage x509.ExtKeyUsage, names []string) error {
+	if len(chain) == 0 {
+		return errors.New("peer has not presented a certificate")
+	}
+	_, ca := r.current()
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range chain[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := chain[0].Verify(x509.VerifyOptions{DNSName: server_name, Roots: ca, Intermediates: intermediates, KeyUsages: []x509.ExtKeyUsage{usage}})
+	if err != nil {
+		return err
+	}
+
+	if len(names) == 0 {
+		return nil
+	}
+	for _, name := range names {
+		if chain[0].VerifyHostname(name) == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("certificate of %s is not allowed to connect to this service", chain[0].Subject.CommonName)
+}
+
+// ServerConfig is TLS config of servers. If clients are passed, only these services may connect
+// (mutual TLS), otherwise clients are not asked for certificates
+func (r *CertReloader) ServerConfig(clients ...string) *tls.Config {
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			cert, _ := r.current()
+			return cert, nil
+		},
+	}
+	if len(clients) > 0 {
+		// certificate is verified by VerifyConnection, as CA may be reloaded
+		config.ClientAuth = tls.RequireAnyClientCert
+		config.VerifyConnection = func(state tls.ConnectionState) error {
+			return r.verifyPeer(state.PeerCertificates, "", x509.ExtKeyUsageClientAuth, clients)
+		}
+	}
+	return config
+}
+
+// ClientConfig is TLS config of clients, they present certificate of the service to servers
+func (r *CertReloader) ClientConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, _ := r.current()
+			return cert, nil
+		},
+		// certificate of server is verified by VerifyConnection against the current CA instead
+		InsecureSkipVerify: true,
+		VerifyConnection: func(state tls.ConnectionState) error {
+			return r.verifyPeer(state.PeerCertificates, state.ServerName, x509.ExtKeyUsageServerAuth, nil)
+		},
+	}
+}
+
+// NewHTTPClient returns client for requests to other services, certs are nil if TLS is disabled.
+// It traces requests and passes trace context and ID of request to them
+func NewHTTPClient(certs *CertReloader, timeout time.Duration) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if certs != nil {
+		transport.TLSClientConfig = certs.ClientConfig()
+	}
+	return &http.Client{Timeout: timeout, Transport: &requestIDTransport{traceTransport(transport)}}
+}
+
+// ListenAndServe serves handler over TLS if certs are not nil, see ServerConfig about clients.
+// Requests are traced. When ctx is cancelled, the server stops accepting connections and waits
+// for requests in flight for drain_timeout, then it closes the rest of them. It returns nil
+// if all requests are drained
+func ListenAndServe(ctx context.Context, addr string, handler http.Handler, drain_timeout time.Duration, certs *CertReloader, clients ...string) error {
+	server := &http.Server{Addr: addr, Handler: traceHandler(handler)}
+	if certs != nil {
+		server.TLSConfig = certs.ServerConfig(clients...)
+	}
+	serve_err := make(chan error, 1)
+	go func() {
+		if certs == nil {
+			serve_err <- server.ListenAndServe()
+		} else {
+			serve_err <- server.ListenAndServeTLS("", "")
+		}
+	}()
+
+	select {
+	case err := <-serve_err:
+		return err
+	case <-ctx.Done():
+	}
+
+	drain_ctx, cancel := context.WithTimeout(context.Background(), drain_timeout)
+	defer cancel()
+	err := server.Shutdown(drain_ctx)
+	if err != nil {
+		server.Close()
+		return fmt.Errorf("requests in flight are not drained in %s: %w", drain_timeout, err)
+	}
+	return nil
+}
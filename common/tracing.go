@@ -0,0 +1,135 @@
+package common
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// exporters of spans
+const (
+	TracingStdout = "stdout"
+	TracingOTLP   = "otlp"
+)
+
+// TracingConfig chooses where spans are exported, tracing is disabled if exporter is not set
+type TracingConfig struct {
+	// stdout or otlp, stdout is for debugging and tests without collector
+	Exporter string `json:"exporter"`
+	// url of OTLP/HTTP collector, e.g. http://otel_collector:4318
+	Endpoint string `json:"endpoint"`
+	// share of traces which are sampled, all of them are sampled if it's not set
+	Sample_ratio float64 `json:"sample_ratio"`
+}
+
+func (c TracingConfig) validate() error {
+	switch c.Exporter {
+	case "", TracingStdout:
+	case TracingOTLP:
+		if c.Endpoint == "" {
+			return errors.New("endpoint of collector must be set for otlp exporter")
+		}
+	default:
+		return fmt.Errorf("unknown exporter %q, it must be %s or %s", c.Exporter, TracingStdout, TracingOTLP)
+	}
+	if c.Sample_ratio < 0 || c.Sample_ratio > 1 {
+		return errors.New("sample_ratio must be between 0 and 1")
+	}
+	return nil
+}
+
+// Tracer starts spans of all services, it uses provider which is set by InitTracing
+var Tracer = otel.Tracer("yet_another_object_storage")
+
+// InitTracing sets up export of spans of the service and propagation of trace context in HTTP headers
+// and gRPC metadata. The returned function flushes spans which are not exported yet
+func InitTracing(config Config, service string) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch config.Tracing.Exporter {
+	case "":
+		return func(context.Context) error { return nil }, nil
+	case TracingStdout:
+		exporter, err = stdouttrace.New()
+	case TracingOTLP:
+		exporter, err = otlptracehttp.New(context.Background(), otlptracehttp.WithEndpointURL(config.Tracing.Endpoint))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s exporter of spans: %v", config.Tracing.Exporter, err)
+	}
+
+	sample_ratio := config.Tracing.Sample_ratio
+	if sample_ratio == 0 {
+		sample_ratio = 1
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(service))),
+		// traces are sampled where they start, other services follow the decision
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(sample_ratio))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// EndSpan ends span of an operation, which has failed if err is not nil
+func EndSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// TracingMiddleware is mux middleware which names span of the request after its route, the span
+// is started by ListenAndServe
+func TracingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		route := routeLabel(req)
+		span := trace.SpanFromContext(req.Context())
+		span.SetName(req.Method + " " + route)
+		span.SetAttributes(semconv.HTTPRoute(route))
+		next.ServeHTTP(w, req)
+	})
+}
+
+// traceHandler starts span of every request, continuing trace of the caller. Scrapes of metrics and
+// health checks are not traced, they would only clutter traces
+func traceHandler(handler http.Handler) http.Handler {
+	return otelhttp.NewHandler(handler, "HTTP",
+		otelhttp.WithFilter(func(req *http.Request) bool { return !isProbe(req) }),
+		otelhttp.WithSpanNameFormatter(func(_ string, req *http.Request) string { return req.Method }),
+	)
+}
+
+// untracedContext makes requests to other services which are made with ctx not sampled, they continue
+// trace which is not sampled
+func untracedContext(ctx context.Context) context.Context {
+	var trace_id trace.TraceID
+	var span_id trace.SpanID
+	rand.Read(trace_id[:])
+	rand.Read(span_id[:])
+	return trace.ContextWithSpanContext(ctx, trace.NewSpanContext(trace.SpanContextConfig{TraceID: trace_id, SpanID: span_id}))
+}
+
+// traceTransport starts span of every request to other services and passes trace context to them
+func traceTransport(transport http.RoundTripper) http.RoundTripper {
+	return otelhttp.NewTransport(transport,
+		otelhttp.WithSpanNameFormatter(func(_ string, req *http.Request) string { return req.Method + " " + req.URL.Hostname() }),
+	)
+}
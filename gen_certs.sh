@@ -0,0 +1,34 @@
+#!/bin/sh
+# gen_certs.sh issues CA and certificates of all services for mutual TLS into ./certs (or the passed directory).
+# Existing CA is reused, so running the script again renews certificates of services without breaking
+# the running ones: they reload certificates when files change
+set -e
+
+dir="${1:-./certs}"
+days=365
+# prometheus is not a service, but it needs a certificate to read metrics of services which check clients
+services="api_service meta_service stat_service prometheus $(sed -n '/"storage_port"/,/}/p' config.json | grep -o '"[a-z_0-9]*":' | tr -d '":' | grep -v storage_port)"
+
+mkdir -p "$dir"
+chmod 700 "$dir"
+
+if [ ! -f "$dir/ca.crt" ]; then
+    openssl req -x509 -newkey rsa:2048 -nodes -days 3650 -subj "/CN=yet_another_object_storage CA" \
+        -keyout "$dir/ca.key" -out "$dir/ca.crt" 2>/dev/null
+fi
+
+for service in $services; do
+    # services are both servers and clients of each other, localhost is for access from the host
+    cat > "$dir/$service.ext" <<EOF
+subjectAltName = DNS:$service, DNS:localhost, IP:127.0.0.1
+extendedKeyUsage = serverAuth, clientAuth
+EOF
+    openssl req -newkey rsa:2048 -nodes -subj "/CN=$service" -keyout "$dir/$service.key.new" -out "$dir/$service.csr" 2>/dev/null
+    openssl x509 -req -in "$dir/$service.csr" -CA "$dir/ca.crt" -CAkey "$dir/ca.key" -CAcreateserial -days $days \
+        -extfile "$dir/$service.ext" -out "$dir/$service.crt.new" 2>/dev/null
+    mv "$dir/$service.key.new" "$dir/$service.key"
+    mv "$dir/$service.crt.new" "$dir/$service.crt"
+    rm "$dir/$service.csr" "$dir/$service.ext"
+    echo "Issued certificate for $service"
+done
+chmod 600 "$dir"/*.key
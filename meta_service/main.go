@@ -2,64 +2,267 @@ package main
 
 import (
 	"common"
-	"database/sql"
-	"log"
+	"context"
+	"database/sql/driver"
+	"log/slog"
 	"meta/meta"
 	metapb "meta/proto"
 	"net"
 	"strconv"
+	"sync"
 
+	"github.com/XSAM/otelsql"
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/reflection"
 
 	_ "github.com/lib/pq"
 )
 
 const (
-	dbConnStr         = "user=meta_service password=super_secret_pass dbname=meta_db host=meta_db port=5432 sslmode=disable"
-	filesTableSchema  = "(id SERIAL PRIMARY KEY, bucket TEXT, file TEXT, content_type TEXT)"
-	chunksTableSchema = "(id SERIAL PRIMARY KEY, file TEXT, chunk TEXT, shard TEXT)"
+	dbName                    = "meta_db"
+	dbConnStr                 = "user=meta_service password=super_secret_pass dbname=meta_db host=meta_db port=5432 sslmode=disable"
+	filesTableSchema          = "(id SERIAL PRIMARY KEY, bucket TEXT, file TEXT, content_type TEXT)"
+	chunksTableSchema         = "(id SERIAL PRIMARY KEY, file TEXT, chunk TEXT, shard TEXT)"
+	bucketsTableSchema        = "(bucket TEXT PRIMARY KEY, created_at TIMESTAMPTZ NOT NULL DEFAULT now(), owner TEXT NOT NULL DEFAULT '', settings TEXT NOT NULL DEFAULT '{}', objects BIGINT NOT NULL DEFAULT 0, bytes BIGINT NOT NULL DEFAULT 0)"
+	tagsTableSchema           = "(bucket TEXT NOT NULL, file TEXT NOT NULL, key TEXT NOT NULL, value TEXT NOT NULL, PRIMARY KEY (bucket, file, key))"
+	lifecycleRulesTableSchema = "(bucket TEXT NOT NULL, rule_id TEXT NOT NULL, enabled BOOLEAN NOT NULL, prefix TEXT NOT NULL DEFAULT '', tags TEXT NOT NULL DEFAULT '{}', expiration_days INT NOT NULL, PRIMARY KEY (bucket, rule_id))"
+	jobsTableSchema           = "(id SERIAL PRIMARY KEY, kind TEXT NOT NULL, bucket TEXT NOT NULL, state TEXT NOT NULL, created_at TIMESTAMPTZ NOT NULL DEFAULT now(), updated_at TIMESTAMPTZ NOT NULL DEFAULT now(), lease_until TIMESTAMPTZ NOT NULL DEFAULT now(), deleted_objects BIGINT NOT NULL DEFAULT 0, deleted_chunks BIGINT NOT NULL DEFAULT 0, error TEXT NOT NULL DEFAULT '')"
+	usersTableSchema          = "(name TEXT PRIMARY KEY, created_at TIMESTAMPTZ NOT NULL DEFAULT now())"
+	groupMembersTableSchema   = "(group_name TEXT NOT NULL, user_name TEXT NOT NULL REFERENCES users (name), PRIMARY KEY (group_name, user_name))"
+	accessKeysTableSchema     = "(access_key_id TEXT PRIMARY KEY, secret_access_key TEXT NOT NULL, user_name TEXT NOT NULL REFERENCES users (name), created_at TIMESTAMPTZ NOT NULL DEFAULT now(), revoked_at TIMESTAMPTZ)"
 )
 
+// migrations are applied on every start after tables are created, so all of them must be idempotent
+var migrations = []string{
+	"ALTER TABLE files ADD COLUMN IF NOT EXISTS size BIGINT NOT NULL DEFAULT 0",
+	"ALTER TABLE chunks ADD COLUMN IF NOT EXISTS bucket TEXT",
+	// legacy chunks are named <bucket>_<seqnum>_<file>, the whole name is matched, as names of buckets may be prefixes
+	// of each other. Names are not put into the regex, they may contain its special characters
+	`UPDATE chunks SET bucket = files.bucket FROM files WHERE chunks.bucket IS NULL AND chunks.file = files.file
+		AND starts_with(chunks.chunk, files.bucket || '_') AND right(chunks.chunk, length(files.file) + 1) = '_' || files.file
+		AND substr(chunks.chunk, length(files.bucket) + 2, length(chunks.chunk) - length(files.bucket) - length(files.file) - 2) ~ '^[0-9]+$'`,
+	// buckets used to be stored as rows of files table with NULL file
+	`INSERT INTO buckets (bucket, objects, bytes)
+		SELECT placeholder.bucket, COUNT(files.id), COALESCE(SUM(files.size), 0) FROM files placeholder
+		LEFT JOIN files ON files.bucket = placeholder.bucket AND files.file IS NOT NULL
+		WHERE placeholder.file IS NULL GROUP BY placeholder.bucket
+		ON CONFLICT DO NOTHING`,
+	"DELETE FROM files WHERE file IS NULL",
+	"ALTER TABLE buckets ADD COLUMN IF NOT EXISTS state TEXT NOT NULL DEFAULT 'active'",
+	"ALTER TABLE files ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ NOT NULL DEFAULT now()",
+	"ALTER TABLE files ADD COLUMN IF NOT EXISTS etag TEXT NOT NULL DEFAULT ''",
+	"ALTER TABLE files ADD COLUMN IF NOT EXISTS content_encoding TEXT NOT NULL DEFAULT ''",
+	"ALTER TABLE files ADD COLUMN IF NOT EXISTS content_disposition TEXT NOT NULL DEFAULT ''",
+	"ALTER TABLE files ADD COLUMN IF NOT EXISTS cache_control TEXT NOT NULL DEFAULT ''",
+	"ALTER TABLE files ADD COLUMN IF NOT EXISTS user_metadata TEXT NOT NULL DEFAULT '{}'",
+	// empty tier is replaced with the default tier of config on start
+	"ALTER TABLE files ADD COLUMN IF NOT EXISTS tier TEXT NOT NULL DEFAULT ''",
+	"ALTER TABLE files ADD COLUMN IF NOT EXISTS restored_until TIMESTAMPTZ",
+	"ALTER TABLE lifecycle_rules ADD COLUMN IF NOT EXISTS transition_days INT NOT NULL DEFAULT 0",
+	"ALTER TABLE lifecycle_rules ADD COLUMN IF NOT EXISTS transition_tier TEXT NOT NULL DEFAULT ''",
+	"ALTER TABLE jobs ADD COLUMN IF NOT EXISTS moved_objects BIGINT NOT NULL DEFAULT 0",
+	"ALTER TABLE jobs ADD COLUMN IF NOT EXISTS moved_chunks BIGINT NOT NULL DEFAULT 0",
+	"ALTER TABLE files ADD COLUMN IF NOT EXISTS codec TEXT NOT NULL DEFAULT ''",
+	// sizes are backfilled only when the columns are added: later encrypted files are stored bigger
+	// than they are, so backfill on every start would reset their sizes
+	`DO $$ BEGIN
+		IF NOT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_schema = current_schema() AND table_name = 'files' AND column_name = 'stored_size') THEN
+			ALTER TABLE files ADD COLUMN stored_size BIGINT NOT NULL DEFAULT 0;
+			UPDATE files SET stored_size = size WHERE codec = '';
+		END IF;
+	END $$`,
+	`DO $$ BEGIN
+		IF NOT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_schema = current_schema() AND table_name = 'buckets' AND column_name = 'stored_bytes') THEN
+			ALTER TABLE buckets ADD COLUMN stored_bytes BIGINT NOT NULL DEFAULT 0;
+			UPDATE buckets SET stored_bytes = bytes;
+		END IF;
+	END $$`,
+	"ALTER TABLE files ADD COLUMN IF NOT EXISTS encryption TEXT NOT NULL DEFAULT ''",
+	"ALTER TABLE files ADD COLUMN IF NOT EXISTS key_id TEXT NOT NULL DEFAULT ''",
+	"ALTER TABLE files ADD COLUMN IF NOT EXISTS wrapped_key BYTEA",
+	"ALTER TABLE files ADD COLUMN IF NOT EXISTS customer_key_md5 TEXT NOT NULL DEFAULT ''",
+	"ALTER TABLE buckets ADD COLUMN IF NOT EXISTS policy TEXT NOT NULL DEFAULT ''",
+	// zero quota means unlimited
+	"ALTER TABLE buckets ADD COLUMN IF NOT EXISTS quota_bytes BIGINT NOT NULL DEFAULT 0",
+	"ALTER TABLE buckets ADD COLUMN IF NOT EXISTS quota_objects BIGINT NOT NULL DEFAULT 0",
+	"ALTER TABLE users ADD COLUMN IF NOT EXISTS quota_bytes BIGINT NOT NULL DEFAULT 0",
+	"ALTER TABLE users ADD COLUMN IF NOT EXISTS quota_objects BIGINT NOT NULL DEFAULT 0",
+}
+
 func main() {
-	log.Println("meta service is started")
-	meta_port := common.ReadConfig().Meta_port
+	common.InitLogging(common.MetaServiceName)
+	slog.Info("meta service is started")
+	ctx, stop := common.ShutdownContext()
+	defer stop()
+	config := common.ReadConfig()
+	meta_port := config.Meta_port
 
 	if meta_port == 0 {
-		log.Fatalf("failed to start meta service: meta_port is not specified in config.json")
+		common.Fatal("failed to start meta service: meta_port is not specified in config.json")
 	}
 
 	port := strconv.Itoa(meta_port)
 
 	lis, err := net.Listen("tcp", "0.0.0.0:"+port)
 	if err != nil {
-		log.Fatalf("failed to listen: %v", err)
+		common.Fatal("failed to listen", "error", err)
+	}
+
+	certs, err := common.NewCertReloader(config, common.MetaServiceName)
+	if err != nil {
+		common.Fatal("failed to load certificate", "error", err)
 	}
+	shutdown_tracing, err := common.InitTracing(config, common.MetaServiceName)
+	if err != nil {
+		common.Fatal("failed to set up tracing", "error", err)
+	}
+	defer shutdown_tracing(context.Background())
 
-	grpcServer := grpc.NewServer()
+	metrics := common.NewMetrics(common.MetaServiceName)
+	server_options := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(common.RequestIDServerInterceptor, meta.MetricsInterceptor(metrics)),
+		// continues traces of api service
+		grpc.StatsHandler(otelgrpc.NewServerHandler()),
+	}
+	if certs != nil {
+		// only api service (and admin tool which runs with its certificate) and stat service may call meta service
+		server_options = append(server_options, grpc.Creds(credentials.NewTLS(certs.ServerConfig(common.ApiServiceName, common.StatServiceName))))
+	}
+	grpcServer := grpc.NewServer(server_options...)
 	reflection.Register(grpcServer)
 
-	metaService := meta.NewServer()
+	metaService := meta.NewServer(certs, metrics)
+	metaService.Config = config
 	metapb.RegisterApiWithMetaServiceServer(grpcServer, metaService)
+	// api and stat services check readiness of meta service over gRPC health protocol
+	health_server := health.NewServer()
+	healthpb.RegisterHealthServer(grpcServer, health_server)
+	// health server is serving by default, but meta service isn't till Postgres is opened and migrated
+	meta.SetServingStatus(health_server, healthpb.HealthCheckResponse_NOT_SERVING)
+	// metrics server and jobs are stopped before the pool of connections to Postgres is closed
+	var background sync.WaitGroup
 
-	metaService.DB, err = sql.Open("postgres", dbConnStr)
+	// queries are traced only within traces of requests, so polling of jobs doesn't flood the exporter
+	metaService.DB, err = otelsql.Open("postgres", dbConnStr,
+		otelsql.WithAttributes(semconv.DBSystemPostgreSQL),
+		otelsql.WithSpanOptions(otelsql.SpanOptions{
+			OmitConnResetSession: true,
+			OmitRows:             true,
+			SpanFilter: func(ctx context.Context, _ otelsql.Method, _ string, _ []driver.NamedValue) bool {
+				return trace.SpanContextFromContext(ctx).IsValid()
+			},
+		}),
+	)
 	if err != nil {
-		log.Fatalf("troubles with connecting to db: %v\n", err)
+		common.Fatal("troubles with connecting to db", "error", err)
 	}
 	defer metaService.DB.Close()
+	go metaService.WatchHealth(ctx, health_server)
+	metrics.Register(collectors.NewDBStatsCollector(metaService.DB, dbName))
+
+	// meta service speaks only gRPC, so metrics and health are served on their own port. It's served
+	// before tables are migrated, so health checkers see that meta service is alive, but not ready
+	if config.Meta_metrics_port != 0 {
+		metrics_router := mux.NewRouter()
+		metrics_router.Handle(common.MetricsPath, metrics.Handler()).Methods("GET")
+		common.HandleHealth(metrics_router, metaService.ReadinessChecks())
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			err := common.ListenAndServe(ctx, ":"+strconv.Itoa(config.Meta_metrics_port), metrics_router, config.ShutdownTimeout(), certs, common.MetricsScraperName)
+			if err != nil {
+				slog.Error("failed to serve metrics", "error", err)
+			}
+		}()
+	}
 
 	_, err = metaService.DB.Exec("CREATE TABLE IF NOT EXISTS files " + filesTableSchema)
 	if err != nil {
-		log.Fatalf("troubles with creating files table: %s\n", err)
+		common.Fatal("troubles with creating files table", "error", err)
 	}
 
 	_, err = metaService.DB.Exec("CREATE TABLE IF NOT EXISTS chunks " + chunksTableSchema)
 	if err != nil {
-		log.Fatalf("troubles with creating chunks table: %s\n", err)
+		common.Fatal("troubles with creating chunks table", "error", err)
 	}
 
-	err = grpcServer.Serve(lis)
+	_, err = metaService.DB.Exec("CREATE TABLE IF NOT EXISTS buckets " + bucketsTableSchema)
+	if err != nil {
+		common.Fatal("troubles with creating buckets table", "error", err)
+	}
+
+	_, err = metaService.DB.Exec("CREATE TABLE IF NOT EXISTS tags " + tagsTableSchema)
 	if err != nil {
-		log.Fatalf("meta service failed")
+		common.Fatal("troubles with creating tags table", "error", err)
+	}
+
+	_, err = metaService.DB.Exec("CREATE TABLE IF NOT EXISTS lifecycle_rules " + lifecycleRulesTableSchema)
+	if err != nil {
+		common.Fatal("troubles with creating lifecycle_rules table", "error", err)
+	}
+
+	_, err = metaService.DB.Exec("CREATE TABLE IF NOT EXISTS jobs " + jobsTableSchema)
+	if err != nil {
+		common.Fatal("troubles with creating jobs table", "error", err)
+	}
+
+	_, err = metaService.DB.Exec("CREATE TABLE IF NOT EXISTS users " + usersTableSchema)
+	if err != nil {
+		common.Fatal("troubles with creating users table", "error", err)
+	}
+
+	_, err = metaService.DB.Exec("CREATE TABLE IF NOT EXISTS access_keys " + accessKeysTableSchema)
+	if err != nil {
+		common.Fatal("troubles with creating access_keys table", "error", err)
+	}
+
+	_, err = metaService.DB.Exec("CREATE TABLE IF NOT EXISTS group_members " + groupMembersTableSchema)
+	if err != nil {
+		common.Fatal("troubles with creating group_members table", "error", err)
+	}
+
+	for _, migration := range migrations {
+		_, err = metaService.DB.Exec(migration)
+		if err != nil {
+			common.Fatal("troubles with applying migration", "migration", migration, "error", err)
+		}
+	}
+
+	// files which were stored before tiers appeared are on shards of the default tier
+	_, err = metaService.DB.Exec("UPDATE files SET tier = $1 WHERE tier = ''", config.GetDefaultTier())
+	if err != nil {
+		common.Fatal("troubles with setting tier of old files", "error", err)
+	}
+
+	metaService.SetMigrated()
+	// job which is interrupted by shutdown is retried after its lease expires
+	background.Add(1)
+	go func() {
+		defer background.Done()
+		metaService.RunJobs(ctx)
+	}()
+
+	stopped := make(chan struct{})
+	go func() {
+		<-ctx.Done()
+		// api and stat services see that meta service is not serving before it stops taking calls
+		health_server.Shutdown()
+		common.GracefulStop(grpcServer, config.ShutdownTimeout())
+		close(stopped)
+	}()
+	err = grpcServer.Serve(lis)
+	if ctx.Err() == nil {
+		common.Fatal("meta service failed", "error", err)
 	}
+	<-stopped
+	background.Wait()
+	slog.Info("meta service is stopped")
 }
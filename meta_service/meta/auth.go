@@ -0,0 +1,202 @@
+package meta
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	metapb "meta/proto"
+	"regexp"
+	"time"
+
+	"github.com/lib/pq"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// ids of access keys look like AWS ones: 20 upper case letters and digits starting with AK
+	accessKeyIDAlphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZ234567"
+	accessKeyIDLength   = 20
+	// 30 random bytes are 40 symbols of secret in base64, as in AWS
+	secretAccessKeyBytes = 30
+)
+
+// same symbols as allowed in names of IAM users
+var userNameRegexp = regexp.MustCompile(`^[A-Za-z0-9_+=,.@-]{1,64}$`)
+
+func newAccessKeyID() (string, error) {
+	random := make([]byte, accessKeyIDLength-2)
+	_, err := rand.Read(random)
+	if err != nil {
+		return "", err
+	}
+	id := []byte("AK")
+	for _, b := range random {
+		id = append(id, accessKeyIDAlphabet[int(b)%len(accessKeyIDAlphabet)])
+	}
+	return string(id), nil
+}
+
+func newSecretAccessKey() (string, error) {
+	random := make([]byte, secretAccessKeyBytes)
+	_, err := rand.Read(random)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(random), nil
+}
+
+func (s *Server) CreateUser(ctx context.Context, req *metapb.CreateUserReq) (*metapb.CreateUserResp, error) {
+	if !userNameRegexp.MatchString(req.User) {
+		return &metapb.CreateUserResp{}, status.Errorf(codes.InvalidArgument, "name of user must consist of 1-64 letters, digits and symbols _+=,.@-, got %q", req.User)
+	}
+
+	res, err := s.DB.ExecContext(ctx, "INSERT INTO users (name) VALUES ($1) ON CONFLICT DO NOTHING", req.User)
+	if err != nil {
+		return &metapb.CreateUserResp{}, status.Errorf(codes.Internal, "failed to insert row into users table while creating user %s: %v", req.User, err)
+	}
+	created, err := res.RowsAffected()
+	if err != nil {
+		return &metapb.CreateUserResp{}, status.Errorf(codes.Internal, "failed to insert row into users table while creating user %s: %v", req.User, err)
+	}
+	if created == 0 {
+		return &metapb.CreateUserResp{}, status.Errorf(codes.AlreadyExists, "user with name %s already exists", req.User)
+	}
+
+	return &metapb.CreateUserResp{}, nil
+}
+
+func (s *Server) CreateAccessKey(ctx context.Context, req *metapb.CreateAccessKeyReq) (*metapb.CreateAccessKeyResp, error) {
+	access_key_id, err := newAccessKeyID()
+	if err != nil {
+		return &metapb.CreateAccessKeyResp{}, status.Errorf(codes.Internal, "failed to generate access key for user %s: %v", req.User, err)
+	}
+	secret_access_key, err := newSecretAccessKey()
+	if err != nil {
+		return &metapb.CreateAccessKeyResp{}, status.Errorf(codes.Internal, "failed to generate access key for user %s: %v", req.User, err)
+	}
+
+	var created_at time.Time
+	err = s.DB.QueryRowContext(ctx, "INSERT INTO access_keys (access_key_id, secret_access_key, user_name) VALUES ($1, $2, $3) RETURNING created_at",
+		access_key_id, secret_access_key, req.User).Scan(&created_at)
+	var pq_err *pq.Error
+	if errors.As(err, &pq_err) && pq_err.Code.Name() == "foreign_key_violation" {
+		return &metapb.CreateAccessKeyResp{}, status.Errorf(codes.NotFound, "user with name %s does not exist", req.User)
+	} else if err != nil {
+		return &metapb.CreateAccessKeyResp{}, status.Errorf(codes.Internal, "failed to insert row into access_keys table while creating key for user %s: %v", req.User, err)
+	}
+
+	key := &metapb.AccessKey{AccessKeyId: access_key_id, SecretAccessKey: secret_access_key, User: req.User, CreatedAt: created_at.Unix()}
+	return &metapb.CreateAccessKeyResp{Key: key}, nil
+}
+
+func scanAccessKey(row rowScanner) (*metapb.AccessKey, error) {
+	key := &metapb.AccessKey{}
+	var created_at time.Time
+	var revoked_at sql.NullTime
+	err := row.Scan(&key.AccessKeyId, &key.SecretAccessKey, &key.User, &created_at, &revoked_at)
+	if err != nil {
+		return nil, err
+	}
+	key.CreatedAt = created_at.Unix()
+	if revoked_at.Valid {
+		key.RevokedAt = revoked_at.Time.Unix()
+	}
+	return key, nil
+}
+
+// GetAccessKey returns revoked keys too, the caller must check RevokedAt
+func (s *Server) GetAccessKey(ctx context.Context, req *metapb.GetAccessKeyReq) (*metapb.GetAccessKeyResp, error) {
+	row := s.DB.QueryRowContext(ctx, "SELECT access_key_id, secret_access_key, user_name, created_at, revoked_at FROM access_keys WHERE access_key_id = $1",
+		req.AccessKeyId)
+	key, err := scanAccessKey(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return &metapb.GetAccessKeyResp{}, status.Errorf(codes.NotFound, "access key %s does not exist", req.AccessKeyId)
+	} else if err != nil {
+		return &metapb.GetAccessKeyResp{}, status.Errorf(codes.Internal, "unknown error while getting access key %s: %v", req.AccessKeyId, err)
+	}
+
+	err = s.DB.QueryRowContext(ctx, "SELECT COALESCE(array_agg(group_name ORDER BY group_name), '{}') FROM group_members WHERE user_name = $1", key.User).
+		Scan(pq.Array(&key.Groups))
+	if err != nil {
+		return &metapb.GetAccessKeyResp{}, status.Errorf(codes.Internal, "unknown error while getting groups of user %s: %v", key.User, err)
+	}
+
+	return &metapb.GetAccessKeyResp{Key: key}, nil
+}
+
+// RevokeAccessKey keeps the revoked key, so its id is never reused and stays visible in the list of keys
+func (s *Server) RevokeAccessKey(ctx context.Context, req *metapb.RevokeAccessKeyReq) (*metapb.RevokeAccessKeyResp, error) {
+	res, err := s.DB.ExecContext(ctx, "UPDATE access_keys SET revoked_at = COALESCE(revoked_at, now()) WHERE access_key_id = $1", req.AccessKeyId)
+	if err != nil {
+		return &metapb.RevokeAccessKeyResp{}, status.Errorf(codes.Internal, "failed to update access_keys table while revoking access key %s: %v", req.AccessKeyId, err)
+	}
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return &metapb.RevokeAccessKeyResp{}, status.Errorf(codes.Internal, "failed to update access_keys table while revoking access key %s: %v", req.AccessKeyId, err)
+	}
+	if updated == 0 {
+		return &metapb.RevokeAccessKeyResp{}, status.Errorf(codes.NotFound, "access key %s does not exist", req.AccessKeyId)
+	}
+
+	return &metapb.RevokeAccessKeyResp{}, nil
+}
+
+// ListAccessKeys never returns secrets of keys
+func (s *Server) ListAccessKeys(ctx context.Context, req *metapb.ListAccessKeysReq) (*metapb.ListAccessKeysResp, error) {
+	rows, err := s.DB.QueryContext(ctx, `SELECT access_key_id, '', user_name, created_at, revoked_at FROM access_keys
+		WHERE $1 = '' OR user_name = $1 ORDER BY user_name, created_at`, req.User)
+	if err != nil {
+		return &metapb.ListAccessKeysResp{}, status.Errorf(codes.Internal, "failed while processing SELECT query while listing access keys: %v", err)
+	}
+	defer rows.Close()
+
+	resp := &metapb.ListAccessKeysResp{}
+	for rows.Next() {
+		key, err := scanAccessKey(rows)
+		if err != nil {
+			return &metapb.ListAccessKeysResp{}, status.Errorf(codes.Internal, "failed while scanning rows while listing access keys: %v", err)
+		}
+		resp.Keys = append(resp.Keys, key)
+	}
+	err = rows.Err()
+	if err != nil {
+		return &metapb.ListAccessKeysResp{}, status.Errorf(codes.Internal, "failed while reading rows while listing access keys: %v", err)
+	}
+
+	return resp, nil
+}
+
+func (s *Server) AddUserToGroup(ctx context.Context, req *metapb.AddUserToGroupReq) (*metapb.AddUserToGroupResp, error) {
+	if !userNameRegexp.MatchString(req.GroupName) {
+		return &metapb.AddUserToGroupResp{}, status.Errorf(codes.InvalidArgument, "name of group must consist of 1-64 letters, digits and symbols _+=,.@-, got %q", req.GroupName)
+	}
+
+	_, err := s.DB.ExecContext(ctx, "INSERT INTO group_members (group_name, user_name) VALUES ($1, $2) ON CONFLICT DO NOTHING", req.GroupName, req.User)
+	var pq_err *pq.Error
+	if errors.As(err, &pq_err) && pq_err.Code.Name() == "foreign_key_violation" {
+		return &metapb.AddUserToGroupResp{}, status.Errorf(codes.NotFound, "user with name %s does not exist", req.User)
+	} else if err != nil {
+		return &metapb.AddUserToGroupResp{}, status.Errorf(codes.Internal, "failed to insert row into group_members table while adding user %s to group %s: %v", req.User, req.GroupName, err)
+	}
+
+	return &metapb.AddUserToGroupResp{}, nil
+}
+
+func (s *Server) RemoveUserFromGroup(ctx context.Context, req *metapb.RemoveUserFromGroupReq) (*metapb.RemoveUserFromGroupResp, error) {
+	res, err := s.DB.ExecContext(ctx, "DELETE FROM group_members WHERE group_name = $1 AND user_name = $2", req.GroupName, req.User)
+	if err != nil {
+		return &metapb.RemoveUserFromGroupResp{}, status.Errorf(codes.Internal, "failed while processing DELETE query while removing user %s from group %s: %v", req.User, req.GroupName, err)
+	}
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return &metapb.RemoveUserFromGroupResp{}, status.Errorf(codes.Internal, "failed while processing DELETE query while removing user %s from group %s: %v", req.User, req.GroupName, err)
+	}
+	if deleted == 0 {
+		return &metapb.RemoveUserFromGroupResp{}, status.Errorf(codes.NotFound, "user %s is not a member of group %s", req.User, req.GroupName)
+	}
+
+	return &metapb.RemoveUserFromGroupResp{}, nil
+}
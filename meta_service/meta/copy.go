@@ -0,0 +1,252 @@
+package meta
+
+import (
+	"common"
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	metapb "meta/proto"
+	"sort"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func (s *Server) CopyFile(ctx context.Context, req *metapb.CopyFileReq) (*metapb.CopyFileResp, error) {
+	if req.SrcBucket == req.DstBucket && req.SrcFile == req.DstFile {
+		return &metapb.CopyFileResp{}, status.Errorf(codes.InvalidArgument, "file %s in bucket %s can't be copied into itself", req.SrcFile, req.SrcBucket)
+	}
+
+	if req.Move {
+		return s.moveFile(ctx, req)
+	}
+	return s.copyFile(ctx, req)
+}
+
+// lockBuckets locks rows of the buckets in the fixed order, so concurrent transactions can't deadlock.
+// It returns states of the buckets
+func lockBuckets(ctx context.Context, tx *sql.Tx, buckets ...string) (map[string]string, error) {
+	sorted := append([]string{}, buckets...)
+	sort.Strings(sorted)
+
+	states := make(map[string]string, len(sorted))
+	for _, bucket := range sorted {
+		if _, locked := states[bucket]; locked {
+			continue
+		}
+		state, err := lockBucket(ctx, tx, bucket)
+		if err != nil {
+			return nil, err
+		}
+		states[bucket] = state
+	}
+	return states, nil
+}
+
+// moveFile renames the file. Names of chunks don't depend on the name of the file,
+// so only metadata has to be rewritten
+func (s *Server) moveFile(ctx context.Context, req *metapb.CopyFileReq) (*metapb.CopyFileResp, error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return &metapb.CopyFileResp{}, status.Errorf(codes.Internal, "failed to begin tx while moving file %s from bucket %s", req.SrcFile, req.SrcBucket)
+	}
+	defer tx.Rollback()
+
+	states, err := lockBuckets(ctx, tx, req.SrcBucket, req.DstBucket)
+	if err != nil {
+		return &metapb.CopyFileResp{}, status.Errorf(codes.Internal, "unknown error while moving file %s from bucket %s: %v", req.SrcFile, req.SrcBucket, err)
+	}
+	if states[req.DstBucket] == "" {
+		return &metapb.CopyFileResp{}, status.Errorf(codes.NotFound, "bucket with name %s does not exist", req.DstBucket)
+	}
+	if states[req.DstBucket] == bucketStateDeleting {
+		return &metapb.CopyFileResp{}, status.Errorf(codes.FailedPrecondition, "bucket %s is being deleted", req.DstBucket)
+	}
+	// the job may have removed chunks of the file already
+	if states[req.SrcBucket] == bucketStateDeleting {
+		return &metapb.CopyFileResp{}, status.Errorf(codes.FailedPrecondition, "bucket %s is being deleted", req.SrcBucket)
+	}
+
+	var size, stored_size int64
+	err = tx.QueryRowContext(ctx, "SELECT size, stored_size FROM files WHERE bucket = $1 AND file = $2", req.SrcBucket, req.SrcFile).Scan(&size, &stored_size)
+	if errors.Is(err, sql.ErrNoRows) {
+		return &metapb.CopyFileResp{}, status.Errorf(codes.NotFound, "bucket with name %s does not exist or file with name %s does not exist", req.SrcBucket, req.SrcFile)
+	} else if err != nil {
+		return &metapb.CopyFileResp{}, status.Errorf(codes.Internal, "unknown error while moving file %s from bucket %s: %v", req.SrcFile, req.SrcBucket, err)
+	}
+
+	err = checkFileAbsent(ctx, tx, req.DstBucket, req.DstFile)
+	if err != nil {
+		return &metapb.CopyFileResp{}, err
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE files SET bucket = $3, file = $4 WHERE bucket = $1 AND file = $2", req.SrcBucket, req.SrcFile, req.DstBucket, req.DstFile)
+	if err != nil {
+		return &metapb.CopyFileResp{}, status.Errorf(codes.Internal, "failed to update files table while moving file %s from bucket %s", req.SrcFile, req.SrcBucket)
+	}
+	_, err = tx.ExecContext(ctx, "UPDATE chunks SET bucket = $3, file = $4 WHERE bucket = $1 AND file = $2", req.SrcBucket, req.SrcFile, req.DstBucket, req.DstFile)
+	if err != nil {
+		return &metapb.CopyFileResp{}, status.Errorf(codes.Internal, "failed to update chunks table while moving file %s from bucket %s", req.SrcFile, req.SrcBucket)
+	}
+	_, err = tx.ExecContext(ctx, "UPDATE tags SET bucket = $3, file = $4 WHERE bucket = $1 AND file = $2", req.SrcBucket, req.SrcFile, req.DstBucket, req.DstFile)
+	if err != nil {
+		return &metapb.CopyFileResp{}, status.Errorf(codes.Internal, "failed to update tags table while moving file %s from bucket %s", req.SrcFile, req.SrcBucket)
+	}
+
+	if req.SrcBucket != req.DstBucket {
+		_, err = tx.ExecContext(ctx, "UPDATE buckets SET objects = objects - 1, bytes = bytes - $2, stored_bytes = stored_bytes - $3 WHERE bucket = $1",
+			req.SrcBucket, size, stored_size)
+		if err != nil {
+			return &metapb.CopyFileResp{}, status.Errorf(codes.Internal, "failed to update counters of bucket %s while moving file %s", req.SrcBucket, req.SrcFile)
+		}
+		_, err = tx.ExecContext(ctx, "UPDATE buckets SET objects = objects + 1, bytes = bytes + $2, stored_bytes = stored_bytes + $3 WHERE bucket = $1",
+			req.DstBucket, size, stored_size)
+		if err != nil {
+			return &metapb.CopyFileResp{}, status.Errorf(codes.Internal, "failed to update counters of bucket %s while moving file %s", req.DstBucket, req.SrcFile)
+		}
+		err = checkQuotas(ctx, tx, req.DstBucket)
+		if err != nil {
+			return &metapb.CopyFileResp{}, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return &metapb.CopyFileResp{}, status.Errorf(codes.Internal, "failed to commit tx while moving file %s from bucket %s", req.SrcFile, req.SrcBucket)
+	}
+
+	return &metapb.CopyFileResp{}, nil
+}
+
+// copyFile creates new file which points to copies of chunks of the source file,
+// chunks are copied by shards themselves
+func (s *Server) copyFile(ctx context.Context, req *metapb.CopyFileReq) (*metapb.CopyFileResp, error) {
+	// the job may have removed chunks of the file already, it's checked again when the copy is saved
+	var src_state string
+	err := s.DB.QueryRowContext(ctx, "SELECT state FROM buckets WHERE bucket = $1", req.SrcBucket).Scan(&src_state)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return &metapb.CopyFileResp{}, status.Errorf(codes.Internal, "unknown error while copying file %s from bucket %s: %v", req.SrcFile, req.SrcBucket, err)
+	}
+	if src_state == bucketStateDeleting {
+		return &metapb.CopyFileResp{}, status.Errorf(codes.FailedPrecondition, "bucket %s is being deleted", req.SrcBucket)
+	}
+
+	src_chunks, err := s.GetFileChunks(ctx, &metapb.GetFileChunksReq{Bucket: req.SrcBucket, File: req.SrcFile})
+	if err != nil {
+		return &metapb.CopyFileResp{}, err
+	}
+	if src_chunks.Archived {
+		return &metapb.CopyFileResp{}, status.Errorf(codes.FailedPrecondition, "file %s in bucket %s is archived, it must be restored before copying", req.SrcFile, req.SrcBucket)
+	}
+
+	// copy of the chunk is stored on the same shard: chunk is placed by its content, which is the same
+	object_id := common.NewObjectID()
+	dst_chunks := make([]*metapb.ChunkFilenameWithShard, len(src_chunks.Chunks))
+	for i, chunk := range src_chunks.Chunks {
+		dst_chunks[i] = &metapb.ChunkFilenameWithShard{Filename: common.GetChunkName(object_id, i), Shard: chunk.Shard}
+	}
+
+	err = inParallel(len(dst_chunks), func(i int) error {
+		return s.copyChunk(ctx, src_chunks.Chunks[i], dst_chunks[i])
+	})
+	if err == nil {
+		err = s.insertFileCopy(ctx, req, dst_chunks)
+	}
+
+	if err != nil {
+		// context of request may be already cancelled, but copied chunks must be removed anyway
+		cleanup_err := s.deleteChunks(context.WithoutCancel(ctx), dst_chunks)
+		if cleanup_err != nil {
+			slog.ErrorContext(ctx, "failed to remove copied chunks", "bucket", req.DstBucket, "file", req.DstFile, "error", cleanup_err)
+		}
+		if _, is_status := status.FromError(err); !is_status {
+			err = status.Errorf(codes.Internal, "failed to copy chunks of file %s from bucket %s: %v", req.SrcFile, req.SrcBucket, err)
+		}
+		return &metapb.CopyFileResp{}, err
+	}
+
+	return &metapb.CopyFileResp{}, nil
+}
+
+// insertFileCopy saves metadata of the copy, source file must still exist at this moment
+func (s *Server) insertFileCopy(ctx context.Context, req *metapb.CopyFileReq, dst_chunks []*metapb.ChunkFilenameWithShard) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to begin tx while copying file %s from bucket %s", req.SrcFile, req.SrcBucket)
+	}
+	defer tx.Rollback()
+
+	states, err := lockBuckets(ctx, tx, req.SrcBucket, req.DstBucket)
+	if err != nil {
+		return status.Errorf(codes.Internal, "unknown error while copying file %s into bucket %s: %v", req.SrcFile, req.DstBucket, err)
+	}
+	if states[req.DstBucket] == "" {
+		return status.Errorf(codes.NotFound, "bucket with name %s does not exist", req.DstBucket)
+	}
+	if states[req.DstBucket] == bucketStateDeleting {
+		return status.Errorf(codes.FailedPrecondition, "bucket %s is being deleted", req.DstBucket)
+	}
+	if states[req.SrcBucket] == bucketStateDeleting {
+		return status.Errorf(codes.FailedPrecondition, "bucket %s is being deleted", req.SrcBucket)
+	}
+
+	err = checkFileAbsent(ctx, tx, req.DstBucket, req.DstFile)
+	if err != nil {
+		return err
+	}
+
+	var size, stored_size int64
+	err = tx.QueryRowContext(ctx, `INSERT INTO files (bucket, file, content_type, size, etag, content_encoding, content_disposition, cache_control, user_metadata,
+			tier, restored_until, codec, stored_size, encryption, key_id, wrapped_key, customer_key_md5)
+		SELECT $3, $4, content_type, size, etag, content_encoding, content_disposition, cache_control, user_metadata,
+			tier, restored_until, codec, stored_size, encryption, key_id, wrapped_key, customer_key_md5
+		FROM files WHERE bucket = $1 AND file = $2 RETURNING size, stored_size`,
+		req.SrcBucket, req.SrcFile, req.DstBucket, req.DstFile).Scan(&size, &stored_size)
+	if errors.Is(err, sql.ErrNoRows) {
+		return status.Errorf(codes.NotFound, "file %s was deleted from bucket %s while copying", req.SrcFile, req.SrcBucket)
+	} else if err != nil {
+		return status.Errorf(codes.Internal, "failed to insert row into files table while copying file %s from bucket %s", req.SrcFile, req.SrcBucket)
+	}
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO tags (bucket, file, key, value) SELECT $3, $4, key, value FROM tags WHERE bucket = $1 AND file = $2",
+		req.SrcBucket, req.SrcFile, req.DstBucket, req.DstFile)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to insert rows into tags table while copying file %s from bucket %s", req.SrcFile, req.SrcBucket)
+	}
+
+	for _, chunk := range dst_chunks {
+		_, err = tx.ExecContext(ctx, "INSERT INTO chunks (bucket, file, chunk, shard) VALUES ($1, $2, $3, $4)", req.DstBucket, req.DstFile, chunk.Filename, chunk.Shard)
+		if err != nil {
+			return status.Errorf(codes.Internal, "failed to insert row into chunks table while copying file %s into bucket %s", req.SrcFile, req.DstBucket)
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE buckets SET objects = objects + 1, bytes = bytes + $2, stored_bytes = stored_bytes + $3 WHERE bucket = $1",
+		req.DstBucket, size, stored_size)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to update counters of bucket %s while copying file %s", req.DstBucket, req.SrcFile)
+	}
+	err = checkQuotas(ctx, tx, req.DstBucket)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to commit tx while copying file %s from bucket %s", req.SrcFile, req.SrcBucket)
+	}
+	return nil
+}
+
+func checkFileAbsent(ctx context.Context, tx *sql.Tx, bucket, file string) error {
+	count := 0
+	err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM files WHERE bucket = $1 AND file = $2", bucket, file).Scan(&count)
+	if err != nil {
+		return status.Errorf(codes.Internal, "unknown error while checking file %s in bucket %s: %v", file, bucket, err)
+	}
+	if count > 0 {
+		return status.Errorf(codes.AlreadyExists, "file with name %s already exists in bucket %s", file, bucket)
+	}
+	return nil
+}
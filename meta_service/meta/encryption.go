@@ -0,0 +1,88 @@
+package meta
+
+import (
+	"common"
+	"context"
+	"errors"
+	metapb "meta/proto"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// validateEncryption checks that the file carries everything which is needed to decrypt it later.
+// Chunks themselves are encrypted by the api service, meta service never sees data keys in plaintext
+func validateEncryption(req *metapb.CreateFileReq) error {
+	switch req.Encryption {
+	case "":
+		if req.KeyId != "" || len(req.WrappedKey) != 0 || req.CustomerKeyMd5 != "" {
+			return errors.New("keys are passed for file which is not encrypted")
+		}
+	case common.EncryptionAES256:
+		if req.KeyId == "" || len(req.WrappedKey) == 0 {
+			return errors.New("id of master key and wrapped data key are required")
+		}
+	case common.EncryptionCustomer:
+		if req.CustomerKeyMd5 == "" {
+			return errors.New("md5 of the key of the client is required")
+		}
+		if req.KeyId != "" || len(req.WrappedKey) != 0 {
+			return errors.New("key of the client must not be wrapped")
+		}
+	default:
+		return errors.New("unknown encryption " + req.Encryption)
+	}
+	return nil
+}
+
+// ListWrappedKeys is used by key rotation to find data keys which are still wrapped with old master keys
+func (s *Server) ListWrappedKeys(ctx context.Context, req *metapb.ListWrappedKeysReq) (*metapb.ListWrappedKeysResp, error) {
+	limit := int(req.Limit)
+	if limit <= 0 || limit > maxFilesInBatch {
+		limit = maxFilesInBatch
+	}
+
+	rows, err := s.DB.QueryContext(ctx, `SELECT id, bucket, file, key_id, wrapped_key FROM files
+		WHERE encryption = $1 AND key_id <> $2 AND id > $3 ORDER BY id LIMIT $4`,
+		common.EncryptionAES256, req.ExcludeKeyId, req.AfterId, limit)
+	if err != nil {
+		return &metapb.ListWrappedKeysResp{}, status.Errorf(codes.Internal, "failed while processing SELECT query while listing wrapped keys: %v", err)
+	}
+	defer rows.Close()
+
+	resp := &metapb.ListWrappedKeysResp{}
+	for rows.Next() {
+		key := &metapb.WrappedKey{}
+		err = rows.Scan(&key.Id, &key.Bucket, &key.File, &key.KeyId, &key.WrappedKey)
+		if err != nil {
+			return &metapb.ListWrappedKeysResp{}, status.Errorf(codes.Internal, "failed while scanning rows while listing wrapped keys: %v", err)
+		}
+		resp.Keys = append(resp.Keys, key)
+	}
+	err = rows.Err()
+	if err != nil {
+		return &metapb.ListWrappedKeysResp{}, status.Errorf(codes.Internal, "failed while reading rows while listing wrapped keys: %v", err)
+	}
+
+	return resp, nil
+}
+
+// RewrapKey replaces wrapped data key of one file. Data key itself is not changed, so chunks are not touched
+func (s *Server) RewrapKey(ctx context.Context, req *metapb.RewrapKeyReq) (*metapb.RewrapKeyResp, error) {
+	if req.NewKeyId == "" || len(req.NewWrappedKey) == 0 {
+		return &metapb.RewrapKeyResp{}, status.Errorf(codes.InvalidArgument, "id of master key and wrapped data key are required")
+	}
+
+	result, err := s.DB.ExecContext(ctx, `UPDATE files SET key_id = $4, wrapped_key = $5
+		WHERE id = $1 AND encryption = $6 AND key_id = $2 AND wrapped_key = $3`,
+		req.Id, req.KeyId, req.WrappedKey, req.NewKeyId, req.NewWrappedKey, common.EncryptionAES256)
+	if err != nil {
+		return &metapb.RewrapKeyResp{}, status.Errorf(codes.Internal, "failed to update files table while rewrapping key of file with id %d: %v", req.Id, err)
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return &metapb.RewrapKeyResp{}, status.Errorf(codes.Internal, "failed to update files table while rewrapping key of file with id %d: %v", req.Id, err)
+	}
+
+	return &metapb.RewrapKeyResp{Rewrapped: updated > 0}, nil
+}
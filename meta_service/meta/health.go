@@ -0,0 +1,59 @@
+package meta
+
+import (
+	"common"
+	"context"
+	"errors"
+	metapb "meta/proto"
+	"time"
+
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// healthCheckInterval is how often serving status of gRPC health protocol is updated
+const healthCheckInterval = 5 * time.Second
+
+// SetMigrated marks that tables are created and migrated, meta service isn't ready before it
+func (s *Server) SetMigrated() {
+	s.migrated.Store(true)
+}
+
+// ReadinessChecks are dependencies of meta service: Postgres must be reachable and migrated
+func (s *Server) ReadinessChecks() map[string]common.ReadinessCheck {
+	return map[string]common.ReadinessCheck{
+		"postgres": s.DB.PingContext,
+		"migrations": func(context.Context) error {
+			if !s.migrated.Load() {
+				return errors.New("migrations are not applied yet")
+			}
+			return nil
+		},
+	}
+}
+
+// SetServingStatus sets status of gRPC health protocol both for the whole server and for ApiWithMetaService
+func SetServingStatus(health_server *health.Server, serving_status healthpb.HealthCheckResponse_ServingStatus) {
+	health_server.SetServingStatus("", serving_status)
+	health_server.SetServingStatus(metapb.ApiWithMetaService_ServiceDesc.ServiceName, serving_status)
+}
+
+// WatchHealth sets serving status of gRPC health protocol by readiness checks till ctx is cancelled.
+// It must be started after the pool of connections to Postgres is opened
+func (s *Server) WatchHealth(ctx context.Context, health_server *health.Server) {
+	ticker := time.NewTicker(healthCheckInterval)
+	defer ticker.Stop()
+	for {
+		serving_status := healthpb.HealthCheckResponse_NOT_SERVING
+		if common.CheckReadiness(ctx, s.ReadinessChecks()).Ready {
+			serving_status = healthpb.HealthCheckResponse_SERVING
+		}
+		SetServingStatus(health_server, serving_status)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
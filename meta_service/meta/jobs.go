@@ -0,0 +1,238 @@
+package meta
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	metapb "meta/proto"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	jobKindDeleteBucket = "delete_bucket"
+
+	jobStatePending = "pending"
+	jobStateRunning = "running"
+	jobStateDone    = "done"
+
+	jobsPollInterval = 5 * time.Second
+	// running job is owned by one meta instance till its lease expires. After that (e.g. when
+	// the instance was restarted) the job is picked up again by any instance
+	jobLease     = time.Minute
+	jobBatchSize = 100
+)
+
+// startDeleteBucketJob marks bucket as deleting and enqueues job which removes all of its files.
+// If bucket is already being deleted, id of the existing job is returned
+func startDeleteBucketJob(ctx context.Context, tx *sql.Tx, bucket, state string) (int64, error) {
+	var job_id int64
+	if state == bucketStateDeleting {
+		err := tx.QueryRowContext(ctx, "SELECT id FROM jobs WHERE kind = $1 AND bucket = $2 AND state <> $3 ORDER BY id DESC LIMIT 1", jobKindDeleteBucket, bucket, jobStateDone).Scan(&job_id)
+		if err == nil {
+			return job_id, nil
+		} else if !errors.Is(err, sql.ErrNoRows) {
+			return 0, err
+		}
+	}
+
+	_, err := tx.ExecContext(ctx, "UPDATE buckets SET state = $2 WHERE bucket = $1", bucket, bucketStateDeleting)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.QueryRowContext(ctx, "INSERT INTO jobs (kind, bucket, state) VALUES ($1, $2, $3) RETURNING id", jobKindDeleteBucket, bucket, jobStatePending).Scan(&job_id)
+	return job_id, err
+}
+
+func (s *Server) GetJob(ctx context.Context, req *metapb.GetJobReq) (*metapb.GetJobResp, error) {
+	var created_at, updated_at time.Time
+	job := &metapb.JobInfo{}
+
+	err := s.DB.QueryRowContext(ctx, `SELECT id, kind, bucket, state, created_at, updated_at, deleted_objects, deleted_chunks, moved_objects, moved_chunks, error
+		FROM jobs WHERE id = $1`, req.Id).
+		Scan(&job.Id, &job.Kind, &job.Bucket, &job.State, &created_at, &updated_at, &job.DeletedObjects, &job.DeletedChunks, &job.MovedObjects, &job.MovedChunks, &job.Error)
+	if errors.Is(err, sql.ErrNoRows) {
+		return &metapb.GetJobResp{}, status.Errorf(codes.NotFound, "job with id %d does not exist", req.Id)
+	} else if err != nil {
+		return &metapb.GetJobResp{}, status.Errorf(codes.Internal, "unknown error while getting job %d: %v", req.Id, err)
+	}
+
+	job.CreatedAt = created_at.Unix()
+	job.UpdatedAt = updated_at.Unix()
+	return &metapb.GetJobResp{Job: job}, nil
+}
+
+// RunJobs executes background jobs till ctx is cancelled
+func (s *Server) RunJobs(ctx context.Context) {
+	ticker := time.NewTicker(jobsPollInterval)
+	defer ticker.Stop()
+
+	next_lifecycle_check := time.Now()
+	for {
+		if !time.Now().Before(next_lifecycle_check) {
+			s.enqueueLifecycleJobs(ctx)
+			next_lifecycle_check = time.Now().Add(lifecycleCheckInterval)
+		}
+
+		for s.runNextJob(ctx) {
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// runNextJob claims one job and runs it till completion. It returns false if there was nothing to run
+// or the job failed, failed job is retried after its lease expires
+func (s *Server) runNextJob(ctx context.Context) bool {
+	var job_id int64
+	var kind, bucket string
+
+	err := s.DB.QueryRowContext(ctx, `UPDATE jobs SET state = $1, lease_until = now() + make_interval(secs => $2), updated_at = now()
+		WHERE id = (SELECT id FROM jobs WHERE state <> $3 AND lease_until < now() ORDER BY id LIMIT 1 FOR UPDATE SKIP LOCKED)
+		RETURNING id, kind, bucket`, jobStateRunning, jobLease.Seconds(), jobStateDone).Scan(&job_id, &kind, &bucket)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false
+	} else if err != nil {
+		slog.ErrorContext(ctx, "failed to claim job", "error", err)
+		return false
+	}
+
+	slog.InfoContext(ctx, "running job", "job_id", job_id, "kind", kind, "bucket", bucket)
+	switch kind {
+	case jobKindDeleteBucket:
+		err = s.runDeleteBucketJob(ctx, job_id, bucket)
+	case jobKindExpireFiles:
+		err = s.runExpireFilesJob(ctx, job_id, bucket)
+	case jobKindTransitionFiles:
+		err = s.runTransitionFilesJob(ctx, job_id, bucket)
+	default:
+		err = fmt.Errorf("unknown kind of job: %s", kind)
+	}
+
+	if err != nil {
+		slog.ErrorContext(ctx, "job failed, it will be retried", "job_id", job_id, "error", err)
+		_, err = s.DB.ExecContext(ctx, "UPDATE jobs SET error = $2, updated_at = now() WHERE id = $1", job_id, err.Error())
+		if err != nil {
+			slog.ErrorContext(ctx, "failed to save error of job", "job_id", job_id, "error", err)
+		}
+		return false
+	}
+
+	slog.InfoContext(ctx, "job is done", "job_id", job_id)
+	return true
+}
+
+func (s *Server) runDeleteBucketJob(ctx context.Context, job_id int64, bucket string) error {
+	for {
+		done, err := s.deleteBucketBatch(ctx, job_id, bucket)
+		if err != nil || done {
+			return err
+		}
+	}
+}
+
+// deleteBucketBatch removes the next batch of files of the bucket, it returns true when there are no files left
+func (s *Server) deleteBucketBatch(ctx context.Context, job_id int64, bucket string) (bool, error) {
+	files, chunks, err := s.selectFilesBatch(ctx, bucket)
+	if err != nil {
+		return false, err
+	}
+
+	if len(files) == 0 {
+		return true, s.finishDeleteBucketJob(ctx, job_id, bucket)
+	}
+
+	// chunks are removed before metadata, so if meta crashes in between,
+	// the same chunks will be removed once again after restart
+	err = s.deleteChunks(ctx, chunks)
+	if err != nil {
+		return false, err
+	}
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	_, err = lockBucket(ctx, tx, bucket)
+	if err != nil {
+		return false, err
+	}
+	objects, err := deleteFilesMeta(ctx, tx, bucket, files)
+	if err != nil {
+		return false, err
+	}
+	_, err = tx.ExecContext(ctx, `UPDATE jobs SET deleted_objects = deleted_objects + $2, deleted_chunks = deleted_chunks + $3, error = '',
+		lease_until = now() + make_interval(secs => $4), updated_at = now() WHERE id = $1`, job_id, objects, len(chunks), jobLease.Seconds())
+	if err != nil {
+		return false, err
+	}
+
+	return false, tx.Commit()
+}
+
+func (s *Server) finishDeleteBucketJob(ctx context.Context, job_id int64, bucket string) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM buckets WHERE bucket = $1 AND state = $2", bucket, bucketStateDeleting)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "DELETE FROM lifecycle_rules WHERE bucket = $1", bucket)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "UPDATE jobs SET state = $2, error = '', updated_at = now() WHERE id = $1", job_id, jobStateDone)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// selectFilesBatch returns up to jobBatchSize files of the bucket together with all their chunks
+func (s *Server) selectFilesBatch(ctx context.Context, bucket string) ([]string, []*metapb.ChunkFilenameWithShard, error) {
+	rows, err := s.DB.QueryContext(ctx, "SELECT file FROM files WHERE bucket = $1 ORDER BY file LIMIT $2", bucket, jobBatchSize)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	files := make([]string, 0, jobBatchSize)
+	for rows.Next() {
+		var file string
+		err = rows.Scan(&file)
+		if err != nil {
+			return nil, nil, err
+		}
+		files = append(files, file)
+	}
+	if rows.Err() != nil {
+		return nil, nil, rows.Err()
+	}
+
+	chunks_of_files, err := selectChunksOfFiles(ctx, s.DB, bucket, files)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	chunks := make([]*metapb.ChunkFilenameWithShard, 0)
+	for _, file_chunks := range chunks_of_files {
+		chunks = append(chunks, file_chunks...)
+	}
+	return files, chunks, nil
+}
@@ -0,0 +1,422 @@
+package meta
+
+import (
+	"common"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	metapb "meta/proto"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	jobKindExpireFiles     = "expire_files"
+	jobKindTransitionFiles = "transition_files"
+
+	// jobs which expire files and move them between tiers are enqueued for every bucket with enabled
+	// lifecycle rules once in this interval
+	lifecycleCheckInterval = time.Hour
+
+	maxLifecycleRules      = 1000
+	maxLifecycleRuleIDLen  = 255
+	maxExpiredFilesPreview = 1000
+)
+
+func (s *Server) validateLifecycleRules(rules []*metapb.LifecycleRule) error {
+	if len(rules) > maxLifecycleRules {
+		return fmt.Errorf("bucket can have at most %d lifecycle rules, got %d", maxLifecycleRules, len(rules))
+	}
+
+	ids := make(map[string]bool, len(rules))
+	for _, rule := range rules {
+		if rule.Id == "" || len(rule.Id) > maxLifecycleRuleIDLen {
+			return fmt.Errorf("length of id of lifecycle rule must be from 1 to %d, got %q", maxLifecycleRuleIDLen, rule.Id)
+		}
+		if ids[rule.Id] {
+			return fmt.Errorf("lifecycle rule with id %s is set more than once", rule.Id)
+		}
+		ids[rule.Id] = true
+
+		if rule.ExpirationDays < 0 || rule.TransitionDays < 0 {
+			return fmt.Errorf("days of lifecycle rule %s must not be negative", rule.Id)
+		}
+		if rule.ExpirationDays == 0 && rule.TransitionDays == 0 {
+			return fmt.Errorf("lifecycle rule %s must expire files or move them into another tier", rule.Id)
+		}
+		if rule.TransitionDays > 0 {
+			if s.Config.GetTierShards(rule.TransitionTier) == nil {
+				return fmt.Errorf("unknown tier %q of lifecycle rule %s", rule.TransitionTier, rule.Id)
+			}
+			if rule.ExpirationDays > 0 && rule.ExpirationDays <= rule.TransitionDays {
+				return fmt.Errorf("lifecycle rule %s expires files before moving them into tier %s", rule.Id, rule.TransitionTier)
+			}
+		} else if rule.TransitionTier != "" {
+			return fmt.Errorf("transition days of lifecycle rule %s must be positive", rule.Id)
+		}
+		err := common.ValidateTags(rule.Tags)
+		if err != nil {
+			return fmt.Errorf("invalid tags of lifecycle rule %s: %v", rule.Id, err)
+		}
+	}
+	return nil
+}
+
+func selectLifecycleRules(ctx context.Context, q querier, bucket string) ([]*metapb.LifecycleRule, error) {
+	rows, err := q.QueryContext(ctx, `SELECT rule_id, enabled, prefix, tags, expiration_days, transition_days, transition_tier
+		FROM lifecycle_rules WHERE bucket = $1 ORDER BY rule_id`, bucket)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := make([]*metapb.LifecycleRule, 0)
+	for rows.Next() {
+		var tags string
+		rule := &metapb.LifecycleRule{}
+		err = rows.Scan(&rule.Id, &rule.Enabled, &rule.Prefix, &tags, &rule.ExpirationDays, &rule.TransitionDays, &rule.TransitionTier)
+		if err != nil {
+			return nil, err
+		}
+		err = json.Unmarshal([]byte(tags), &rule.Tags)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
+
+func (s *Server) PutBucketLifecycle(ctx context.Context, req *metapb.PutBucketLifecycleReq) (*metapb.PutBucketLifecycleResp, error) {
+	err := s.validateLifecycleRules(req.Rules)
+	if err != nil {
+		return &metapb.PutBucketLifecycleResp{}, status.Errorf(codes.InvalidArgument, "invalid lifecycle configuration of bucket %s: %v", req.Bucket, err)
+	}
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return &metapb.PutBucketLifecycleResp{}, status.Errorf(codes.Internal, "failed to begin tx while putting lifecycle configuration of bucket %s", req.Bucket)
+	}
+	defer tx.Rollback()
+
+	bucket_state, err := lockBucket(ctx, tx, req.Bucket)
+	if err != nil {
+		return &metapb.PutBucketLifecycleResp{}, status.Errorf(codes.Internal, "unknown error while putting lifecycle configuration of bucket %s: %v", req.Bucket, err)
+	}
+	if bucket_state == "" {
+		return &metapb.PutBucketLifecycleResp{}, status.Errorf(codes.NotFound, "bucket with name %s does not exist", req.Bucket)
+	}
+	if bucket_state == bucketStateDeleting {
+		return &metapb.PutBucketLifecycleResp{}, status.Errorf(codes.FailedPrecondition, "bucket %s is being deleted", req.Bucket)
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM lifecycle_rules WHERE bucket = $1", req.Bucket)
+	if err != nil {
+		return &metapb.PutBucketLifecycleResp{}, status.Errorf(codes.Internal, "failed while processing DELETE query while putting lifecycle configuration of bucket %s", req.Bucket)
+	}
+	for _, rule := range req.Rules {
+		tags := rule.Tags
+		if tags == nil {
+			tags = map[string]string{}
+		}
+		tags_json, err := json.Marshal(tags)
+		if err != nil {
+			return &metapb.PutBucketLifecycleResp{}, status.Errorf(codes.Internal, "failed to encode tags of lifecycle rule %s of bucket %s", rule.Id, req.Bucket)
+		}
+
+		_, err = tx.ExecContext(ctx, `INSERT INTO lifecycle_rules (bucket, rule_id, enabled, prefix, tags, expiration_days, transition_days, transition_tier)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+			req.Bucket, rule.Id, rule.Enabled, rule.Prefix, string(tags_json), rule.ExpirationDays, rule.TransitionDays, rule.TransitionTier)
+		if err != nil {
+			return &metapb.PutBucketLifecycleResp{}, status.Errorf(codes.Internal, "failed to insert row into lifecycle_rules table while putting lifecycle configuration of bucket %s", req.Bucket)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return &metapb.PutBucketLifecycleResp{}, status.Errorf(codes.Internal, "failed to commit tx while putting lifecycle configuration of bucket %s", req.Bucket)
+	}
+
+	return &metapb.PutBucketLifecycleResp{}, nil
+}
+
+func (s *Server) GetBucketLifecycle(ctx context.Context, req *metapb.GetBucketLifecycleReq) (*metapb.GetBucketLifecycleResp, error) {
+	tx, err := s.DB.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return &metapb.GetBucketLifecycleResp{}, status.Errorf(codes.Internal, "failed to begin tx while getting lifecycle configuration of bucket %s", req.Bucket)
+	}
+	defer tx.Rollback()
+
+	count := 0
+	err = tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM buckets WHERE bucket = $1", req.Bucket).Scan(&count)
+	if err != nil {
+		return &metapb.GetBucketLifecycleResp{}, status.Errorf(codes.Internal, "unknown error while getting lifecycle configuration of bucket %s: %v", req.Bucket, err)
+	}
+	if count == 0 {
+		return &metapb.GetBucketLifecycleResp{}, status.Errorf(codes.NotFound, "bucket with name %s does not exist", req.Bucket)
+	}
+
+	rules, err := selectLifecycleRules(ctx, tx, req.Bucket)
+	if err != nil {
+		return &metapb.GetBucketLifecycleResp{}, status.Errorf(codes.Internal, "failed while reading lifecycle rules of bucket %s: %v", req.Bucket, err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return &metapb.GetBucketLifecycleResp{}, status.Errorf(codes.Internal, "failed to commit tx while getting lifecycle configuration of bucket %s", req.Bucket)
+	}
+
+	return &metapb.GetBucketLifecycleResp{Rules: rules}, nil
+}
+
+// lifecycleRuleToSQL converts the rule into condition on rows of files table which are older than days,
+// args of the query are appended to args
+func lifecycleRuleToSQL(rule *metapb.LifecycleRule, days int32, args *[]any) string {
+	*args = append(*args, rule.Prefix, days)
+	condition := fmt.Sprintf("starts_with(file, $%d) AND created_at < now() - make_interval(days => $%d)", len(*args)-1, len(*args))
+
+	// tags of the rule are checked in the same way as tag expression k1=v1 AND k2=v2 AND ...
+	keys := make([]string, 0, len(rule.Tags))
+	for key := range rule.Tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		condition += " AND " + tagExprToSQL(&common.TagCond{Key: key, Op: common.TagOpEquals, Value: rule.Tags[key]}, args)
+	}
+	return condition
+}
+
+// expiredFilesQuery returns query which selects file, size, created_at and id of the first matching rule
+// of expired files of the bucket ordered by name. Disabled rules and rules which don't expire files are ignored,
+// empty query means that there are no such rules
+func expiredFilesQuery(bucket string, rules []*metapb.LifecycleRule) (string, []any) {
+	args := []any{bucket}
+	conditions := make([]string, 0, len(rules))
+	rule_id_cases := make([]string, 0, len(rules))
+	for _, rule := range rules {
+		if !rule.Enabled || rule.ExpirationDays == 0 {
+			continue
+		}
+		condition := "(" + lifecycleRuleToSQL(rule, rule.ExpirationDays, &args) + ")"
+		args = append(args, rule.Id)
+		conditions = append(conditions, condition)
+		rule_id_cases = append(rule_id_cases, "WHEN "+condition+" THEN $"+strconv.Itoa(len(args))+"::text")
+	}
+	if len(conditions) == 0 {
+		return "", nil
+	}
+
+	query := "SELECT file, size, created_at, CASE " + strings.Join(rule_id_cases, " ") + " END FROM files WHERE bucket = $1 AND (" +
+		strings.Join(conditions, " OR ") + ") ORDER BY file"
+	return query, args
+}
+
+// PreviewLifecycle is a dry run of lifecycle rules, it returns files which would be expired now
+func (s *Server) PreviewLifecycle(ctx context.Context, req *metapb.PreviewLifecycleReq) (*metapb.PreviewLifecycleResp, error) {
+	err := s.validateLifecycleRules(req.Rules)
+	if err != nil {
+		return &metapb.PreviewLifecycleResp{}, status.Errorf(codes.InvalidArgument, "invalid lifecycle configuration of bucket %s: %v", req.Bucket, err)
+	}
+
+	limit := int(req.Limit)
+	if limit <= 0 || limit > maxExpiredFilesPreview {
+		limit = maxExpiredFilesPreview
+	}
+
+	tx, err := s.DB.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return &metapb.PreviewLifecycleResp{}, status.Errorf(codes.Internal, "failed to begin tx while previewing lifecycle of bucket %s", req.Bucket)
+	}
+	defer tx.Rollback()
+
+	count := 0
+	err = tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM buckets WHERE bucket = $1", req.Bucket).Scan(&count)
+	if err != nil {
+		return &metapb.PreviewLifecycleResp{}, status.Errorf(codes.Internal, "unknown error while previewing lifecycle of bucket %s: %v", req.Bucket, err)
+	}
+	if count == 0 {
+		return &metapb.PreviewLifecycleResp{}, status.Errorf(codes.NotFound, "bucket with name %s does not exist", req.Bucket)
+	}
+
+	rules := req.Rules
+	if len(rules) == 0 {
+		rules, err = selectLifecycleRules(ctx, tx, req.Bucket)
+		if err != nil {
+			return &metapb.PreviewLifecycleResp{}, status.Errorf(codes.Internal, "failed while reading lifecycle rules of bucket %s: %v", req.Bucket, err)
+		}
+	}
+
+	resp := &metapb.PreviewLifecycleResp{Files: make([]*metapb.ExpiredFile, 0)}
+	query, args := expiredFilesQuery(req.Bucket, rules)
+	if query == "" {
+		return resp, nil
+	}
+
+	// one extra row shows that result is truncated
+	args = append(args, limit+1)
+	rows, err := tx.QueryContext(ctx, query+" LIMIT $"+strconv.Itoa(len(args)), args...)
+	if err != nil {
+		return &metapb.PreviewLifecycleResp{}, status.Errorf(codes.Internal, "failed while processing SELECT query while previewing lifecycle of bucket %s: %v", req.Bucket, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var created_at time.Time
+		file := &metapb.ExpiredFile{}
+		err = rows.Scan(&file.File, &file.Size, &created_at, &file.RuleId)
+		if err != nil {
+			return &metapb.PreviewLifecycleResp{}, status.Errorf(codes.Internal, "failed while reading results of SELECT query while previewing lifecycle of bucket %s: %v", req.Bucket, err)
+		}
+		file.CreatedAt = created_at.Unix()
+		resp.Files = append(resp.Files, file)
+	}
+	if rows.Err() != nil {
+		return &metapb.PreviewLifecycleResp{}, status.Errorf(codes.Internal, "failed while reading results of SELECT query while previewing lifecycle of bucket %s: %v", req.Bucket, rows.Err())
+	}
+
+	if len(resp.Files) > limit {
+		resp.Files = resp.Files[:limit]
+		resp.Truncated = true
+	}
+	return resp, nil
+}
+
+// enqueueLifecycleJobs creates jobs which expire files and move them between tiers for every active bucket
+// with enabled lifecycle rules, unless such jobs are already enqueued. Rules are checked by jobs themselves,
+// so extra jobs are harmless
+func (s *Server) enqueueLifecycleJobs(ctx context.Context) {
+	days_columns := map[string]string{
+		jobKindExpireFiles:     "expiration_days",
+		jobKindTransitionFiles: "transition_days",
+	}
+
+	for kind, days_column := range days_columns {
+		res, err := s.DB.ExecContext(ctx, `INSERT INTO jobs (kind, bucket, state)
+			SELECT DISTINCT $1::text, rules.bucket, $2::text FROM lifecycle_rules rules
+			JOIN buckets ON buckets.bucket = rules.bucket
+			WHERE rules.enabled AND rules.`+days_column+` > 0 AND buckets.state = $3
+			AND NOT EXISTS (SELECT 1 FROM jobs WHERE jobs.kind = $1 AND jobs.bucket = rules.bucket AND jobs.state <> $4)`,
+			kind, jobStatePending, bucketStateActive, jobStateDone)
+		if err != nil {
+			slog.ErrorContext(ctx, "failed to enqueue jobs", "kind", kind, "error", err)
+			continue
+		}
+
+		enqueued, err := res.RowsAffected()
+		if err == nil && enqueued > 0 {
+			slog.InfoContext(ctx, "enqueued jobs", "kind", kind, "count", enqueued)
+		}
+	}
+}
+
+func (s *Server) runExpireFilesJob(ctx context.Context, job_id int64, bucket string) error {
+	for {
+		done, err := s.expireFilesBatch(ctx, job_id, bucket)
+		if err != nil || done {
+			return err
+		}
+	}
+}
+
+// expireFilesBatch removes the next batch of expired files of the bucket, it returns true when there are no
+// expired files left. Unlike deleting the whole bucket, metadata is removed before chunks: files are still
+// visible to clients, so they must never point to removed chunks. Chunks which failed to be removed stay
+// on shards as garbage
+func (s *Server) expireFilesBatch(ctx context.Context, job_id int64, bucket string) (bool, error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	// bucket is locked, so files can't be replaced concurrently and expiration is checked on actual rows
+	bucket_state, err := lockBucket(ctx, tx, bucket)
+	if err != nil {
+		return false, err
+	}
+
+	files := make([]string, 0, jobBatchSize)
+	if bucket_state == bucketStateActive {
+		rules, err := selectLifecycleRules(ctx, tx, bucket)
+		if err != nil {
+			return false, err
+		}
+		files, err = selectExpiredFilesBatch(ctx, tx, bucket, rules)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	if len(files) == 0 {
+		_, err = tx.ExecContext(ctx, "UPDATE jobs SET state = $2, error = '', updated_at = now() WHERE id = $1", job_id, jobStateDone)
+		if err != nil {
+			return false, err
+		}
+		return true, tx.Commit()
+	}
+
+	chunks_of_files, err := selectChunksOfFiles(ctx, tx, bucket, files)
+	if err != nil {
+		return false, err
+	}
+	chunks := make([]*metapb.ChunkFilenameWithShard, 0)
+	for _, file_chunks := range chunks_of_files {
+		chunks = append(chunks, file_chunks...)
+	}
+
+	objects, err := deleteFilesMeta(ctx, tx, bucket, files)
+	if err != nil {
+		return false, err
+	}
+	_, err = tx.ExecContext(ctx, `UPDATE jobs SET deleted_objects = deleted_objects + $2, deleted_chunks = deleted_chunks + $3, error = '',
+		lease_until = now() + make_interval(secs => $4), updated_at = now() WHERE id = $1`, job_id, objects, len(chunks), jobLease.Seconds())
+	if err != nil {
+		return false, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return false, err
+	}
+
+	err = s.deleteChunks(ctx, chunks)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to remove chunks of expired files", "bucket", bucket, "error", err)
+	}
+	return false, nil
+}
+
+func selectExpiredFilesBatch(ctx context.Context, tx *sql.Tx, bucket string, rules []*metapb.LifecycleRule) ([]string, error) {
+	files := make([]string, 0, jobBatchSize)
+	query, args := expiredFilesQuery(bucket, rules)
+	if query == "" {
+		return files, nil
+	}
+
+	args = append(args, jobBatchSize)
+	rows, err := tx.QueryContext(ctx, query+" LIMIT $"+strconv.Itoa(len(args)), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var file, rule_id string
+		var size int64
+		var created_at time.Time
+		err = rows.Scan(&file, &size, &created_at, &rule_id)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+	return files, rows.Err()
+}
@@ -1,22 +1,82 @@
 package meta
 
 import (
+	"common"
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
 	metapb "meta/proto"
-	"strings"
+	"net/http"
+	"sync/atomic"
+	"time"
 
+	"github.com/lib/pq"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
 
+const (
+	bucketStateActive   = "active"
+	bucketStateDeleting = "deleting"
+
+	maxFilesInBatch = 1000
+)
+
 type Server struct {
 	metapb.UnimplementedApiWithMetaServiceServer
-	DB *sql.DB
+	DB     *sql.DB
+	Config common.Config
+
+	http_client *http.Client
+	// tables are created and migrated
+	migrated atomic.Bool
+}
+
+// NewServer creates server which talks to shards over TLS if certs are not nil, its requests to shards
+// are recorded in metrics
+func NewServer(certs *common.CertReloader, metrics *common.Metrics) *Server {
+	return &Server{http_client: metrics.InstrumentShardClient(common.NewHTTPClient(certs, shardRequestTimeout))}
 }
 
-func NewServer() *Server {
-	return &Server{}
+// rowScanner is implemented both by *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// querier is implemented both by *sql.DB and *sql.Tx
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+func scanBucketInfo(row rowScanner) (*metapb.BucketInfo, error) {
+	var created_at time.Time
+	var settings string
+	info := &metapb.BucketInfo{}
+
+	err := row.Scan(&info.Bucket, &created_at, &info.Owner, &settings, &info.Objects, &info.Bytes, &info.State, &info.StoredBytes, &info.Policy, &info.QuotaBytes, &info.QuotaObjects)
+	if err != nil {
+		return nil, err
+	}
+
+	info.CreatedAt = created_at.Unix()
+	err = json.Unmarshal([]byte(settings), &info.Settings)
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// lockBucket returns state of the bucket and locks its row till the end of tx,
+// so counters of the bucket can be safely updated. Empty state means that bucket does not exist
+func lockBucket(ctx context.Context, tx *sql.Tx, bucket string) (string, error) {
+	var state string
+	err := tx.QueryRowContext(ctx, "SELECT state FROM buckets WHERE bucket = $1 FOR UPDATE", bucket).Scan(&state)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return state, err
 }
 
 func (s *Server) CreateBucket(ctx context.Context, req *metapb.CreateBucketReq) (*metapb.CreateBucketResp, error) {
@@ -26,15 +86,25 @@ func (s *Server) CreateBucket(ctx context.Context, req *metapb.CreateBucketReq)
 	}
 	defer tx.Rollback()
 
-	count := 0
-	tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM files WHERE bucket = $1", req.Bucket).Scan(&count)
-	if count > 0 {
-		return &metapb.CreateBucketResp{}, status.Errorf(codes.AlreadyExists, "bucket with name %s already exists", req.Bucket)
+	settings := req.Settings
+	if settings == nil {
+		settings = map[string]string{}
+	}
+	settings_raw, err := json.Marshal(settings)
+	if err != nil {
+		return &metapb.CreateBucketResp{}, status.Errorf(codes.InvalidArgument, "failed to encode settings of bucket %s: %v", req.Bucket, err)
 	}
 
-	_, err = tx.ExecContext(ctx, "INSERT INTO files (bucket, file, content_type) VALUES ($1, $2, $3)", req.Bucket, sql.NullString{}, sql.NullString{})
+	res, err := tx.ExecContext(ctx, "INSERT INTO buckets (bucket, owner, settings) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING", req.Bucket, req.Owner, string(settings_raw))
+	if err != nil {
+		return &metapb.CreateBucketResp{}, status.Errorf(codes.Internal, "failed to insert row into buckets table while creating bucket %s", req.Bucket)
+	}
+	inserted, err := res.RowsAffected()
 	if err != nil {
-		return &metapb.CreateBucketResp{}, status.Errorf(codes.Internal, "failed to insert row into files table while creating bucket %s", req.Bucket)
+		return &metapb.CreateBucketResp{}, status.Errorf(codes.Internal, "failed to insert row into buckets table while creating bucket %s: %v", req.Bucket, err)
+	}
+	if inserted == 0 {
+		return &metapb.CreateBucketResp{}, status.Errorf(codes.AlreadyExists, "bucket with name %s already exists", req.Bucket)
 	}
 
 	err = tx.Commit()
@@ -52,20 +122,44 @@ func (s *Server) DeleteBucket(ctx context.Context, req *metapb.DeleteBucketReq)
 	}
 	defer tx.Rollback()
 
-	count := 0
-	tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM files WHERE bucket = $1", req.Bucket).Scan(&count)
-
-	if count == 0 {
+	var objects int64
+	var state string
+	err = tx.QueryRowContext(ctx, "SELECT objects, state FROM buckets WHERE bucket = $1 FOR UPDATE", req.Bucket).Scan(&objects, &state)
+	if errors.Is(err, sql.ErrNoRows) {
 		return &metapb.DeleteBucketResp{}, status.Errorf(codes.NotFound, "bucket with name %s does not exist", req.Bucket)
+	} else if err != nil {
+		return &metapb.DeleteBucketResp{}, status.Errorf(codes.Internal, "unknown error while deleting bucket %s: %v", req.Bucket, err)
+	}
+
+	if req.Recursive {
+		job_id, err := startDeleteBucketJob(ctx, tx, req.Bucket, state)
+		if err != nil {
+			return &metapb.DeleteBucketResp{}, status.Errorf(codes.Internal, "failed to start job while deleting bucket %s: %v", req.Bucket, err)
+		}
+
+		err = tx.Commit()
+		if err != nil {
+			return &metapb.DeleteBucketResp{}, status.Errorf(codes.Internal, "failed to commit tx while deleting bucket %s", req.Bucket)
+		}
+		return &metapb.DeleteBucketResp{JobId: job_id}, nil
 	}
-	if count > 1 {
+
+	// job of the bucket is keyed by its name, so the bucket can't be removed under it and recreated
+	if state == bucketStateDeleting {
+		return &metapb.DeleteBucketResp{}, status.Errorf(codes.FailedPrecondition, "bucket %s is being deleted", req.Bucket)
+	}
+	if objects > 0 {
 		return &metapb.DeleteBucketResp{}, status.Errorf(codes.FailedPrecondition, "bucket %s is not empty before deleting", req.Bucket)
 	}
 
-	_, err = tx.ExecContext(ctx, "DELETE FROM files WHERE bucket = $1", req.Bucket)
+	_, err = tx.ExecContext(ctx, "DELETE FROM buckets WHERE bucket = $1", req.Bucket)
 	if err != nil {
 		return &metapb.DeleteBucketResp{}, status.Errorf(codes.Internal, "failed while processing DELETE query while deleting bucket %s", req.Bucket)
 	}
+	_, err = tx.ExecContext(ctx, "DELETE FROM lifecycle_rules WHERE bucket = $1", req.Bucket)
+	if err != nil {
+		return &metapb.DeleteBucketResp{}, status.Errorf(codes.Internal, "failed to delete lifecycle rules while deleting bucket %s", req.Bucket)
+	}
 
 	err = tx.Commit()
 	if err != nil {
@@ -75,14 +169,60 @@ func (s *Server) DeleteBucket(ctx context.Context, req *metapb.DeleteBucketReq)
 	return &metapb.DeleteBucketResp{}, nil
 }
 
+func (s *Server) ListBuckets(ctx context.Context, req *metapb.ListBucketsReq) (*metapb.ListBucketsResp, error) {
+	rows, err := s.DB.QueryContext(ctx, "SELECT bucket, created_at, owner, settings, objects, bytes, state, stored_bytes, policy, quota_bytes, quota_objects FROM buckets ORDER BY bucket")
+	if err != nil {
+		return &metapb.ListBucketsResp{}, status.Errorf(codes.Internal, "failed while processing SELECT query while listing buckets")
+	}
+	defer rows.Close()
+
+	buckets := make([]*metapb.BucketInfo, 0)
+	for rows.Next() {
+		info, err := scanBucketInfo(rows)
+		if err != nil {
+			return &metapb.ListBucketsResp{}, status.Errorf(codes.Internal, "failed while reading results of SELECT query while listing buckets: %v", err)
+		}
+		buckets = append(buckets, info)
+	}
+	if rows.Err() != nil {
+		return &metapb.ListBucketsResp{}, status.Errorf(codes.Internal, "failed while reading results of SELECT query while listing buckets: %v", rows.Err())
+	}
+
+	return &metapb.ListBucketsResp{Buckets: buckets}, nil
+}
+
+func (s *Server) GetBucket(ctx context.Context, req *metapb.GetBucketReq) (*metapb.GetBucketResp, error) {
+	row := s.DB.QueryRowContext(ctx, "SELECT bucket, created_at, owner, settings, objects, bytes, state, stored_bytes, policy, quota_bytes, quota_objects FROM buckets WHERE bucket = $1", req.Bucket)
+	info, err := scanBucketInfo(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return &metapb.GetBucketResp{}, status.Errorf(codes.NotFound, "bucket with name %s does not exist", req.Bucket)
+	} else if err != nil {
+		return &metapb.GetBucketResp{}, status.Errorf(codes.Internal, "unknown error while getting bucket %s: %v", req.Bucket, err)
+	}
+
+	return &metapb.GetBucketResp{Bucket: info}, nil
+}
+
 func (s *Server) GetFiles(ctx context.Context, req *metapb.GetFilesReq) (*metapb.GetFilesResp, error) {
-	tx, err := s.DB.BeginTx(ctx, nil)
+	tx, err := s.DB.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
 	if err != nil {
 		return &metapb.GetFilesResp{}, status.Errorf(codes.Internal, "failed to begin tx while getting list of files from bucket %s", req.Bucket)
 	}
 	defer tx.Rollback()
 
-	rows, err := s.DB.QueryContext(ctx, "SELECT file FROM files WHERE bucket = $1", req.Bucket)
+	// files of buckets which are being deleted are not listed, as in GetFileChunks
+	var state string
+	err = tx.QueryRowContext(ctx, "SELECT state FROM buckets WHERE bucket = $1", req.Bucket).Scan(&state)
+	if errors.Is(err, sql.ErrNoRows) {
+		return &metapb.GetFilesResp{}, status.Errorf(codes.NotFound, "bucket with name %s does not exist", req.Bucket)
+	} else if err != nil {
+		return &metapb.GetFilesResp{}, status.Errorf(codes.Internal, "unknown error while getting list of files of bucket %s: %v", req.Bucket, err)
+	}
+	if state == bucketStateDeleting {
+		return &metapb.GetFilesResp{}, status.Errorf(codes.NotFound, "bucket %s is being deleted", req.Bucket)
+	}
+
+	rows, err := tx.QueryContext(ctx, "SELECT file FROM files WHERE bucket = $1 ORDER BY file", req.Bucket)
 	if err != nil {
 		return &metapb.GetFilesResp{}, status.Errorf(codes.Internal, "failed while processing SELECT query while getting list of files of bucket %s", req.Bucket)
 	}
@@ -90,23 +230,13 @@ func (s *Server) GetFiles(ctx context.Context, req *metapb.GetFilesReq) (*metapb
 
 	var cur_file string
 	list_of_files := make([]string, 0)
-	bucket_exists := false
 
 	for rows.Next() {
 		err = rows.Scan(&cur_file)
-		// it's a terrible crutch but i haven't figured out how to make it better
-		if err != nil && !strings.Contains(err.Error(), "converting NULL to string is unsupported") {
+		if err != nil {
 			return &metapb.GetFilesResp{}, status.Errorf(codes.Internal, "failed while reading results of SELECT query while getting list of files of bucket %s", req.Bucket)
 		}
-		bucket_exists = true
-		// "" <- the "file" is NULL
-		if strings.Compare(cur_file, "") != 0 {
-			list_of_files = append(list_of_files, cur_file)
-		}
-	}
-
-	if !bucket_exists {
-		return &metapb.GetFilesResp{}, status.Errorf(codes.NotFound, "bucket with name %s does not exist", req.Bucket)
+		list_of_files = append(list_of_files, cur_file)
 	}
 
 	err = tx.Commit()
@@ -124,50 +254,113 @@ func (s *Server) CreateFile(ctx context.Context, req *metapb.CreateFileReq) (*me
 	}
 	defer tx.Rollback()
 
-	rows, err := s.DB.QueryContext(ctx, "SELECT file FROM files WHERE bucket = $1", req.Bucket)
+	bucket_state, err := lockBucket(ctx, tx, req.Bucket)
 	if err != nil {
-		return &metapb.CreateFileResp{}, status.Errorf(codes.Internal, "failed while processing SELECT query while creating file %s in bucket %s", req.File, req.Bucket)
+		return &metapb.CreateFileResp{}, status.Errorf(codes.Internal, "unknown error while creating file %s in bucket %s: %v", req.File, req.Bucket, err)
+	}
+	if bucket_state == "" {
+		return &metapb.CreateFileResp{}, status.Errorf(codes.NotFound, "bucket with name %s does not exist", req.Bucket)
+	}
+	if bucket_state == bucketStateDeleting {
+		return &metapb.CreateFileResp{}, status.Errorf(codes.FailedPrecondition, "bucket %s is being deleted", req.Bucket)
 	}
-	defer rows.Close()
 
-	var cur_file string
-	bucket_exists := false
+	file_exists := true
+	existing_etag := ""
+	err = tx.QueryRowContext(ctx, "SELECT etag FROM files WHERE bucket = $1 AND file = $2 FOR UPDATE", req.Bucket, req.File).Scan(&existing_etag)
+	if errors.Is(err, sql.ErrNoRows) {
+		file_exists = false
+	} else if err != nil {
+		return &metapb.CreateFileResp{}, status.Errorf(codes.Internal, "unknown error while creating file %s in bucket %s: %v", req.File, req.Bucket, err)
+	}
 
-	for rows.Next() {
-		err = rows.Scan(&cur_file)
-		if err != nil && !strings.Contains(err.Error(), "converting NULL to string is unsupported") {
-			return &metapb.CreateFileResp{}, status.Errorf(codes.Internal, "failed while reading results of SELECT query while creating file %s in bucket %s: %v", req.File, req.Bucket, err)
+	// preconditions are checked inside tx, so they can be used for optimistic concurrency control
+	if !checkPreconditions(req.IfMatch, req.IfNoneMatch, file_exists, existing_etag) {
+		return &metapb.CreateFileResp{}, status.Errorf(codes.Aborted, "precondition failed for file %s in bucket %s", req.File, req.Bucket)
+	}
+	if file_exists && req.IfMatch == "" {
+		return &metapb.CreateFileResp{}, status.Errorf(codes.AlreadyExists, "file with name %s already exists in bucket %s", req.File, req.Bucket)
+	}
+
+	replaced_chunks := make([]*metapb.ChunkFilenameWithShard, 0)
+	if file_exists {
+		replaced_chunks, err = selectChunks(ctx, tx, req.Bucket, req.File)
+		if err != nil {
+			return &metapb.CreateFileResp{}, status.Errorf(codes.Internal, "failed while reading chunks of replaced file %s in bucket %s: %v", req.File, req.Bucket, err)
 		}
-		bucket_exists = true
-		if strings.Compare(cur_file, req.File) == 0 {
-			return &metapb.CreateFileResp{}, status.Errorf(codes.AlreadyExists, "file with name %s already exists in bucket %s", req.File, req.Bucket)
+		_, err = deleteFilesMeta(ctx, tx, req.Bucket, []string{req.File})
+		if err != nil {
+			return &metapb.CreateFileResp{}, status.Errorf(codes.Internal, "failed to delete replaced file %s in bucket %s: %v", req.File, req.Bucket, err)
 		}
 	}
 
-	if !bucket_exists {
-		return &metapb.CreateFileResp{}, status.Errorf(codes.NotFound, "bucket with name %s does not exist", req.Bucket)
+	err = common.ValidateTags(req.Tags)
+	if err != nil {
+		return &metapb.CreateFileResp{}, status.Errorf(codes.InvalidArgument, "invalid tags of file %s in bucket %s: %v", req.File, req.Bucket, err)
 	}
 
-	_, err = tx.ExecContext(ctx, "INSERT INTO files (bucket, file, content_type) VALUES ($1, $2, $3)", req.Bucket, req.File, req.ContentType)
+	tier := req.Tier
+	if tier == "" {
+		tier = s.Config.GetDefaultTier()
+	}
+	if s.Config.GetTierShards(tier) == nil {
+		return &metapb.CreateFileResp{}, status.Errorf(codes.InvalidArgument, "unknown tier %s of file %s in bucket %s", tier, req.File, req.Bucket)
+	}
+	if !common.IsKnownCodec(req.Codec) {
+		return &metapb.CreateFileResp{}, status.Errorf(codes.InvalidArgument, "unknown codec %s of file %s in bucket %s", req.Codec, req.File, req.Bucket)
+	}
+	err = validateEncryption(req)
+	if err != nil {
+		return &metapb.CreateFileResp{}, status.Errorf(codes.InvalidArgument, "invalid encryption of file %s in bucket %s: %v", req.File, req.Bucket, err)
+	}
+
+	user_metadata := req.UserMetadata
+	if user_metadata == nil {
+		user_metadata = map[string]string{}
+	}
+	user_metadata_raw, err := json.Marshal(user_metadata)
+	if err != nil {
+		return &metapb.CreateFileResp{}, status.Errorf(codes.InvalidArgument, "failed to encode user metadata of file %s: %v", req.File, err)
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO files (bucket, file, content_type, size, etag, content_encoding, content_disposition, cache_control, user_metadata, tier, codec, stored_size,
+			encryption, key_id, wrapped_key, customer_key_md5)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)`,
+		req.Bucket, req.File, req.ContentType, req.Size, req.Etag, req.ContentEncoding, req.ContentDisposition, req.CacheControl, string(user_metadata_raw), tier,
+		req.Codec, req.StoredSize, req.Encryption, req.KeyId, req.WrappedKey, req.CustomerKeyMd5)
 	if err != nil {
 		return &metapb.CreateFileResp{}, status.Errorf(codes.Internal, "failed to insert row into files table while creating file %s in bucket %s", req.File, req.Bucket)
 	}
 
+	err = insertTags(ctx, tx, req.Bucket, req.File, req.Tags)
+	if err != nil {
+		return &metapb.CreateFileResp{}, status.Errorf(codes.Internal, "failed to insert rows into tags table while creating file %s in bucket %s", req.File, req.Bucket)
+	}
+
 	for i := 0; i < len(req.Chunks); i++ {
 		cur_chunk := req.Chunks[i].Filename
 		cur_shard := req.Chunks[i].Shard
-		_, err = tx.ExecContext(ctx, "INSERT INTO chunks (file, chunk, shard) VALUES ($1, $2, $3)", req.File, cur_chunk, cur_shard)
+		_, err = tx.ExecContext(ctx, "INSERT INTO chunks (bucket, file, chunk, shard) VALUES ($1, $2, $3, $4)", req.Bucket, req.File, cur_chunk, cur_shard)
 		if err != nil {
 			return &metapb.CreateFileResp{}, status.Errorf(codes.Internal, "failed to insert row into chunks table while creating file %s in bucket %s", req.File, req.Bucket)
 		}
 	}
 
+	_, err = tx.ExecContext(ctx, "UPDATE buckets SET objects = objects + 1, bytes = bytes + $2, stored_bytes = stored_bytes + $3 WHERE bucket = $1", req.Bucket, req.Size, req.StoredSize)
+	if err != nil {
+		return &metapb.CreateFileResp{}, status.Errorf(codes.Internal, "failed to update counters of bucket %s while creating file %s", req.Bucket, req.File)
+	}
+	err = checkQuotas(ctx, tx, req.Bucket)
+	if err != nil {
+		return &metapb.CreateFileResp{}, err
+	}
+
 	err = tx.Commit()
 	if err != nil {
 		return &metapb.CreateFileResp{}, status.Errorf(codes.Internal, "failed to commit tx while creating file %s in bucket %s", req.File, req.Bucket)
 	}
 
-	return &metapb.CreateFileResp{}, nil
+	return &metapb.CreateFileResp{ReplacedChunks: replaced_chunks}, nil
 }
 
 func (s *Server) DeleteFile(ctx context.Context, req *metapb.DeleteFileReq) (*metapb.DeleteFileResp, error) {
@@ -177,84 +370,240 @@ func (s *Server) DeleteFile(ctx context.Context, req *metapb.DeleteFileReq) (*me
 	}
 	defer tx.Rollback()
 
-	count := 0
-	err = s.DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM files WHERE bucket = $1 AND file = $2", req.Bucket, req.File).Scan(&count)
-	if count == 0 {
+	bucket_state, err := lockBucket(ctx, tx, req.Bucket)
+	if err != nil {
+		return &metapb.DeleteFileResp{}, status.Errorf(codes.Internal, "unknown error while deleting file %s from bucket %s: %v", req.File, req.Bucket, err)
+	}
+
+	var etag string
+	err = tx.QueryRowContext(ctx, "SELECT etag FROM files WHERE bucket = $1 AND file = $2", req.Bucket, req.File).Scan(&etag)
+	if bucket_state == "" || errors.Is(err, sql.ErrNoRows) {
 		return &metapb.DeleteFileResp{}, status.Errorf(codes.NotFound, "bucket with name %s does not exist or file with name %s does not exist", req.Bucket, req.File)
 	} else if err != nil {
 		return &metapb.DeleteFileResp{}, status.Errorf(codes.Internal, "unknown error while deleting file %s from bucket %s: %v", req.File, req.Bucket, err)
 	}
 
-	rows_from_chunks, err := s.DB.QueryContext(ctx, "SELECT chunk, shard FROM chunks WHERE file = $1", req.File)
+	if !checkPreconditions(req.IfMatch, "", true, etag) {
+		return &metapb.DeleteFileResp{}, status.Errorf(codes.Aborted, "precondition failed for file %s in bucket %s", req.File, req.Bucket)
+	}
+
+	chunks_with_shards, err := selectChunks(ctx, tx, req.Bucket, req.File)
 	if err != nil {
-		return &metapb.DeleteFileResp{}, status.Errorf(codes.Internal, "failed while processing SELECT query while deleting file %s in bucket %s from chunks table", req.File, req.Bucket)
+		return &metapb.DeleteFileResp{}, status.Errorf(codes.Internal, "failed while reading chunks while deleting file %s in bucket %s: %v", req.File, req.Bucket, err)
 	}
-	defer rows_from_chunks.Close()
 
-	var cur_chunk, cur_shard string
-	chunks_with_shards := make([]*metapb.ChunkFilenameWithShard, 0)
+	_, err = deleteFilesMeta(ctx, tx, req.Bucket, []string{req.File})
+	if err != nil {
+		return &metapb.DeleteFileResp{}, status.Errorf(codes.Internal, "failed to delete metadata of file %s in bucket %s: %v", req.File, req.Bucket, err)
+	}
 
-	for rows_from_chunks.Next() {
-		err = rows_from_chunks.Scan(&cur_chunk, &cur_shard)
+	err = tx.Commit()
+	if err != nil {
+		return &metapb.DeleteFileResp{}, status.Errorf(codes.Internal, "failed to commit tx while deleting file %s in bucket %s", req.File, req.Bucket)
+	}
+
+	return &metapb.DeleteFileResp{Chunks: chunks_with_shards}, nil
+}
+
+func (s *Server) DeleteFiles(ctx context.Context, req *metapb.DeleteFilesReq) (*metapb.DeleteFilesResp, error) {
+	if len(req.Files) > maxFilesInBatch {
+		return &metapb.DeleteFilesResp{}, status.Errorf(codes.InvalidArgument, "at most %d files can be deleted in one batch, got %d", maxFilesInBatch, len(req.Files))
+	}
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return &metapb.DeleteFilesResp{}, status.Errorf(codes.Internal, "failed to begin tx while deleting files in bucket %s", req.Bucket)
+	}
+	defer tx.Rollback()
+
+	bucket_state, err := lockBucket(ctx, tx, req.Bucket)
+	if err != nil {
+		return &metapb.DeleteFilesResp{}, status.Errorf(codes.Internal, "unknown error while deleting files from bucket %s: %v", req.Bucket, err)
+	}
+	if bucket_state == "" {
+		return &metapb.DeleteFilesResp{}, status.Errorf(codes.NotFound, "bucket with name %s does not exist", req.Bucket)
+	}
+
+	rows, err := tx.QueryContext(ctx, "SELECT file FROM files WHERE bucket = $1 AND file = ANY($2)", req.Bucket, pq.Array(req.Files))
+	if err != nil {
+		return &metapb.DeleteFilesResp{}, status.Errorf(codes.Internal, "failed while processing SELECT query while deleting files from bucket %s", req.Bucket)
+	}
+	existing_files := make([]string, 0, len(req.Files))
+	for rows.Next() {
+		var file string
+		err = rows.Scan(&file)
 		if err != nil {
-			return &metapb.DeleteFileResp{}, status.Errorf(codes.Internal, "failed while reading results of SELECT query while deleting file %s in bucket %s: %v", req.File, req.Bucket, err)
+			rows.Close()
+			return &metapb.DeleteFilesResp{}, status.Errorf(codes.Internal, "failed while reading results of SELECT query while deleting files from bucket %s: %v", req.Bucket, err)
 		}
-		chunks_with_shards = append(chunks_with_shards, &metapb.ChunkFilenameWithShard{Filename: cur_chunk, Shard: cur_shard})
+		existing_files = append(existing_files, file)
 	}
+	rows.Close()
 
-	_, err = tx.ExecContext(ctx, "DELETE FROM files WHERE file = $1", req.File)
+	chunks_of_files, err := selectChunksOfFiles(ctx, tx, req.Bucket, existing_files)
 	if err != nil {
-		return &metapb.DeleteFileResp{}, status.Errorf(codes.Internal, "failed while processing DELETE query while deleting file %s from files table", req.File)
+		return &metapb.DeleteFilesResp{}, status.Errorf(codes.Internal, "failed while reading chunks while deleting files from bucket %s: %v", req.Bucket, err)
 	}
-	_, err = tx.ExecContext(ctx, "DELETE FROM chunks WHERE file = $1", req.File)
+
+	_, err = deleteFilesMeta(ctx, tx, req.Bucket, existing_files)
 	if err != nil {
-		return &metapb.DeleteFileResp{}, status.Errorf(codes.Internal, "failed while processing DELETE query while deleting file %s from chunks table", req.File)
+		return &metapb.DeleteFilesResp{}, status.Errorf(codes.Internal, "failed while processing DELETE query while deleting files from bucket %s: %v", req.Bucket, err)
 	}
 
 	err = tx.Commit()
 	if err != nil {
-		return &metapb.DeleteFileResp{}, status.Errorf(codes.Internal, "failed to commit tx while deleting file %s in bucket %s", req.File, req.Bucket)
+		return &metapb.DeleteFilesResp{}, status.Errorf(codes.Internal, "failed to commit tx while deleting files from bucket %s", req.Bucket)
+	}
+
+	is_deleted := make(map[string]bool, len(existing_files))
+	for _, file := range existing_files {
+		is_deleted[file] = true
+	}
+
+	results := make([]*metapb.DeleteFileResult, 0, len(req.Files))
+	for _, file := range req.Files {
+		if is_deleted[file] {
+			results = append(results, &metapb.DeleteFileResult{File: file, Chunks: chunks_of_files[file]})
+			// the same file may be listed several times, but its chunks must be removed only once
+			delete(chunks_of_files, file)
+		} else {
+			results = append(results, &metapb.DeleteFileResult{
+				File:         file,
+				ErrorCode:    "NoSuchKey",
+				ErrorMessage: fmt.Sprintf("file with name %s does not exist in bucket %s", file, req.Bucket),
+			})
+		}
 	}
 
-	return &metapb.DeleteFileResp{Chunks: chunks_with_shards}, nil
+	return &metapb.DeleteFilesResp{Results: results}, nil
 }
 
+// GetFileChunks doesn't serve files of buckets which are being deleted, their chunks are removed
+// by the job before their metadata
 func (s *Server) GetFileChunks(ctx context.Context, req *metapb.GetFileChunksReq) (*metapb.GetFileChunksResp, error) {
-	tx, err := s.DB.BeginTx(ctx, nil)
+	tx, err := s.DB.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
 	if err != nil {
 		return &metapb.GetFileChunksResp{}, status.Errorf(codes.Internal, "failed to begin tx while getting file chunks %s in bucket %s", req.File, req.Bucket)
 	}
 	defer tx.Rollback()
 
-	content_type := ""
-	err = s.DB.QueryRowContext(ctx, "SELECT content_type FROM files WHERE bucket = $1 AND file = $2", req.Bucket, req.File).Scan(&content_type)
-	if err != nil && strings.Compare(err.Error(), "sql: no rows in result set") == 0 {
+	resp := &metapb.GetFileChunksResp{}
+	var created_at time.Time
+	var restored_until sql.NullTime
+	var user_metadata string
+	err = tx.QueryRowContext(ctx, `SELECT content_type, size, created_at, etag, content_encoding, content_disposition, cache_control, user_metadata,
+		tier, restored_until, codec, stored_size, encryption, key_id, wrapped_key, customer_key_md5
+		FROM files WHERE bucket = $1 AND file = $2
+		AND NOT EXISTS (SELECT 1 FROM buckets WHERE bucket = $1 AND state = $3)`, req.Bucket, req.File, bucketStateDeleting).
+		Scan(&resp.ContentType, &resp.Size, &created_at, &resp.Etag, &resp.ContentEncoding, &resp.ContentDisposition, &resp.CacheControl, &user_metadata,
+			&resp.Tier, &restored_until, &resp.Codec, &resp.StoredSize, &resp.Encryption, &resp.KeyId, &resp.WrappedKey, &resp.CustomerKeyMd5)
+	if errors.Is(err, sql.ErrNoRows) {
 		return &metapb.GetFileChunksResp{}, status.Errorf(codes.NotFound, "bucket with name %s does not exist or file with name %s does not exist", req.Bucket, req.File)
 	} else if err != nil {
 		return &metapb.GetFileChunksResp{}, status.Errorf(codes.Internal, "unknown error while getting chunks from file %s from bucket %s: %v", req.File, req.Bucket, err)
 	}
 
-	rows_from_chunks, err := s.DB.QueryContext(ctx, "SELECT chunk, shard FROM chunks WHERE file = $1", req.File)
+	resp.CreatedAt = created_at.Unix()
+	resp.Archived = s.Config.IsArchiveTier(resp.Tier)
+	if restored_until.Valid {
+		resp.RestoredUntil = restored_until.Time.Unix()
+	}
+	err = json.Unmarshal([]byte(user_metadata), &resp.UserMetadata)
+	if err != nil {
+		return &metapb.GetFileChunksResp{}, status.Errorf(codes.Internal, "failed to decode user metadata of file %s in bucket %s: %v", req.File, req.Bucket, err)
+	}
+
+	resp.Chunks, err = selectChunks(ctx, tx, req.Bucket, req.File)
+	if err != nil {
+		return &metapb.GetFileChunksResp{}, status.Errorf(codes.Internal, "failed while reading chunks of file %s in bucket %s: %v", req.File, req.Bucket, err)
+	}
+	resp.Tags, err = selectTags(ctx, tx, req.Bucket, req.File)
 	if err != nil {
-		return &metapb.GetFileChunksResp{}, status.Errorf(codes.Internal, "failed while processing SELECT query while getting chunks of file %s in bucket %s from chunks table", req.File, req.Bucket)
+		return &metapb.GetFileChunksResp{}, status.Errorf(codes.Internal, "failed while reading tags of file %s in bucket %s: %v", req.File, req.Bucket, err)
 	}
-	defer rows_from_chunks.Close()
+
+	err = tx.Commit()
+	if err != nil {
+		return &metapb.GetFileChunksResp{}, status.Errorf(codes.Internal, "failed to commit tx while getting chunks of file %s in bucket %s", req.File, req.Bucket)
+	}
+
+	return resp, nil
+}
+
+// checkPreconditions evaluates values of If-Match and If-None-Match headers against the current state of the file
+func checkPreconditions(if_match, if_none_match string, file_exists bool, etag string) bool {
+	if if_match != "" && !(file_exists && common.ETagMatches(if_match, etag)) {
+		return false
+	}
+	if if_none_match != "" && file_exists && common.ETagMatches(if_none_match, etag) {
+		return false
+	}
+	return true
+}
+
+// selectChunks returns chunks of the file in the order they were written
+func selectChunks(ctx context.Context, tx *sql.Tx, bucket, file string) ([]*metapb.ChunkFilenameWithShard, error) {
+	rows, err := tx.QueryContext(ctx, "SELECT chunk, shard FROM chunks WHERE bucket = $1 AND file = $2 ORDER BY id", bucket, file)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
 	var cur_chunk, cur_shard string
 	chunks_with_shards := make([]*metapb.ChunkFilenameWithShard, 0)
 
-	for rows_from_chunks.Next() {
-		err = rows_from_chunks.Scan(&cur_chunk, &cur_shard)
+	for rows.Next() {
+		err = rows.Scan(&cur_chunk, &cur_shard)
 		if err != nil {
-			return &metapb.GetFileChunksResp{}, status.Errorf(codes.Internal, "failed while reading results of SELECT query while getting chunks of file %s in bucket %s: %v", req.File, req.Bucket, err)
+			return nil, err
 		}
 		chunks_with_shards = append(chunks_with_shards, &metapb.ChunkFilenameWithShard{Filename: cur_chunk, Shard: cur_shard})
 	}
+	return chunks_with_shards, rows.Err()
+}
 
-	err = tx.Commit()
+// selectChunksOfFiles returns chunks of each of the files in the order they were written
+func selectChunksOfFiles(ctx context.Context, q querier, bucket string, files []string) (map[string][]*metapb.ChunkFilenameWithShard, error) {
+	rows, err := q.QueryContext(ctx, "SELECT file, chunk, shard FROM chunks WHERE bucket = $1 AND file = ANY($2) ORDER BY id", bucket, pq.Array(files))
 	if err != nil {
-		return &metapb.GetFileChunksResp{}, status.Errorf(codes.Internal, "failed to commit tx while getting chunks of file %s in bucket %s", req.File, req.Bucket)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var cur_file string
+	chunks_of_files := make(map[string][]*metapb.ChunkFilenameWithShard, len(files))
+
+	for rows.Next() {
+		chunk := &metapb.ChunkFilenameWithShard{}
+		err = rows.Scan(&cur_file, &chunk.Filename, &chunk.Shard)
+		if err != nil {
+			return nil, err
+		}
+		chunks_of_files[cur_file] = append(chunks_of_files[cur_file], chunk)
+	}
+	return chunks_of_files, rows.Err()
+}
+
+// deleteFilesMeta removes files of the bucket together with their chunks and tags from tables and updates
+// counters of the bucket, row of the bucket must be locked by caller. It returns number of deleted files
+func deleteFilesMeta(ctx context.Context, tx *sql.Tx, bucket string, files []string) (int64, error) {
+	_, err := tx.ExecContext(ctx, "DELETE FROM chunks WHERE bucket = $1 AND file = ANY($2)", bucket, pq.Array(files))
+	if err != nil {
+		return 0, err
+	}
+	_, err = tx.ExecContext(ctx, "DELETE FROM tags WHERE bucket = $1 AND file = ANY($2)", bucket, pq.Array(files))
+	if err != nil {
+		return 0, err
+	}
+
+	var objects, bytes, stored_bytes int64
+	err = tx.QueryRowContext(ctx, `WITH deleted AS (DELETE FROM files WHERE bucket = $1 AND file = ANY($2) RETURNING size, stored_size)
+		SELECT COUNT(*), COALESCE(SUM(size), 0), COALESCE(SUM(stored_size), 0) FROM deleted`, bucket, pq.Array(files)).Scan(&objects, &bytes, &stored_bytes)
+	if err != nil {
+		return 0, err
 	}
 
-	return &metapb.GetFileChunksResp{Chunks: chunks_with_shards, ContentType: content_type}, nil
+	_, err = tx.ExecContext(ctx, "UPDATE buckets SET objects = objects - $2, bytes = bytes - $3, stored_bytes = stored_bytes - $4 WHERE bucket = $1",
+		bucket, objects, bytes, stored_bytes)
+	return objects, err
 }
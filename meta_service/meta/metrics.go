@@ -0,0 +1,28 @@
+package meta
+
+import (
+	"common"
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// MetricsInterceptor records latencies of gRPC methods by their status codes
+func MetricsInterceptor(metrics *common.Metrics) grpc.UnaryServerInterceptor {
+	duration := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "grpc_server_handling_seconds",
+		Help:    "Duration of gRPC calls handled by the service, its count is the number of calls.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{common.LabelGrpcMethod, common.LabelGrpcCode})
+	metrics.Register(duration)
+
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		duration.WithLabelValues(info.FullMethod, status.Code(err).String()).Observe(time.Since(start).Seconds())
+		return resp, err
+	}
+}
@@ -0,0 +1,33 @@
+package meta
+
+import (
+	"common"
+	"context"
+	metapb "meta/proto"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// PutBucketPolicy replaces policy of the bucket. Policies are evaluated by the api service, meta service
+// only checks that the policy can be parsed
+func (s *Server) PutBucketPolicy(ctx context.Context, req *metapb.PutBucketPolicyReq) (*metapb.PutBucketPolicyResp, error) {
+	_, err := common.ParsePolicy(req.Policy)
+	if err != nil {
+		return &metapb.PutBucketPolicyResp{}, status.Errorf(codes.InvalidArgument, "invalid policy of bucket %s: %v", req.Bucket, err)
+	}
+
+	res, err := s.DB.ExecContext(ctx, "UPDATE buckets SET policy = $2 WHERE bucket = $1", req.Bucket, req.Policy)
+	if err != nil {
+		return &metapb.PutBucketPolicyResp{}, status.Errorf(codes.Internal, "failed to update buckets table while putting policy of bucket %s: %v", req.Bucket, err)
+	}
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return &metapb.PutBucketPolicyResp{}, status.Errorf(codes.Internal, "failed to update buckets table while putting policy of bucket %s: %v", req.Bucket, err)
+	}
+	if updated == 0 {
+		return &metapb.PutBucketPolicyResp{}, status.Errorf(codes.NotFound, "bucket with name %s does not exist", req.Bucket)
+	}
+
+	return &metapb.PutBucketPolicyResp{}, nil
+}
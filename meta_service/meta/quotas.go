@@ -0,0 +1,170 @@
+package meta
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	metapb "meta/proto"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// unlimitedHeadroom is returned if there is no quota
+const unlimitedHeadroom = -1
+
+// exceeds checks usage against quota, zero quota means unlimited
+func exceeds(used, quota int64) bool {
+	return quota > 0 && used > quota
+}
+
+func headroom(used, quota int64) int64 {
+	if quota == 0 {
+		return unlimitedHeadroom
+	}
+	return max(quota-used, 0)
+}
+
+func minHeadroom(a, b int64) int64 {
+	if a == unlimitedHeadroom {
+		return b
+	}
+	if b == unlimitedHeadroom {
+		return a
+	}
+	return min(a, b)
+}
+
+// checkQuotas must be called in tx after counters of the bucket are increased, so the tx is rolled back if they
+// exceed quota of the bucket or of its owner. Row of the owner is locked, so concurrent uploads into different
+// buckets of one user can't exceed the quota together. Quota which is already exceeded blocks adding anything
+func checkQuotas(ctx context.Context, tx *sql.Tx, bucket string) error {
+	var owner string
+	var objects, bytes, quota_objects, quota_bytes int64
+	err := tx.QueryRowContext(ctx, "SELECT owner, objects, bytes, quota_objects, quota_bytes FROM buckets WHERE bucket = $1", bucket).
+		Scan(&owner, &objects, &bytes, &quota_objects, &quota_bytes)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to read quotas of bucket %s: %v", bucket, err)
+	}
+	if exceeds(objects, quota_objects) {
+		return status.Errorf(codes.ResourceExhausted, "quota of bucket %s is exceeded: it may contain at most %d objects", bucket, quota_objects)
+	}
+	if exceeds(bytes, quota_bytes) {
+		return status.Errorf(codes.ResourceExhausted, "quota of bucket %s is exceeded: it may contain at most %d bytes", bucket, quota_bytes)
+	}
+	if owner == "" {
+		return nil
+	}
+
+	err = tx.QueryRowContext(ctx, "SELECT quota_objects, quota_bytes FROM users WHERE name = $1 FOR UPDATE", owner).Scan(&quota_objects, &quota_bytes)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	} else if err != nil {
+		return status.Errorf(codes.Internal, "failed to read quotas of user %s: %v", owner, err)
+	}
+	if quota_objects == 0 && quota_bytes == 0 {
+		return nil
+	}
+
+	err = tx.QueryRowContext(ctx, "SELECT COALESCE(SUM(objects), 0), COALESCE(SUM(bytes), 0) FROM buckets WHERE owner = $1", owner).Scan(&objects, &bytes)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to count usage of user %s: %v", owner, err)
+	}
+	if exceeds(objects, quota_objects) {
+		return status.Errorf(codes.ResourceExhausted, "quota of user %s is exceeded: buckets of the user may contain at most %d objects", owner, quota_objects)
+	}
+	if exceeds(bytes, quota_bytes) {
+		return status.Errorf(codes.ResourceExhausted, "quota of user %s is exceeded: buckets of the user may contain at most %d bytes", owner, quota_bytes)
+	}
+	return nil
+}
+
+func (s *Server) SetQuota(ctx context.Context, req *metapb.SetQuotaReq) (*metapb.SetQuotaResp, error) {
+	if (req.Bucket == "") == (req.User == "") {
+		return &metapb.SetQuotaResp{}, status.Errorf(codes.InvalidArgument, "quota must be set either for bucket or for user")
+	}
+	if req.QuotaBytes < 0 || req.QuotaObjects < 0 {
+		return &metapb.SetQuotaResp{}, status.Errorf(codes.InvalidArgument, "quota must not be negative, zero means unlimited")
+	}
+
+	query, name, kind := "UPDATE buckets SET quota_bytes = $2, quota_objects = $3 WHERE bucket = $1", req.Bucket, "bucket"
+	if req.User != "" {
+		query, name, kind = "UPDATE users SET quota_bytes = $2, quota_objects = $3 WHERE name = $1", req.User, "user"
+	}
+	res, err := s.DB.ExecContext(ctx, query, name, req.QuotaBytes, req.QuotaObjects)
+	if err != nil {
+		return &metapb.SetQuotaResp{}, status.Errorf(codes.Internal, "failed to set quota of %s %s: %v", kind, name, err)
+	}
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return &metapb.SetQuotaResp{}, status.Errorf(codes.Internal, "failed to set quota of %s %s: %v", kind, name, err)
+	}
+	if updated == 0 {
+		return &metapb.SetQuotaResp{}, status.Errorf(codes.NotFound, "%s with name %s does not exist", kind, name)
+	}
+
+	return &metapb.SetQuotaResp{}, nil
+}
+
+// GetQuotaHeadroom lets api service reject uploads which don't fit before their chunks are written.
+// It's only a hint, quotas are enforced when the file is created
+func (s *Server) GetQuotaHeadroom(ctx context.Context, req *metapb.GetQuotaHeadroomReq) (*metapb.GetQuotaHeadroomResp, error) {
+	var owner string
+	var objects, bytes, quota_objects, quota_bytes int64
+	err := s.DB.QueryRowContext(ctx, "SELECT owner, objects, bytes, quota_objects, quota_bytes FROM buckets WHERE bucket = $1", req.Bucket).
+		Scan(&owner, &objects, &bytes, &quota_objects, &quota_bytes)
+	if errors.Is(err, sql.ErrNoRows) {
+		return &metapb.GetQuotaHeadroomResp{}, status.Errorf(codes.NotFound, "bucket with name %s does not exist", req.Bucket)
+	} else if err != nil {
+		return &metapb.GetQuotaHeadroomResp{}, status.Errorf(codes.Internal, "failed to read quotas of bucket %s: %v", req.Bucket, err)
+	}
+
+	// replaced file is freed
+	var freed_objects, freed_bytes int64
+	err = s.DB.QueryRowContext(ctx, "SELECT 1, size FROM files WHERE bucket = $1 AND file = $2", req.Bucket, req.File).Scan(&freed_objects, &freed_bytes)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return &metapb.GetQuotaHeadroomResp{}, status.Errorf(codes.Internal, "unknown error while getting file %s in bucket %s: %v", req.File, req.Bucket, err)
+	}
+
+	resp := &metapb.GetQuotaHeadroomResp{
+		Bytes:   headroom(bytes-freed_bytes, quota_bytes),
+		Objects: headroom(objects-freed_objects, quota_objects),
+	}
+	if owner == "" {
+		return resp, nil
+	}
+
+	usage, err := s.userUsage(ctx, owner)
+	if errors.Is(err, sql.ErrNoRows) {
+		return resp, nil
+	} else if err != nil {
+		return &metapb.GetQuotaHeadroomResp{}, status.Errorf(codes.Internal, "failed to count usage of user %s: %v", owner, err)
+	}
+	resp.Bytes = minHeadroom(resp.Bytes, headroom(usage.Bytes-freed_bytes, usage.QuotaBytes))
+	resp.Objects = minHeadroom(resp.Objects, headroom(usage.Objects-freed_objects, usage.QuotaObjects))
+	return resp, nil
+}
+
+// userUsage returns sql.ErrNoRows if there is no such user
+func (s *Server) userUsage(ctx context.Context, user string) (*metapb.UserUsage, error) {
+	usage := &metapb.UserUsage{User: user}
+	err := s.DB.QueryRowContext(ctx, `SELECT users.quota_bytes, users.quota_objects, COUNT(buckets.bucket),
+			COALESCE(SUM(buckets.objects), 0), COALESCE(SUM(buckets.bytes), 0), COALESCE(SUM(buckets.stored_bytes), 0)
+		FROM users LEFT JOIN buckets ON buckets.owner = users.name WHERE users.name = $1 GROUP BY users.name`, user).
+		Scan(&usage.QuotaBytes, &usage.QuotaObjects, &usage.Buckets, &usage.Objects, &usage.Bytes, &usage.StoredBytes)
+	if err != nil {
+		return nil, err
+	}
+	return usage, nil
+}
+
+func (s *Server) GetUserUsage(ctx context.Context, req *metapb.GetUserUsageReq) (*metapb.GetUserUsageResp, error) {
+	usage, err := s.userUsage(ctx, req.User)
+	if errors.Is(err, sql.ErrNoRows) {
+		return &metapb.GetUserUsageResp{}, status.Errorf(codes.NotFound, "user with name %s does not exist", req.User)
+	} else if err != nil {
+		return &metapb.GetUserUsageResp{}, status.Errorf(codes.Internal, "failed to count usage of user %s: %v", req.User, err)
+	}
+
+	return &metapb.GetUserUsageResp{Usage: usage}, nil
+}
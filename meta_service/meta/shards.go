@@ -0,0 +1,98 @@
+package meta
+
+import (
+	"context"
+	"fmt"
+	metapb "meta/proto"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	shardRequestTimeout      = 30 * time.Second
+	maxParallelShardRequests = 16
+)
+
+// deleteChunk removes chunk from its shard. Chunk which is already absent is not an error,
+// so deleting can be safely retried
+func (s *Server) deleteChunk(ctx context.Context, chunk *metapb.ChunkFilenameWithShard) error {
+	shard_port, ok := s.Config.Shards[chunk.Shard]
+	if !ok {
+		return fmt.Errorf("unknown shard %s of chunk %s", chunk.Shard, chunk.Filename)
+	}
+
+	delete_req, err := http.NewRequestWithContext(ctx, http.MethodDelete, s.Config.GetStorageURL(chunk.Shard, shard_port, chunk.Filename), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.http_client.Do(delete_req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("shard %s responded with status %s while deleting chunk %s", chunk.Shard, resp.Status, chunk.Filename)
+	}
+	return nil
+}
+
+// deleteChunks removes chunks from shards in parallel and returns the first error
+func (s *Server) deleteChunks(ctx context.Context, chunks []*metapb.ChunkFilenameWithShard) error {
+	return inParallel(len(chunks), func(i int) error {
+		return s.deleteChunk(ctx, chunks[i])
+	})
+}
+
+// copyChunk asks shard of dst chunk to copy src chunk into it. Shards talk to each other directly,
+// so data does not pass through meta service
+func (s *Server) copyChunk(ctx context.Context, src, dst *metapb.ChunkFilenameWithShard) error {
+	shard_port, ok := s.Config.Shards[dst.Shard]
+	if !ok {
+		return fmt.Errorf("unknown shard %s of chunk %s", dst.Shard, dst.Filename)
+	}
+
+	query := url.Values{}
+	query.Set("source", src.Filename)
+	query.Set("source_shard", src.Shard)
+	copy_url := s.Config.GetStorageURL(dst.Shard, shard_port, "copy/"+dst.Filename) + "?" + query.Encode()
+
+	copy_req, err := http.NewRequestWithContext(ctx, http.MethodPost, copy_url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.http_client.Do(copy_req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("shard %s responded with status %s while copying chunk %s into %s", dst.Shard, resp.Status, src.Filename, dst.Filename)
+	}
+	return nil
+}
+
+// inParallel calls fn for each of n indexes using at most maxParallelShardRequests goroutines
+// and returns the first error
+func inParallel(n int, fn func(i int) error) error {
+	errs := make(chan error, n)
+	limiter := make(chan struct{}, maxParallelShardRequests)
+	for i := 0; i < n; i++ {
+		go func(i int) {
+			limiter <- struct{}{}
+			defer func() { <-limiter }()
+			errs <- fn(i)
+		}(i)
+	}
+
+	var first_err error
+	for i := 0; i < n; i++ {
+		err := <-errs
+		if err != nil && first_err == nil {
+			first_err = err
+		}
+	}
+	return first_err
+}
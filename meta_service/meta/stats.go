@@ -0,0 +1,48 @@
+package meta
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	metapb "meta/proto"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// GetShardChunks counts chunks of buckets on every shard, so stat service can tell how buckets are
+// spread over shards. Shards which don't store chunks of a bucket are not listed
+func (s *Server) GetShardChunks(ctx context.Context, req *metapb.GetShardChunksReq) (*metapb.GetShardChunksResp, error) {
+	if req.Bucket != "" {
+		var exists bool
+		err := s.DB.QueryRowContext(ctx, "SELECT true FROM buckets WHERE bucket = $1", req.Bucket).Scan(&exists)
+		if errors.Is(err, sql.ErrNoRows) {
+			return &metapb.GetShardChunksResp{}, status.Errorf(codes.NotFound, "bucket with name %s does not exist", req.Bucket)
+		} else if err != nil {
+			return &metapb.GetShardChunksResp{}, status.Errorf(codes.Internal, "unknown error while getting bucket %s: %v", req.Bucket, err)
+		}
+	}
+
+	rows, err := s.DB.QueryContext(ctx, `SELECT bucket, shard, COUNT(*) FROM chunks
+		WHERE bucket IS NOT NULL AND ($1 = '' OR bucket = $1)
+		GROUP BY bucket, shard ORDER BY bucket, shard`, req.Bucket)
+	if err != nil {
+		return &metapb.GetShardChunksResp{}, status.Errorf(codes.Internal, "failed to count chunks on shards: %v", err)
+	}
+	defer rows.Close()
+
+	shard_chunks := make([]*metapb.ShardChunks, 0)
+	for rows.Next() {
+		counted := &metapb.ShardChunks{}
+		err = rows.Scan(&counted.Bucket, &counted.Shard, &counted.Chunks)
+		if err != nil {
+			return &metapb.GetShardChunksResp{}, status.Errorf(codes.Internal, "failed to count chunks on shards: %v", err)
+		}
+		shard_chunks = append(shard_chunks, counted)
+	}
+	if rows.Err() != nil {
+		return &metapb.GetShardChunksResp{}, status.Errorf(codes.Internal, "failed to count chunks on shards: %v", rows.Err())
+	}
+
+	return &metapb.GetShardChunksResp{ShardChunks: shard_chunks}, nil
+}
@@ -0,0 +1,228 @@
+package meta
+
+import (
+	"common"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	metapb "meta/proto"
+	"strconv"
+
+	"github.com/lib/pq"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const maxFoundFiles = 1000
+
+func insertTags(ctx context.Context, tx *sql.Tx, bucket, file string, tags map[string]string) error {
+	for key, value := range tags {
+		_, err := tx.ExecContext(ctx, "INSERT INTO tags (bucket, file, key, value) VALUES ($1, $2, $3, $4)", bucket, file, key, value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func selectTags(ctx context.Context, q querier, bucket, file string) (map[string]string, error) {
+	rows, err := q.QueryContext(ctx, "SELECT key, value FROM tags WHERE bucket = $1 AND file = $2", bucket, file)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var key, value string
+	tags := make(map[string]string)
+	for rows.Next() {
+		err = rows.Scan(&key, &value)
+		if err != nil {
+			return nil, err
+		}
+		tags[key] = value
+	}
+	return tags, rows.Err()
+}
+
+func (s *Server) PutFileTags(ctx context.Context, req *metapb.PutFileTagsReq) (*metapb.PutFileTagsResp, error) {
+	err := common.ValidateTags(req.Tags)
+	if err != nil {
+		return &metapb.PutFileTagsResp{}, status.Errorf(codes.InvalidArgument, "invalid tags of file %s in bucket %s: %v", req.File, req.Bucket, err)
+	}
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return &metapb.PutFileTagsResp{}, status.Errorf(codes.Internal, "failed to begin tx while putting tags of file %s in bucket %s", req.File, req.Bucket)
+	}
+	defer tx.Rollback()
+
+	var file string
+	err = tx.QueryRowContext(ctx, "SELECT file FROM files WHERE bucket = $1 AND file = $2 FOR UPDATE", req.Bucket, req.File).Scan(&file)
+	if errors.Is(err, sql.ErrNoRows) {
+		return &metapb.PutFileTagsResp{}, status.Errorf(codes.NotFound, "bucket with name %s does not exist or file with name %s does not exist", req.Bucket, req.File)
+	} else if err != nil {
+		return &metapb.PutFileTagsResp{}, status.Errorf(codes.Internal, "unknown error while putting tags of file %s in bucket %s: %v", req.File, req.Bucket, err)
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM tags WHERE bucket = $1 AND file = $2", req.Bucket, req.File)
+	if err != nil {
+		return &metapb.PutFileTagsResp{}, status.Errorf(codes.Internal, "failed while processing DELETE query while putting tags of file %s in bucket %s", req.File, req.Bucket)
+	}
+	err = insertTags(ctx, tx, req.Bucket, req.File, req.Tags)
+	if err != nil {
+		return &metapb.PutFileTagsResp{}, status.Errorf(codes.Internal, "failed to insert rows into tags table while putting tags of file %s in bucket %s", req.File, req.Bucket)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return &metapb.PutFileTagsResp{}, status.Errorf(codes.Internal, "failed to commit tx while putting tags of file %s in bucket %s", req.File, req.Bucket)
+	}
+
+	return &metapb.PutFileTagsResp{}, nil
+}
+
+func (s *Server) GetFileTags(ctx context.Context, req *metapb.GetFileTagsReq) (*metapb.GetFileTagsResp, error) {
+	tx, err := s.DB.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return &metapb.GetFileTagsResp{}, status.Errorf(codes.Internal, "failed to begin tx while getting tags of file %s in bucket %s", req.File, req.Bucket)
+	}
+	defer tx.Rollback()
+
+	count := 0
+	err = tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM files WHERE bucket = $1 AND file = $2", req.Bucket, req.File).Scan(&count)
+	if err != nil {
+		return &metapb.GetFileTagsResp{}, status.Errorf(codes.Internal, "unknown error while getting tags of file %s in bucket %s: %v", req.File, req.Bucket, err)
+	}
+	if count == 0 {
+		return &metapb.GetFileTagsResp{}, status.Errorf(codes.NotFound, "bucket with name %s does not exist or file with name %s does not exist", req.Bucket, req.File)
+	}
+
+	tags, err := selectTags(ctx, tx, req.Bucket, req.File)
+	if err != nil {
+		return &metapb.GetFileTagsResp{}, status.Errorf(codes.Internal, "failed while reading tags of file %s in bucket %s: %v", req.File, req.Bucket, err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return &metapb.GetFileTagsResp{}, status.Errorf(codes.Internal, "failed to commit tx while getting tags of file %s in bucket %s", req.File, req.Bucket)
+	}
+
+	return &metapb.GetFileTagsResp{Tags: tags}, nil
+}
+
+// tagExprToSQL converts tag expression into condition on rows of files table, args of the query are appended to args
+func tagExprToSQL(expr common.TagExpr, args *[]any) string {
+	placeholder := func(arg any) string {
+		*args = append(*args, arg)
+		return "$" + strconv.Itoa(len(*args))
+	}
+
+	switch e := expr.(type) {
+	case *common.TagAnd:
+		return "(" + tagExprToSQL(e.Left, args) + " AND " + tagExprToSQL(e.Right, args) + ")"
+	case *common.TagOr:
+		return "(" + tagExprToSQL(e.Left, args) + " OR " + tagExprToSQL(e.Right, args) + ")"
+	case *common.TagNot:
+		return "(NOT " + tagExprToSQL(e.Expr, args) + ")"
+	case *common.TagCond:
+		tag_exists := "EXISTS (SELECT 1 FROM tags WHERE tags.bucket = files.bucket AND tags.file = files.file AND tags.key = " + placeholder(e.Key)
+		switch e.Op {
+		case common.TagOpEquals:
+			return tag_exists + " AND tags.value = " + placeholder(e.Value) + ")"
+		case common.TagOpNotEquals:
+			return "(NOT " + tag_exists + " AND tags.value = " + placeholder(e.Value) + "))"
+		default:
+			return tag_exists + ")"
+		}
+	default:
+		panic(fmt.Sprintf("unknown node of tag expression: %T", expr))
+	}
+}
+
+// FindFiles finds files across all buckets (or in one bucket) which tags match the expression
+func (s *Server) FindFiles(ctx context.Context, req *metapb.FindFilesReq) (*metapb.FindFilesResp, error) {
+	expr, err := common.ParseTagExpr(req.Expression)
+	if err != nil {
+		return &metapb.FindFilesResp{}, status.Errorf(codes.InvalidArgument, "invalid tag expression %q: %v", req.Expression, err)
+	}
+
+	limit := int(req.Limit)
+	if limit <= 0 || limit > maxFoundFiles {
+		limit = maxFoundFiles
+	}
+
+	args := make([]any, 0)
+	query := "SELECT bucket, file FROM files WHERE " + tagExprToSQL(expr, &args)
+	if req.Bucket != "" {
+		args = append(args, req.Bucket)
+		query += " AND bucket = $" + strconv.Itoa(len(args))
+	}
+	if req.Restricted {
+		args = append(args, pq.Array(req.AllowedBuckets))
+		query += " AND bucket = ANY($" + strconv.Itoa(len(args)) + ")"
+	}
+	// one extra row shows that result is truncated
+	args = append(args, limit+1)
+	query += " ORDER BY bucket, file LIMIT $" + strconv.Itoa(len(args))
+
+	tx, err := s.DB.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return &metapb.FindFilesResp{}, status.Errorf(codes.Internal, "failed to begin tx while finding files by tags")
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return &metapb.FindFilesResp{}, status.Errorf(codes.Internal, "failed while processing SELECT query while finding files by tags: %v", err)
+	}
+	found := make([]*metapb.FoundFile, 0)
+	for rows.Next() {
+		file := &metapb.FoundFile{Tags: make(map[string]string)}
+		err = rows.Scan(&file.Bucket, &file.File)
+		if err != nil {
+			rows.Close()
+			return &metapb.FindFilesResp{}, status.Errorf(codes.Internal, "failed while reading results of SELECT query while finding files by tags: %v", err)
+		}
+		found = append(found, file)
+	}
+	rows.Close()
+
+	truncated := len(found) > limit
+	if truncated {
+		found = found[:limit]
+	}
+
+	buckets := make([]string, len(found))
+	files := make([]string, len(found))
+	found_by_name := make(map[[2]string]*metapb.FoundFile, len(found))
+	for i, file := range found {
+		buckets[i] = file.Bucket
+		files[i] = file.File
+		found_by_name[[2]string{file.Bucket, file.File}] = file
+	}
+
+	tag_rows, err := tx.QueryContext(ctx, `SELECT tags.bucket, tags.file, tags.key, tags.value FROM tags
+		JOIN unnest($1::text[], $2::text[]) AS found (bucket, file) ON tags.bucket = found.bucket AND tags.file = found.file`,
+		pq.Array(buckets), pq.Array(files))
+	if err != nil {
+		return &metapb.FindFilesResp{}, status.Errorf(codes.Internal, "failed while processing SELECT query while reading tags of found files: %v", err)
+	}
+	defer tag_rows.Close()
+
+	var bucket, file, key, value string
+	for tag_rows.Next() {
+		err = tag_rows.Scan(&bucket, &file, &key, &value)
+		if err != nil {
+			return &metapb.FindFilesResp{}, status.Errorf(codes.Internal, "failed while reading tags of found files: %v", err)
+		}
+		found_by_name[[2]string{bucket, file}].Tags[key] = value
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return &metapb.FindFilesResp{}, status.Errorf(codes.Internal, "failed to commit tx while finding files by tags")
+	}
+
+	return &metapb.FindFilesResp{Files: found, Truncated: truncated}, nil
+}
@@ -0,0 +1,370 @@
+package meta
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	metapb "meta/proto"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	defaultRestoreDays = 1
+	maxRestoreDays     = 30000
+)
+
+// pickShard chooses shard of the tier for the chunk by rendezvous hashing of its name,
+// so the chunk goes to the same shard every time it is moved into the tier
+func pickShard(chunk_name string, shards map[string]int) string {
+	var best uint64
+	var best_shard string
+	for shard := range shards {
+		h := fnv.New64a()
+		h.Write([]byte(shard))
+		h.Write([]byte{0})
+		h.Write([]byte(chunk_name))
+		cur := h.Sum64()
+		if best_shard == "" || best < cur || (best == cur && shard < best_shard) {
+			best = cur
+			best_shard = shard
+		}
+	}
+	return best_shard
+}
+
+func sameChunks(a, b []*metapb.ChunkFilenameWithShard) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Filename != b[i].Filename || a[i].Shard != b[i].Shard {
+			return false
+		}
+	}
+	return true
+}
+
+// lockFileMove takes advisory lock of the file in Postgres, so only one move of the file runs at a time
+// on all instances of meta service. Copies keep names of chunks and go to the same shards, so overlapping
+// moves would remove copies which another move has switched the file to. It returns nil if the file is
+// already being moved. The lock is held by the returned connection till unlockFileMove
+func (s *Server) lockFileMove(ctx context.Context, bucket, file string) (*sql.Conn, error) {
+	conn, err := s.DB.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var locked bool
+	err = conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock(hashtextextended($1, 0))", bucket+"/"+file).Scan(&locked)
+	if err != nil || !locked {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+func unlockFileMove(ctx context.Context, conn *sql.Conn, bucket, file string) {
+	defer conn.Close()
+	_, err := conn.ExecContext(context.WithoutCancel(ctx), "SELECT pg_advisory_unlock(hashtextextended($1, 0))", bucket+"/"+file)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to unlock file after moving it", "bucket", bucket, "file", file, "error", err)
+		// the session is closed instead of returning to the pool, lock is released with it
+		conn.Raw(func(any) error { return driver.ErrBadConn })
+	}
+}
+
+// moveFileToTier copies chunks of the file onto shards of the tier and then switches metadata to the copies.
+// Chunks which are already on the right shard are not touched. If the file was replaced or removed meanwhile,
+// or it's being moved by someone else, copies are removed and false is returned. restored_until is saved
+// together with the new tier
+func (s *Server) moveFileToTier(ctx context.Context, bucket, file, tier string, restored_until sql.NullTime) (bool, int, error) {
+	shards := s.Config.GetTierShards(tier)
+	if len(shards) == 0 {
+		return false, 0, fmt.Errorf("unknown tier %s", tier)
+	}
+
+	lock, err := s.lockFileMove(ctx, bucket, file)
+	if err != nil {
+		return false, 0, err
+	}
+	if lock == nil {
+		return false, 0, nil
+	}
+	defer unlockFileMove(ctx, lock, bucket, file)
+
+	tx, err := s.DB.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return false, 0, err
+	}
+	src_chunks, err := selectChunks(ctx, tx, bucket, file)
+	tx.Rollback()
+	if err != nil {
+		return false, 0, err
+	}
+
+	moved_src := make([]*metapb.ChunkFilenameWithShard, 0, len(src_chunks))
+	moved_dst := make([]*metapb.ChunkFilenameWithShard, 0, len(src_chunks))
+	for _, chunk := range src_chunks {
+		dst_shard := pickShard(chunk.Filename, shards)
+		if dst_shard != chunk.Shard {
+			// names of chunks are unique, so the copy keeps the name and differs only by shard
+			moved_src = append(moved_src, chunk)
+			moved_dst = append(moved_dst, &metapb.ChunkFilenameWithShard{Filename: chunk.Filename, Shard: dst_shard})
+		}
+	}
+
+	err = inParallel(len(moved_dst), func(i int) error {
+		return s.copyChunk(ctx, moved_src[i], moved_dst[i])
+	})
+	if err == nil {
+		var switched bool
+		switched, err = s.switchFileTier(ctx, bucket, file, tier, restored_until, src_chunks, moved_dst)
+		if err == nil && !switched {
+			err = errFileChanged
+		}
+	}
+
+	if err != nil {
+		// context may be already cancelled, but copies must be removed anyway
+		cleanup_err := s.deleteChunks(context.WithoutCancel(ctx), moved_dst)
+		if cleanup_err != nil {
+			slog.ErrorContext(ctx, "failed to remove copies of chunks", "bucket", bucket, "file", file, "error", cleanup_err)
+		}
+		if errors.Is(err, errFileChanged) {
+			return false, 0, nil
+		}
+		return false, 0, err
+	}
+
+	err = s.deleteChunks(ctx, moved_src)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to remove old chunks after moving file into tier", "bucket", bucket, "file", file, "tier", tier, "error", err)
+	}
+	return true, len(moved_dst), nil
+}
+
+var errFileChanged = errors.New("file was changed while moving it")
+
+// switchFileTier points metadata of the file to moved chunks, if its chunks are still the same as before moving
+func (s *Server) switchFileTier(ctx context.Context, bucket, file, tier string, restored_until sql.NullTime,
+	src_chunks, moved_dst []*metapb.ChunkFilenameWithShard) (bool, error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	_, err = lockBucket(ctx, tx, bucket)
+	if err != nil {
+		return false, err
+	}
+	cur_chunks, err := selectChunks(ctx, tx, bucket, file)
+	if err != nil {
+		return false, err
+	}
+	if !sameChunks(src_chunks, cur_chunks) {
+		return false, nil
+	}
+
+	for _, chunk := range moved_dst {
+		_, err = tx.ExecContext(ctx, "UPDATE chunks SET shard = $4 WHERE bucket = $1 AND file = $2 AND chunk = $3", bucket, file, chunk.Filename, chunk.Shard)
+		if err != nil {
+			return false, err
+		}
+	}
+	res, err := tx.ExecContext(ctx, "UPDATE files SET tier = $3, restored_until = $4 WHERE bucket = $1 AND file = $2", bucket, file, tier, restored_until)
+	if err != nil {
+		return false, err
+	}
+	updated, err := res.RowsAffected()
+	if err != nil || updated == 0 {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
+
+// RestoreFile moves archived file into the default tier, so it can be read. The file stays there for req.Days,
+// after that lifecycle rules may move it into the archive again. For already restored file only the period is extended
+func (s *Server) RestoreFile(ctx context.Context, req *metapb.RestoreFileReq) (*metapb.RestoreFileResp, error) {
+	days := req.Days
+	if days == 0 {
+		days = defaultRestoreDays
+	}
+	if days < 0 || days > maxRestoreDays {
+		return &metapb.RestoreFileResp{}, status.Errorf(codes.InvalidArgument, "file can be restored for 1 to %d days, got %d", maxRestoreDays, days)
+	}
+	restored_until := time.Now().Add(time.Duration(days) * 24 * time.Hour)
+
+	var tier string
+	var cur_restored_until sql.NullTime
+	err := s.DB.QueryRowContext(ctx, "SELECT tier, restored_until FROM files WHERE bucket = $1 AND file = $2", req.Bucket, req.File).Scan(&tier, &cur_restored_until)
+	if errors.Is(err, sql.ErrNoRows) {
+		return &metapb.RestoreFileResp{}, status.Errorf(codes.NotFound, "bucket with name %s does not exist or file with name %s does not exist", req.Bucket, req.File)
+	} else if err != nil {
+		return &metapb.RestoreFileResp{}, status.Errorf(codes.Internal, "unknown error while restoring file %s in bucket %s: %v", req.File, req.Bucket, err)
+	}
+
+	if !s.Config.IsArchiveTier(tier) {
+		if !cur_restored_until.Valid {
+			return &metapb.RestoreFileResp{}, status.Errorf(codes.FailedPrecondition, "file %s in bucket %s is not archived", req.File, req.Bucket)
+		}
+		_, err = s.DB.ExecContext(ctx, "UPDATE files SET restored_until = $3 WHERE bucket = $1 AND file = $2 AND restored_until IS NOT NULL",
+			req.Bucket, req.File, restored_until)
+		if err != nil {
+			return &metapb.RestoreFileResp{}, status.Errorf(codes.Internal, "failed to update files table while restoring file %s in bucket %s", req.File, req.Bucket)
+		}
+		return &metapb.RestoreFileResp{RestoredUntil: restored_until.Unix()}, nil
+	}
+
+	moved, _, err := s.moveFileToTier(ctx, req.Bucket, req.File, s.Config.GetDefaultTier(), sql.NullTime{Time: restored_until, Valid: true})
+	if err != nil {
+		return &metapb.RestoreFileResp{}, status.Errorf(codes.Internal, "failed to move chunks of file %s in bucket %s out of archive: %v", req.File, req.Bucket, err)
+	}
+	if !moved {
+		return &metapb.RestoreFileResp{}, status.Errorf(codes.Aborted, "file %s in bucket %s was changed or is being moved while restoring it", req.File, req.Bucket)
+	}
+
+	slog.InfoContext(ctx, "restored file", "bucket", req.Bucket, "file", req.File, "restored_until", restored_until.UTC())
+	return &metapb.RestoreFileResp{RestoredUntil: restored_until.Unix()}, nil
+}
+
+type fileTransition struct {
+	file string
+	tier string
+}
+
+// transitionFilesQuery returns query which selects files of the bucket which must be moved into another tier
+// together with that tier. When several rules match the file, the rule with the largest number of days wins,
+// so files are never moved back and forth between tiers. Files restored from archive are skipped till their
+// restoration period ends. Empty query means that there are no enabled rules which move files
+func transitionFilesQuery(bucket string, rules []*metapb.LifecycleRule) (string, []any) {
+	transition_rules := make([]*metapb.LifecycleRule, 0, len(rules))
+	for _, rule := range rules {
+		if rule.Enabled && rule.TransitionDays > 0 {
+			transition_rules = append(transition_rules, rule)
+		}
+	}
+	if len(transition_rules) == 0 {
+		return "", nil
+	}
+	sort.Slice(transition_rules, func(i, j int) bool {
+		if transition_rules[i].TransitionDays != transition_rules[j].TransitionDays {
+			return transition_rules[i].TransitionDays > transition_rules[j].TransitionDays
+		}
+		return transition_rules[i].Id < transition_rules[j].Id
+	})
+
+	args := []any{bucket}
+	tier_cases := make([]string, 0, len(transition_rules))
+	for _, rule := range transition_rules {
+		condition := "(" + lifecycleRuleToSQL(rule, rule.TransitionDays, &args) + ")"
+		args = append(args, rule.TransitionTier)
+		tier_cases = append(tier_cases, "WHEN "+condition+" THEN $"+strconv.Itoa(len(args))+"::text")
+	}
+
+	target_tier := "CASE " + strings.Join(tier_cases, " ") + " END"
+	query := "SELECT file, target_tier FROM (SELECT file, tier, " + target_tier + " AS target_tier FROM files WHERE bucket = $1 " +
+		"AND (restored_until IS NULL OR restored_until < now())) AS candidates WHERE target_tier IS NOT NULL AND target_tier <> tier ORDER BY file"
+	return query, args
+}
+
+func (s *Server) runTransitionFilesJob(ctx context.Context, job_id int64, bucket string) error {
+	for {
+		done, err := s.transitionFilesBatch(ctx, job_id, bucket)
+		if err != nil || done {
+			return err
+		}
+	}
+}
+
+// transitionFilesBatch moves the next batch of files of the bucket into tiers of lifecycle rules,
+// it returns true when there are no such files left
+func (s *Server) transitionFilesBatch(ctx context.Context, job_id int64, bucket string) (bool, error) {
+	transitions, err := s.selectTransitionsBatch(ctx, bucket)
+	if err != nil {
+		return false, err
+	}
+
+	if len(transitions) == 0 {
+		_, err = s.DB.ExecContext(ctx, "UPDATE jobs SET state = $2, error = '', updated_at = now() WHERE id = $1", job_id, jobStateDone)
+		return err == nil, err
+	}
+
+	moved_objects, moved_chunks := 0, 0
+	changed := 0
+	for _, transition := range transitions {
+		moved, chunks, err := s.moveFileToTier(ctx, bucket, transition.file, transition.tier, sql.NullTime{})
+		if err != nil {
+			return false, err
+		}
+		if moved {
+			moved_objects++
+			moved_chunks += chunks
+		} else {
+			changed++
+		}
+	}
+
+	_, err = s.DB.ExecContext(ctx, `UPDATE jobs SET moved_objects = moved_objects + $2, moved_chunks = moved_chunks + $3, error = '',
+		lease_until = now() + make_interval(secs => $4), updated_at = now() WHERE id = $1`, job_id, moved_objects, moved_chunks, jobLease.Seconds())
+	if err != nil {
+		return false, err
+	}
+
+	// files which were changed while moving are selected again only if they still match the rules,
+	// but if nothing was moved, the job stops till the next check of lifecycle rules
+	return changed == len(transitions), nil
+}
+
+func (s *Server) selectTransitionsBatch(ctx context.Context, bucket string) ([]fileTransition, error) {
+	tx, err := s.DB.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	transitions := make([]fileTransition, 0, jobBatchSize)
+	var bucket_state string
+	err = tx.QueryRowContext(ctx, "SELECT state FROM buckets WHERE bucket = $1", bucket).Scan(&bucket_state)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && bucket_state != bucketStateActive) {
+		return transitions, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	rules, err := selectLifecycleRules(ctx, tx, bucket)
+	if err != nil {
+		return nil, err
+	}
+	query, args := transitionFilesQuery(bucket, rules)
+	if query == "" {
+		return transitions, nil
+	}
+
+	args = append(args, jobBatchSize)
+	rows, err := tx.QueryContext(ctx, query+" LIMIT $"+strconv.Itoa(len(args)), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var transition fileTransition
+		err = rows.Scan(&transition.file, &transition.tier)
+		if err != nil {
+			return nil, err
+		}
+		transitions = append(transitions, transition)
+	}
+	return transitions, rows.Err()
+}
@@ -27,7 +27,33 @@ type ApiWithMetaServiceClient interface {
 	GetFiles(ctx context.Context, in *GetFilesReq, opts ...grpc.CallOption) (*GetFilesResp, error)
 	CreateFile(ctx context.Context, in *CreateFileReq, opts ...grpc.CallOption) (*CreateFileResp, error)
 	DeleteFile(ctx context.Context, in *DeleteFileReq, opts ...grpc.CallOption) (*DeleteFileResp, error)
+	DeleteFiles(ctx context.Context, in *DeleteFilesReq, opts ...grpc.CallOption) (*DeleteFilesResp, error)
+	CopyFile(ctx context.Context, in *CopyFileReq, opts ...grpc.CallOption) (*CopyFileResp, error)
 	GetFileChunks(ctx context.Context, in *GetFileChunksReq, opts ...grpc.CallOption) (*GetFileChunksResp, error)
+	ListBuckets(ctx context.Context, in *ListBucketsReq, opts ...grpc.CallOption) (*ListBucketsResp, error)
+	GetBucket(ctx context.Context, in *GetBucketReq, opts ...grpc.CallOption) (*GetBucketResp, error)
+	GetJob(ctx context.Context, in *GetJobReq, opts ...grpc.CallOption) (*GetJobResp, error)
+	PutFileTags(ctx context.Context, in *PutFileTagsReq, opts ...grpc.CallOption) (*PutFileTagsResp, error)
+	GetFileTags(ctx context.Context, in *GetFileTagsReq, opts ...grpc.CallOption) (*GetFileTagsResp, error)
+	FindFiles(ctx context.Context, in *FindFilesReq, opts ...grpc.CallOption) (*FindFilesResp, error)
+	PutBucketLifecycle(ctx context.Context, in *PutBucketLifecycleReq, opts ...grpc.CallOption) (*PutBucketLifecycleResp, error)
+	GetBucketLifecycle(ctx context.Context, in *GetBucketLifecycleReq, opts ...grpc.CallOption) (*GetBucketLifecycleResp, error)
+	PreviewLifecycle(ctx context.Context, in *PreviewLifecycleReq, opts ...grpc.CallOption) (*PreviewLifecycleResp, error)
+	RestoreFile(ctx context.Context, in *RestoreFileReq, opts ...grpc.CallOption) (*RestoreFileResp, error)
+	ListWrappedKeys(ctx context.Context, in *ListWrappedKeysReq, opts ...grpc.CallOption) (*ListWrappedKeysResp, error)
+	RewrapKey(ctx context.Context, in *RewrapKeyReq, opts ...grpc.CallOption) (*RewrapKeyResp, error)
+	CreateUser(ctx context.Context, in *CreateUserReq, opts ...grpc.CallOption) (*CreateUserResp, error)
+	CreateAccessKey(ctx context.Context, in *CreateAccessKeyReq, opts ...grpc.CallOption) (*CreateAccessKeyResp, error)
+	GetAccessKey(ctx context.Context, in *GetAccessKeyReq, opts ...grpc.CallOption) (*GetAccessKeyResp, error)
+	RevokeAccessKey(ctx context.Context, in *RevokeAccessKeyReq, opts ...grpc.CallOption) (*RevokeAccessKeyResp, error)
+	ListAccessKeys(ctx context.Context, in *ListAccessKeysReq, opts ...grpc.CallOption) (*ListAccessKeysResp, error)
+	PutBucketPolicy(ctx context.Context, in *PutBucketPolicyReq, opts ...grpc.CallOption) (*PutBucketPolicyResp, error)
+	AddUserToGroup(ctx context.Context, in *AddUserToGroupReq, opts ...grpc.CallOption) (*AddUserToGroupResp, error)
+	RemoveUserFromGroup(ctx context.Context, in *RemoveUserFromGroupReq, opts ...grpc.CallOption) (*RemoveUserFromGroupResp, error)
+	SetQuota(ctx context.Context, in *SetQuotaReq, opts ...grpc.CallOption) (*SetQuotaResp, error)
+	GetQuotaHeadroom(ctx context.Context, in *GetQuotaHeadroomReq, opts ...grpc.CallOption) (*GetQuotaHeadroomResp, error)
+	GetUserUsage(ctx context.Context, in *GetUserUsageReq, opts ...grpc.CallOption) (*GetUserUsageResp, error)
+	GetShardChunks(ctx context.Context, in *GetShardChunksReq, opts ...grpc.CallOption) (*GetShardChunksResp, error)
 }
 
 type apiWithMetaServiceClient struct {
@@ -83,6 +109,24 @@ func (c *apiWithMetaServiceClient) DeleteFile(ctx context.Context, in *DeleteFil
 	return out, nil
 }
 
+func (c *apiWithMetaServiceClient) DeleteFiles(ctx context.Context, in *DeleteFilesReq, opts ...grpc.CallOption) (*DeleteFilesResp, error) {
+	out := new(DeleteFilesResp)
+	err := c.cc.Invoke(ctx, "/meta.ApiWithMetaService/DeleteFiles", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *apiWithMetaServiceClient) CopyFile(ctx context.Context, in *CopyFileReq, opts ...grpc.CallOption) (*CopyFileResp, error) {
+	out := new(CopyFileResp)
+	err := c.cc.Invoke(ctx, "/meta.ApiWithMetaService/CopyFile", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *apiWithMetaServiceClient) GetFileChunks(ctx context.Context, in *GetFileChunksReq, opts ...grpc.CallOption) (*GetFileChunksResp, error) {
 	out := new(GetFileChunksResp)
 	err := c.cc.Invoke(ctx, "/meta.ApiWithMetaService/GetFileChunks", in, out, opts...)
@@ -92,6 +136,222 @@ func (c *apiWithMetaServiceClient) GetFileChunks(ctx context.Context, in *GetFil
 	return out, nil
 }
 
+func (c *apiWithMetaServiceClient) ListBuckets(ctx context.Context, in *ListBucketsReq, opts ...grpc.CallOption) (*ListBucketsResp, error) {
+	out := new(ListBucketsResp)
+	err := c.cc.Invoke(ctx, "/meta.ApiWithMetaService/ListBuckets", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *apiWithMetaServiceClient) GetBucket(ctx context.Context, in *GetBucketReq, opts ...grpc.CallOption) (*GetBucketResp, error) {
+	out := new(GetBucketResp)
+	err := c.cc.Invoke(ctx, "/meta.ApiWithMetaService/GetBucket", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *apiWithMetaServiceClient) GetJob(ctx context.Context, in *GetJobReq, opts ...grpc.CallOption) (*GetJobResp, error) {
+	out := new(GetJobResp)
+	err := c.cc.Invoke(ctx, "/meta.ApiWithMetaService/GetJob", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *apiWithMetaServiceClient) PutFileTags(ctx context.Context, in *PutFileTagsReq, opts ...grpc.CallOption) (*PutFileTagsResp, error) {
+	out := new(PutFileTagsResp)
+	err := c.cc.Invoke(ctx, "/meta.ApiWithMetaService/PutFileTags", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *apiWithMetaServiceClient) GetFileTags(ctx context.Context, in *GetFileTagsReq, opts ...grpc.CallOption) (*GetFileTagsResp, error) {
+	out := new(GetFileTagsResp)
+	err := c.cc.Invoke(ctx, "/meta.ApiWithMetaService/GetFileTags", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *apiWithMetaServiceClient) FindFiles(ctx context.Context, in *FindFilesReq, opts ...grpc.CallOption) (*FindFilesResp, error) {
+	out := new(FindFilesResp)
+	err := c.cc.Invoke(ctx, "/meta.ApiWithMetaService/FindFiles", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *apiWithMetaServiceClient) PutBucketLifecycle(ctx context.Context, in *PutBucketLifecycleReq, opts ...grpc.CallOption) (*PutBucketLifecycleResp, error) {
+	out := new(PutBucketLifecycleResp)
+	err := c.cc.Invoke(ctx, "/meta.ApiWithMetaService/PutBucketLifecycle", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *apiWithMetaServiceClient) GetBucketLifecycle(ctx context.Context, in *GetBucketLifecycleReq, opts ...grpc.CallOption) (*GetBucketLifecycleResp, error) {
+	out := new(GetBucketLifecycleResp)
+	err := c.cc.Invoke(ctx, "/meta.ApiWithMetaService/GetBucketLifecycle", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *apiWithMetaServiceClient) PreviewLifecycle(ctx context.Context, in *PreviewLifecycleReq, opts ...grpc.CallOption) (*PreviewLifecycleResp, error) {
+	out := new(PreviewLifecycleResp)
+	err := c.cc.Invoke(ctx, "/meta.ApiWithMetaService/PreviewLifecycle", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *apiWithMetaServiceClient) RestoreFile(ctx context.Context, in *RestoreFileReq, opts ...grpc.CallOption) (*RestoreFileResp, error) {
+	out := new(RestoreFileResp)
+	err := c.cc.Invoke(ctx, "/meta.ApiWithMetaService/RestoreFile", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *apiWithMetaServiceClient) ListWrappedKeys(ctx context.Context, in *ListWrappedKeysReq, opts ...grpc.CallOption) (*ListWrappedKeysResp, error) {
+	out := new(ListWrappedKeysResp)
+	err := c.cc.Invoke(ctx, "/meta.ApiWithMetaService/ListWrappedKeys", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *apiWithMetaServiceClient) RewrapKey(ctx context.Context, in *RewrapKeyReq, opts ...grpc.CallOption) (*RewrapKeyResp, error) {
+	out := new(RewrapKeyResp)
+	err := c.cc.Invoke(ctx, "/meta.ApiWithMetaService/RewrapKey", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *apiWithMetaServiceClient) CreateUser(ctx context.Context, in *CreateUserReq, opts ...grpc.CallOption) (*CreateUserResp, error) {
+	out := new(CreateUserResp)
+	err := c.cc.Invoke(ctx, "/meta.ApiWithMetaService/CreateUser", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *apiWithMetaServiceClient) CreateAccessKey(ctx context.Context, in *CreateAccessKeyReq, opts ...grpc.CallOption) (*CreateAccessKeyResp, error) {
+	out := new(CreateAccessKeyResp)
+	err := c.cc.Invoke(ctx, "/meta.ApiWithMetaService/CreateAccessKey", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *apiWithMetaServiceClient) GetAccessKey(ctx context.Context, in *GetAccessKeyReq, opts ...grpc.CallOption) (*GetAccessKeyResp, error) {
+	out := new(GetAccessKeyResp)
+	err := c.cc.Invoke(ctx, "/meta.ApiWithMetaService/GetAccessKey", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *apiWithMetaServiceClient) RevokeAccessKey(ctx context.Context, in *RevokeAccessKeyReq, opts ...grpc.CallOption) (*RevokeAccessKeyResp, error) {
+	out := new(RevokeAccessKeyResp)
+	err := c.cc.Invoke(ctx, "/meta.ApiWithMetaService/RevokeAccessKey", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *apiWithMetaServiceClient) ListAccessKeys(ctx context.Context, in *ListAccessKeysReq, opts ...grpc.CallOption) (*ListAccessKeysResp, error) {
+	out := new(ListAccessKeysResp)
+	err := c.cc.Invoke(ctx, "/meta.ApiWithMetaService/ListAccessKeys", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *apiWithMetaServiceClient) PutBucketPolicy(ctx context.Context, in *PutBucketPolicyReq, opts ...grpc.CallOption) (*PutBucketPolicyResp, error) {
+	out := new(PutBucketPolicyResp)
+	err := c.cc.Invoke(ctx, "/meta.ApiWithMetaService/PutBucketPolicy", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *apiWithMetaServiceClient) AddUserToGroup(ctx context.Context, in *AddUserToGroupReq, opts ...grpc.CallOption) (*AddUserToGroupResp, error) {
+	out := new(AddUserToGroupResp)
+	err := c.cc.Invoke(ctx, "/meta.ApiWithMetaService/AddUserToGroup", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *apiWithMetaServiceClient) RemoveUserFromGroup(ctx context.Context, in *RemoveUserFromGroupReq, opts ...grpc.CallOption) (*RemoveUserFromGroupResp, error) {
+	out := new(RemoveUserFromGroupResp)
+	err := c.cc.Invoke(ctx, "/meta.ApiWithMetaService/RemoveUserFromGroup", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *apiWithMetaServiceClient) SetQuota(ctx context.Context, in *SetQuotaReq, opts ...grpc.CallOption) (*SetQuotaResp, error) {
+	out := new(SetQuotaResp)
+	err := c.cc.Invoke(ctx, "/meta.ApiWithMetaService/SetQuota", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *apiWithMetaServiceClient) GetQuotaHeadroom(ctx context.Context, in *GetQuotaHeadroomReq, opts ...grpc.CallOption) (*GetQuotaHeadroomResp, error) {
+	out := new(GetQuotaHeadroomResp)
+	err := c.cc.Invoke(ctx, "/meta.ApiWithMetaService/GetQuotaHeadroom", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *apiWithMetaServiceClient) GetUserUsage(ctx context.Context, in *GetUserUsageReq, opts ...grpc.CallOption) (*GetUserUsageResp, error) {
+	out := new(GetUserUsageResp)
+	err := c.cc.Invoke(ctx, "/meta.ApiWithMetaService/GetUserUsage", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *apiWithMetaServiceClient) GetShardChunks(ctx context.Context, in *GetShardChunksReq, opts ...grpc.CallOption) (*GetShardChunksResp, error) {
+	out := new(GetShardChunksResp)
+	err := c.cc.Invoke(ctx, "/meta.ApiWithMetaService/GetShardChunks", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // ApiWithMetaServiceServer is the server API for ApiWithMetaService service.
 // All implementations must embed UnimplementedApiWithMetaServiceServer
 // for forward compatibility
@@ -101,7 +361,33 @@ type ApiWithMetaServiceServer interface {
 	GetFiles(context.Context, *GetFilesReq) (*GetFilesResp, error)
 	CreateFile(context.Context, *CreateFileReq) (*CreateFileResp, error)
 	DeleteFile(context.Context, *DeleteFileReq) (*DeleteFileResp, error)
+	DeleteFiles(context.Context, *DeleteFilesReq) (*DeleteFilesResp, error)
+	CopyFile(context.Context, *CopyFileReq) (*CopyFileResp, error)
 	GetFileChunks(context.Context, *GetFileChunksReq) (*GetFileChunksResp, error)
+	ListBuckets(context.Context, *ListBucketsReq) (*ListBucketsResp, error)
+	GetBucket(context.Context, *GetBucketReq) (*GetBucketResp, error)
+	GetJob(context.Context, *GetJobReq) (*GetJobResp, error)
+	PutFileTags(context.Context, *PutFileTagsReq) (*PutFileTagsResp, error)
+	GetFileTags(context.Context, *GetFileTagsReq) (*GetFileTagsResp, error)
+	FindFiles(context.Context, *FindFilesReq) (*FindFilesResp, error)
+	PutBucketLifecycle(context.Context, *PutBucketLifecycleReq) (*PutBucketLifecycleResp, error)
+	GetBucketLifecycle(context.Context, *GetBucketLifecycleReq) (*GetBucketLifecycleResp, error)
+	PreviewLifecycle(context.Context, *PreviewLifecycleReq) (*PreviewLifecycleResp, error)
+	RestoreFile(context.Context, *RestoreFileReq) (*RestoreFileResp, error)
+	ListWrappedKeys(context.Context, *ListWrappedKeysReq) (*ListWrappedKeysResp, error)
+	RewrapKey(context.Context, *RewrapKeyReq) (*RewrapKeyResp, error)
+	CreateUser(context.Context, *CreateUserReq) (*CreateUserResp, error)
+	CreateAccessKey(context.Context, *CreateAccessKeyReq) (*CreateAccessKeyResp, error)
+	GetAccessKey(context.Context, *GetAccessKeyReq) (*GetAccessKeyResp, error)
+	RevokeAccessKey(context.Context, *RevokeAccessKeyReq) (*RevokeAccessKeyResp, error)
+	ListAccessKeys(context.Context, *ListAccessKeysReq) (*ListAccessKeysResp, error)
+	PutBucketPolicy(context.Context, *PutBucketPolicyReq) (*PutBucketPolicyResp, error)
+	AddUserToGroup(context.Context, *AddUserToGroupReq) (*AddUserToGroupResp, error)
+	RemoveUserFromGroup(context.Context, *RemoveUserFromGroupReq) (*RemoveUserFromGroupResp, error)
+	SetQuota(context.Context, *SetQuotaReq) (*SetQuotaResp, error)
+	GetQuotaHeadroom(context.Context, *GetQuotaHeadroomReq) (*GetQuotaHeadroomResp, error)
+	GetUserUsage(context.Context, *GetUserUsageReq) (*GetUserUsageResp, error)
+	GetShardChunks(context.Context, *GetShardChunksReq) (*GetShardChunksResp, error)
 	mustEmbedUnimplementedApiWithMetaServiceServer()
 }
 
@@ -124,9 +410,87 @@ func (UnimplementedApiWithMetaServiceServer) CreateFile(context.Context, *Create
 func (UnimplementedApiWithMetaServiceServer) DeleteFile(context.Context, *DeleteFileReq) (*DeleteFileResp, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method DeleteFile not implemented")
 }
+func (UnimplementedApiWithMetaServiceServer) DeleteFiles(context.Context, *DeleteFilesReq) (*DeleteFilesResp, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteFiles not implemented")
+}
+func (UnimplementedApiWithMetaServiceServer) CopyFile(context.Context, *CopyFileReq) (*CopyFileResp, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CopyFile not implemented")
+}
 func (UnimplementedApiWithMetaServiceServer) GetFileChunks(context.Context, *GetFileChunksReq) (*GetFileChunksResp, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method GetFileChunks not implemented")
 }
+func (UnimplementedApiWithMetaServiceServer) ListBuckets(context.Context, *ListBucketsReq) (*ListBucketsResp, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListBuckets not implemented")
+}
+func (UnimplementedApiWithMetaServiceServer) GetBucket(context.Context, *GetBucketReq) (*GetBucketResp, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetBucket not implemented")
+}
+func (UnimplementedApiWithMetaServiceServer) GetJob(context.Context, *GetJobReq) (*GetJobResp, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetJob not implemented")
+}
+func (UnimplementedApiWithMetaServiceServer) PutFileTags(context.Context, *PutFileTagsReq) (*PutFileTagsResp, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PutFileTags not implemented")
+}
+func (UnimplementedApiWithMetaServiceServer) GetFileTags(context.Context, *GetFileTagsReq) (*GetFileTagsResp, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetFileTags not implemented")
+}
+func (UnimplementedApiWithMetaServiceServer) FindFiles(context.Context, *FindFilesReq) (*FindFilesResp, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method FindFiles not implemented")
+}
+func (UnimplementedApiWithMetaServiceServer) PutBucketLifecycle(context.Context, *PutBucketLifecycleReq) (*PutBucketLifecycleResp, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PutBucketLifecycle not implemented")
+}
+func (UnimplementedApiWithMetaServiceServer) GetBucketLifecycle(context.Context, *GetBucketLifecycleReq) (*GetBucketLifecycleResp, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetBucketLifecycle not implemented")
+}
+func (UnimplementedApiWithMetaServiceServer) PreviewLifecycle(context.Context, *PreviewLifecycleReq) (*PreviewLifecycleResp, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PreviewLifecycle not implemented")
+}
+func (UnimplementedApiWithMetaServiceServer) RestoreFile(context.Context, *RestoreFileReq) (*RestoreFileResp, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RestoreFile not implemented")
+}
+func (UnimplementedApiWithMetaServiceServer) ListWrappedKeys(context.Context, *ListWrappedKeysReq) (*ListWrappedKeysResp, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListWrappedKeys not implemented")
+}
+func (UnimplementedApiWithMetaServiceServer) RewrapKey(context.Context, *RewrapKeyReq) (*RewrapKeyResp, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RewrapKey not implemented")
+}
+func (UnimplementedApiWithMetaServiceServer) CreateUser(context.Context, *CreateUserReq) (*CreateUserResp, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateUser not implemented")
+}
+func (UnimplementedApiWithMetaServiceServer) CreateAccessKey(context.Context, *CreateAccessKeyReq) (*CreateAccessKeyResp, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateAccessKey not implemented")
+}
+func (UnimplementedApiWithMetaServiceServer) GetAccessKey(context.Context, *GetAccessKeyReq) (*GetAccessKeyResp, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetAccessKey not implemented")
+}
+func (UnimplementedApiWithMetaServiceServer) RevokeAccessKey(context.Context, *RevokeAccessKeyReq) (*RevokeAccessKeyResp, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RevokeAccessKey not implemented")
+}
+func (UnimplementedApiWithMetaServiceServer) ListAccessKeys(context.Context, *ListAccessKeysReq) (*ListAccessKeysResp, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListAccessKeys not implemented")
+}
+func (UnimplementedApiWithMetaServiceServer) PutBucketPolicy(context.Context, *PutBucketPolicyReq) (*PutBucketPolicyResp, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PutBucketPolicy not implemented")
+}
+func (UnimplementedApiWithMetaServiceServer) AddUserToGroup(context.Context, *AddUserToGroupReq) (*AddUserToGroupResp, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AddUserToGroup not implemented")
+}
+func (UnimplementedApiWithMetaServiceServer) RemoveUserFromGroup(context.Context, *RemoveUserFromGroupReq) (*RemoveUserFromGroupResp, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RemoveUserFromGroup not implemented")
+}
+func (UnimplementedApiWithMetaServiceServer) SetQuota(context.Context, *SetQuotaReq) (*SetQuotaResp, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetQuota not implemented")
+}
+func (UnimplementedApiWithMetaServiceServer) GetQuotaHeadroom(context.Context, *GetQuotaHeadroomReq) (*GetQuotaHeadroomResp, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetQuotaHeadroom not implemented")
+}
+func (UnimplementedApiWithMetaServiceServer) GetUserUsage(context.Context, *GetUserUsageReq) (*GetUserUsageResp, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetUserUsage not implemented")
+}
+func (UnimplementedApiWithMetaServiceServer) GetShardChunks(context.Context, *GetShardChunksReq) (*GetShardChunksResp, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetShardChunks not implemented")
+}
 func (UnimplementedApiWithMetaServiceServer) mustEmbedUnimplementedApiWithMetaServiceServer() {}
 
 // UnsafeApiWithMetaServiceServer may be embedded to opt out of forward compatibility for this service.
@@ -230,6 +594,42 @@ func _ApiWithMetaService_DeleteFile_Handler(srv interface{}, ctx context.Context
 	return interceptor(ctx, in, info, handler)
 }
 
+func _ApiWithMetaService_DeleteFiles_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteFilesReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ApiWithMetaServiceServer).DeleteFiles(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/meta.ApiWithMetaService/DeleteFiles",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ApiWithMetaServiceServer).DeleteFiles(ctx, req.(*DeleteFilesReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ApiWithMetaService_CopyFile_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CopyFileReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ApiWithMetaServiceServer).CopyFile(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/meta.ApiWithMetaService/CopyFile",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ApiWithMetaServiceServer).CopyFile(ctx, req.(*CopyFileReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _ApiWithMetaService_GetFileChunks_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(GetFileChunksReq)
 	if err := dec(in); err != nil {
@@ -248,36 +648,572 @@ func _ApiWithMetaService_GetFileChunks_Handler(srv interface{}, ctx context.Cont
 	return interceptor(ctx, in, info, handler)
 }
 
-// ApiWithMetaService_ServiceDesc is the grpc.ServiceDesc for ApiWithMetaService service.
-// It's only intended for direct use with grpc.RegisterService,
-// and not to be introspected or modified (even as a copy)
-var ApiWithMetaService_ServiceDesc = grpc.ServiceDesc{
-	ServiceName: "meta.ApiWithMetaService",
-	HandlerType: (*ApiWithMetaServiceServer)(nil),
-	Methods: []grpc.MethodDesc{
-		{
-			MethodName: "CreateBucket",
-			Handler:    _ApiWithMetaService_CreateBucket_Handler,
-		},
-		{
-			MethodName: "DeleteBucket",
-			Handler:    _ApiWithMetaService_DeleteBucket_Handler,
-		},
-		{
-			MethodName: "GetFiles",
-			Handler:    _ApiWithMetaService_GetFiles_Handler,
-		},
-		{
-			MethodName: "CreateFile",
-			Handler:    _ApiWithMetaService_CreateFile_Handler,
-		},
-		{
-			MethodName: "DeleteFile",
-			Handler:    _ApiWithMetaService_DeleteFile_Handler,
-		},
-		{
-			MethodName: "GetFileChunks",
-			Handler:    _ApiWithMetaService_GetFileChunks_Handler,
+func _ApiWithMetaService_ListBuckets_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListBucketsReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ApiWithMetaServiceServer).ListBuckets(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/meta.ApiWithMetaService/ListBuckets",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ApiWithMetaServiceServer).ListBuckets(ctx, req.(*ListBucketsReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ApiWithMetaService_GetBucket_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetBucketReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ApiWithMetaServiceServer).GetBucket(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/meta.ApiWithMetaService/GetBucket",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ApiWithMetaServiceServer).GetBucket(ctx, req.(*GetBucketReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ApiWithMetaService_GetJob_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetJobReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ApiWithMetaServiceServer).GetJob(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/meta.ApiWithMetaService/GetJob",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ApiWithMetaServiceServer).GetJob(ctx, req.(*GetJobReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ApiWithMetaService_PutFileTags_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PutFileTagsReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ApiWithMetaServiceServer).PutFileTags(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/meta.ApiWithMetaService/PutFileTags",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ApiWithMetaServiceServer).PutFileTags(ctx, req.(*PutFileTagsReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ApiWithMetaService_GetFileTags_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetFileTagsReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ApiWithMetaServiceServer).GetFileTags(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/meta.ApiWithMetaService/GetFileTags",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ApiWithMetaServiceServer).GetFileTags(ctx, req.(*GetFileTagsReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ApiWithMetaService_FindFiles_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(FindFilesReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ApiWithMetaServiceServer).FindFiles(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/meta.ApiWithMetaService/FindFiles",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ApiWithMetaServiceServer).FindFiles(ctx, req.(*FindFilesReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ApiWithMetaService_PutBucketLifecycle_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PutBucketLifecycleReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ApiWithMetaServiceServer).PutBucketLifecycle(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/meta.ApiWithMetaService/PutBucketLifecycle",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ApiWithMetaServiceServer).PutBucketLifecycle(ctx, req.(*PutBucketLifecycleReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ApiWithMetaService_GetBucketLifecycle_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetBucketLifecycleReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ApiWithMetaServiceServer).GetBucketLifecycle(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/meta.ApiWithMetaService/GetBucketLifecycle",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ApiWithMetaServiceServer).GetBucketLifecycle(ctx, req.(*GetBucketLifecycleReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ApiWithMetaService_PreviewLifecycle_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PreviewLifecycleReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ApiWithMetaServiceServer).PreviewLifecycle(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/meta.ApiWithMetaService/PreviewLifecycle",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ApiWithMetaServiceServer).PreviewLifecycle(ctx, req.(*PreviewLifecycleReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ApiWithMetaService_RestoreFile_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RestoreFileReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ApiWithMetaServiceServer).RestoreFile(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/meta.ApiWithMetaService/RestoreFile",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ApiWithMetaServiceServer).RestoreFile(ctx, req.(*RestoreFileReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ApiWithMetaService_ListWrappedKeys_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListWrappedKeysReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ApiWithMetaServiceServer).ListWrappedKeys(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/meta.ApiWithMetaService/ListWrappedKeys",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ApiWithMetaServiceServer).ListWrappedKeys(ctx, req.(*ListWrappedKeysReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ApiWithMetaService_RewrapKey_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RewrapKeyReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ApiWithMetaServiceServer).RewrapKey(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/meta.ApiWithMetaService/RewrapKey",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ApiWithMetaServiceServer).RewrapKey(ctx, req.(*RewrapKeyReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ApiWithMetaService_CreateUser_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateUserReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ApiWithMetaServiceServer).CreateUser(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/meta.ApiWithMetaService/CreateUser",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ApiWithMetaServiceServer).CreateUser(ctx, req.(*CreateUserReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ApiWithMetaService_CreateAccessKey_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateAccessKeyReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ApiWithMetaServiceServer).CreateAccessKey(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/meta.ApiWithMetaService/CreateAccessKey",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ApiWithMetaServiceServer).CreateAccessKey(ctx, req.(*CreateAccessKeyReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ApiWithMetaService_GetAccessKey_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetAccessKeyReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ApiWithMetaServiceServer).GetAccessKey(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/meta.ApiWithMetaService/GetAccessKey",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ApiWithMetaServiceServer).GetAccessKey(ctx, req.(*GetAccessKeyReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ApiWithMetaService_RevokeAccessKey_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RevokeAccessKeyReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ApiWithMetaServiceServer).RevokeAccessKey(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/meta.ApiWithMetaService/RevokeAccessKey",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ApiWithMetaServiceServer).RevokeAccessKey(ctx, req.(*RevokeAccessKeyReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ApiWithMetaService_ListAccessKeys_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListAccessKeysReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ApiWithMetaServiceServer).ListAccessKeys(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/meta.ApiWithMetaService/ListAccessKeys",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ApiWithMetaServiceServer).ListAccessKeys(ctx, req.(*ListAccessKeysReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ApiWithMetaService_PutBucketPolicy_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PutBucketPolicyReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ApiWithMetaServiceServer).PutBucketPolicy(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/meta.ApiWithMetaService/PutBucketPolicy",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ApiWithMetaServiceServer).PutBucketPolicy(ctx, req.(*PutBucketPolicyReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ApiWithMetaService_AddUserToGroup_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AddUserToGroupReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ApiWithMetaServiceServer).AddUserToGroup(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/meta.ApiWithMetaService/AddUserToGroup",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ApiWithMetaServiceServer).AddUserToGroup(ctx, req.(*AddUserToGroupReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ApiWithMetaService_RemoveUserFromGroup_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RemoveUserFromGroupReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ApiWithMetaServiceServer).RemoveUserFromGroup(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/meta.ApiWithMetaService/RemoveUserFromGroup",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ApiWithMetaServiceServer).RemoveUserFromGroup(ctx, req.(*RemoveUserFromGroupReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ApiWithMetaService_SetQuota_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetQuotaReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ApiWithMetaServiceServer).SetQuota(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/meta.ApiWithMetaService/SetQuota",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ApiWithMetaServiceServer).SetQuota(ctx, req.(*SetQuotaReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ApiWithMetaService_GetQuotaHeadroom_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetQuotaHeadroomReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ApiWithMetaServiceServer).GetQuotaHeadroom(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/meta.ApiWithMetaService/GetQuotaHeadroom",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ApiWithMetaServiceServer).GetQuotaHeadroom(ctx, req.(*GetQuotaHeadroomReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ApiWithMetaService_GetUserUsage_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetUserUsageReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ApiWithMetaServiceServer).GetUserUsage(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/meta.ApiWithMetaService/GetUserUsage",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ApiWithMetaServiceServer).GetUserUsage(ctx, req.(*GetUserUsageReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ApiWithMetaService_GetShardChunks_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetShardChunksReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ApiWithMetaServiceServer).GetShardChunks(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/meta.ApiWithMetaService/GetShardChunks",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ApiWithMetaServiceServer).GetShardChunks(ctx, req.(*GetShardChunksReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// ApiWithMetaService_ServiceDesc is the grpc.ServiceDesc for ApiWithMetaService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var ApiWithMetaService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "meta.ApiWithMetaService",
+	HandlerType: (*ApiWithMetaServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "CreateBucket",
+			Handler:    _ApiWithMetaService_CreateBucket_Handler,
+		},
+		{
+			MethodName: "DeleteBucket",
+			Handler:    _ApiWithMetaService_DeleteBucket_Handler,
+		},
+		{
+			MethodName: "GetFiles",
+			Handler:    _ApiWithMetaService_GetFiles_Handler,
+		},
+		{
+			MethodName: "CreateFile",
+			Handler:    _ApiWithMetaService_CreateFile_Handler,
+		},
+		{
+			MethodName: "DeleteFile",
+			Handler:    _ApiWithMetaService_DeleteFile_Handler,
+		},
+		{
+			MethodName: "DeleteFiles",
+			Handler:    _ApiWithMetaService_DeleteFiles_Handler,
+		},
+		{
+			MethodName: "CopyFile",
+			Handler:    _ApiWithMetaService_CopyFile_Handler,
+		},
+		{
+			MethodName: "GetFileChunks",
+			Handler:    _ApiWithMetaService_GetFileChunks_Handler,
+		},
+		{
+			MethodName: "ListBuckets",
+			Handler:    _ApiWithMetaService_ListBuckets_Handler,
+		},
+		{
+			MethodName: "GetBucket",
+			Handler:    _ApiWithMetaService_GetBucket_Handler,
+		},
+		{
+			MethodName: "GetJob",
+			Handler:    _ApiWithMetaService_GetJob_Handler,
+		},
+		{
+			MethodName: "PutFileTags",
+			Handler:    _ApiWithMetaService_PutFileTags_Handler,
+		},
+		{
+			MethodName: "GetFileTags",
+			Handler:    _ApiWithMetaService_GetFileTags_Handler,
+		},
+		{
+			MethodName: "FindFiles",
+			Handler:    _ApiWithMetaService_FindFiles_Handler,
+		},
+		{
+			MethodName: "PutBucketLifecycle",
+			Handler:    _ApiWithMetaService_PutBucketLifecycle_Handler,
+		},
+		{
+			MethodName: "GetBucketLifecycle",
+			Handler:    _ApiWithMetaService_GetBucketLifecycle_Handler,
+		},
+		{
+			MethodName: "PreviewLifecycle",
+			Handler:    _ApiWithMetaService_PreviewLifecycle_Handler,
+		},
+		{
+			MethodName: "RestoreFile",
+			Handler:    _ApiWithMetaService_RestoreFile_Handler,
+		},
+		{
+			MethodName: "ListWrappedKeys",
+			Handler:    _ApiWithMetaService_ListWrappedKeys_Handler,
+		},
+		{
+			MethodName: "RewrapKey",
+			Handler:    _ApiWithMetaService_RewrapKey_Handler,
+		},
+		{
+			MethodName: "CreateUser",
+			Handler:    _ApiWithMetaService_CreateUser_Handler,
+		},
+		{
+			MethodName: "CreateAccessKey",
+			Handler:    _ApiWithMetaService_CreateAccessKey_Handler,
+		},
+		{
+			MethodName: "GetAccessKey",
+			Handler:    _ApiWithMetaService_GetAccessKey_Handler,
+		},
+		{
+			MethodName: "RevokeAccessKey",
+			Handler:    _ApiWithMetaService_RevokeAccessKey_Handler,
+		},
+		{
+			MethodName: "ListAccessKeys",
+			Handler:    _ApiWithMetaService_ListAccessKeys_Handler,
+		},
+		{
+			MethodName: "PutBucketPolicy",
+			Handler:    _ApiWithMetaService_PutBucketPolicy_Handler,
+		},
+		{
+			MethodName: "AddUserToGroup",
+			Handler:    _ApiWithMetaService_AddUserToGroup_Handler,
+		},
+		{
+			MethodName: "RemoveUserFromGroup",
+			Handler:    _ApiWithMetaService_RemoveUserFromGroup_Handler,
+		},
+		{
+			MethodName: "SetQuota",
+			Handler:    _ApiWithMetaService_SetQuota_Handler,
+		},
+		{
+			MethodName: "GetQuotaHeadroom",
+			Handler:    _ApiWithMetaService_GetQuotaHeadroom_Handler,
+		},
+		{
+			MethodName: "GetUserUsage",
+			Handler:    _ApiWithMetaService_GetUserUsage_Handler,
+		},
+		{
+			MethodName: "GetShardChunks",
+			Handler:    _ApiWithMetaService_GetShardChunks_Handler,
 		},
 	},
 	Streams:  []grpc.StreamDesc{},
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	metapb "meta/proto"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// shardSummaryTimeout bounds waiting for one shard, unreachable shard must not hang the whole summary
+const shardSummaryTimeout = 5 * time.Second
+
+// shardStats is usage of one shard as it's reported by the shard at /stats/get
+type shardStats struct {
+	Shard      string `json:"shard"`
+	Tier       string `json:"tier"`
+	Reachable  bool   `json:"reachable"`
+	Error      string `json:"error,omitempty"`
+	Chunks     int64  `json:"chunks"`
+	UsedBytes  int64  `json:"used_bytes"`
+	FreeBytes  int64  `json:"free_bytes"`
+	TotalBytes int64  `json:"total_bytes"`
+	// operations with chunks and their failures since the shard has started
+	Ops    map[string]int64 `json:"ops,omitempty"`
+	Errors map[string]int64 `json:"errors,omitempty"`
+}
+
+// tierSkew shows how evenly chunks are spread over reachable shards of one tier. Skew is
+// (max - min) / mean, 0 means that all shards store the same
+type tierSkew struct {
+	Tier          string  `json:"tier"`
+	Shards        int     `json:"shards"`
+	MinChunks     int64   `json:"min_chunks"`
+	MaxChunks     int64   `json:"max_chunks"`
+	ChunksSkew    float64 `json:"chunks_skew"`
+	MinUsedBytes  int64   `json:"min_used_bytes"`
+	MaxUsedBytes  int64   `json:"max_used_bytes"`
+	UsedBytesSkew float64 `json:"used_bytes_skew"`
+}
+
+type bucketStats struct {
+	Bucket       string `json:"bucket"`
+	Owner        string `json:"owner"`
+	State        string `json:"state"`
+	Objects      int64  `json:"objects"`
+	Bytes        int64  `json:"bytes"`
+	StoredBytes  int64  `json:"stored_bytes"`
+	QuotaBytes   int64  `json:"quota_bytes"`
+	QuotaObjects int64  `json:"quota_objects"`
+	Chunks       int64  `json:"chunks"`
+	// chunks of the bucket by shards which store them
+	ShardChunks map[string]int64 `json:"shard_chunks"`
+}
+
+type clusterStats struct {
+	Shards          []shardStats `json:"shards"`
+	ReachableShards int          `json:"reachable_shards"`
+	// sums over reachable shards
+	Chunks    int64         `json:"chunks"`
+	UsedBytes int64         `json:"used_bytes"`
+	FreeBytes int64         `json:"free_bytes"`
+	Skew      []tierSkew    `json:"skew"`
+	Buckets   []bucketStats `json:"buckets"`
+	// buckets are missing if meta service has failed, stats of shards are returned anyway
+	MetaError string `json:"meta_error,omitempty"`
+}
+
+type objectChunk struct {
+	Chunk string `json:"chunk"`
+	Shard string `json:"shard"`
+}
+
+type objectStats struct {
+	Bucket     string `json:"bucket"`
+	File       string `json:"file"`
+	Size       int64  `json:"size"`
+	StoredSize int64  `json:"stored_size"`
+	Tier       string `json:"tier"`
+	Archived   bool   `json:"archived"`
+	// empty if file was not restored
+	RestoredUntil string        `json:"restored_until,omitempty"`
+	Codec         string        `json:"codec"`
+	Encryption    string        `json:"encryption"`
+	CreatedAt     string        `json:"created_at"`
+	Chunks        []objectChunk `json:"chunks"`
+	// chunks of the file by shards which store them
+	ShardChunks map[string]int64 `json:"shard_chunks"`
+}
+
+func writeJSON(w http.ResponseWriter, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(value)
+}
+
+// getShardSummary reads usage of the shard, errors are reported in the result
+func (s *statServer) getShardSummary(ctx context.Context, shard string, port int) shardStats {
+	stats := shardStats{Shard: shard}
+
+	ctx, cancel := context.WithTimeout(ctx, shardSummaryTimeout)
+	defer cancel()
+	shard_req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.getShardURL(shard, port), nil)
+	if err != nil {
+		stats.Error = err.Error()
+		return stats
+	}
+	resp, err := s.http_client.Do(shard_req)
+	if err != nil {
+		stats.Error = err.Error()
+		return stats
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		stats.Error = "shard responded with status " + resp.Status
+		return stats
+	}
+	err = json.NewDecoder(resp.Body).Decode(&stats)
+	if err != nil {
+		stats.Error = fmt.Sprintf("invalid summary of shard: %v", err)
+		return stats
+	}
+	stats.Reachable = true
+	return stats
+}
+
+// sortedShards returns shards sorted by tiers and names and tiers of shards
+func (s *statServer) sortedShards() ([]string, map[string]string) {
+	shard_tiers := make(map[string]string, len(s.config.Shards))
+	for tier, tier_info := range s.config.GetTiers() {
+		for _, shard := range tier_info.Shards {
+			shard_tiers[shard] = tier
+		}
+	}
+
+	shards := make([]string, 0, len(s.config.Shards))
+	for shard := range s.config.Shards {
+		shards = append(shards, shard)
+	}
+	sort.Slice(shards, func(i, j int) bool {
+		if shard_tiers[shards[i]] != shard_tiers[shards[j]] {
+			return shard_tiers[shards[i]] < shard_tiers[shards[j]]
+		}
+		return shards[i] < shards[j]
+	})
+	return shards, shard_tiers
+}
+
+// getShardSummaries requests all shards in parallel, shards are sorted by tiers and names
+func (s *statServer) getShardSummaries(ctx context.Context) []shardStats {
+	shards, shard_tiers := s.sortedShards()
+	summaries := make([]shardStats, len(shards))
+	var wg sync.WaitGroup
+	for i, shard := range shards {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			summaries[i] = s.getShardSummary(ctx, shard, s.config.Shards[shard])
+			summaries[i].Tier = shard_tiers[shard]
+		}()
+	}
+	wg.Wait()
+	return summaries
+}
+
+func skewOf(min_value, max_value, sum int64, n int) float64 {
+	if sum == 0 {
+		return 0
+	}
+	return float64(max_value-min_value) / (float64(sum) / float64(n))
+}
+
+// skewOfTiers compares reachable shards within tiers, shards of different tiers are not expected
+// to store the same
+func skewOfTiers(summaries []shardStats) []tierSkew {
+	skews := make([]tierSkew, 0)
+	chunks := make([]int64, 0)
+	used_bytes := make([]int64, 0)
+	tier_index := make(map[string]int)
+	for _, summary := range summaries {
+		if !summary.Reachable {
+			continue
+		}
+		i, ok := tier_index[summary.Tier]
+		if !ok {
+			i = len(skews)
+			tier_index[summary.Tier] = i
+			skews = append(skews, tierSkew{Tier: summary.Tier, MinChunks: summary.Chunks, MinUsedBytes: summary.UsedBytes})
+			chunks = append(chunks, 0)
+			used_bytes = append(used_bytes, 0)
+		}
+
+		skew := &skews[i]
+		skew.Shards++
+		skew.MinChunks = min(skew.MinChunks, summary.Chunks)
+		skew.MaxChunks = max(skew.MaxChunks, summary.Chunks)
+		skew.MinUsedBytes = min(skew.MinUsedBytes, summary.UsedBytes)
+		skew.MaxUsedBytes = max(skew.MaxUsedBytes, summary.UsedBytes)
+		chunks[i] += summary.Chunks
+		used_bytes[i] += summary.UsedBytes
+	}
+
+	for i := range skews {
+		skews[i].ChunksSkew = skewOf(skews[i].MinChunks, skews[i].MaxChunks, chunks[i], skews[i].Shards)
+		skews[i].UsedBytesSkew = skewOf(skews[i].MinUsedBytes, skews[i].MaxUsedBytes, used_bytes[i], skews[i].Shards)
+	}
+	return skews
+}
+
+// getShardChunks reads chunks of buckets by shards from meta service, all buckets are read if bucket is empty
+func (s *statServer) getShardChunks(ctx context.Context, bucket string) (map[string]map[string]int64, error) {
+	resp, err := s.grpc_client.GetShardChunks(ctx, &metapb.GetShardChunksReq{Bucket: bucket})
+	if err != nil {
+		return nil, err
+	}
+
+	bucket_chunks := make(map[string]map[string]int64)
+	for _, counted := range resp.ShardChunks {
+		if bucket_chunks[counted.Bucket] == nil {
+			bucket_chunks[counted.Bucket] = make(map[string]int64)
+		}
+		bucket_chunks[counted.Bucket][counted.Shard] = counted.Chunks
+	}
+	return bucket_chunks, nil
+}
+
+func newBucketStats(info *metapb.BucketInfo, shard_chunks map[string]int64) bucketStats {
+	stats := bucketStats{
+		Bucket:       info.Bucket,
+		Owner:        info.Owner,
+		State:        info.State,
+		Objects:      info.Objects,
+		Bytes:        info.Bytes,
+		StoredBytes:  info.StoredBytes,
+		QuotaBytes:   info.QuotaBytes,
+		QuotaObjects: info.QuotaObjects,
+		ShardChunks:  make(map[string]int64),
+	}
+	for shard, chunks := range shard_chunks {
+		stats.ShardChunks[shard] = chunks
+		stats.Chunks += chunks
+	}
+	return stats
+}
+
+// getBucketsStats joins buckets with their chunks on shards
+func (s *statServer) getBucketsStats(ctx context.Context) ([]bucketStats, error) {
+	resp, err := s.grpc_client.ListBuckets(ctx, &metapb.ListBucketsReq{})
+	if err != nil {
+		return nil, err
+	}
+	bucket_chunks, err := s.getShardChunks(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+
+	buckets := make([]bucketStats, 0, len(resp.Buckets))
+	for _, info := range resp.Buckets {
+		buckets = append(buckets, newBucketStats(info, bucket_chunks[info.Bucket]))
+	}
+	return buckets, nil
+}
+
+func (s *statServer) getClusterStats(w http.ResponseWriter, req *http.Request) {
+	var summaries []shardStats
+	shards_done := make(chan struct{})
+	go func() {
+		summaries = s.getShardSummaries(req.Context())
+		close(shards_done)
+	}()
+
+	cluster := clusterStats{}
+	buckets, err := s.getBucketsStats(req.Context())
+	if err != nil {
+		cluster.MetaError = err.Error()
+	} else {
+		cluster.Buckets = buckets
+	}
+	<-shards_done
+
+	cluster.Shards = summaries
+	for _, summary := range summaries {
+		if !summary.Reachable {
+			continue
+		}
+		cluster.ReachableShards++
+		cluster.Chunks += summary.Chunks
+		cluster.UsedBytes += summary.UsedBytes
+		cluster.FreeBytes += summary.FreeBytes
+	}
+	cluster.Skew = skewOfTiers(summaries)
+
+	writeJSON(w, cluster)
+}
+
+func (s *statServer) getBucketStats(w http.ResponseWriter, req *http.Request) {
+	bucket := mux.Vars(req)["bucket"]
+
+	resp, err := s.grpc_client.GetBucket(req.Context(), &metapb.GetBucketReq{Bucket: bucket})
+	if err != nil {
+		writeMetaError(w, err)
+		return
+	}
+	bucket_chunks, err := s.getShardChunks(req.Context(), bucket)
+	if err != nil {
+		writeMetaError(w, err)
+		return
+	}
+
+	writeJSON(w, newBucketStats(resp.Bucket, bucket_chunks[bucket]))
+}
+
+func (s *statServer) getObjectStats(w http.ResponseWriter, req *http.Request) {
+	bucket := mux.Vars(req)["bucket"]
+	file := mux.Vars(req)["file"]
+
+	resp, err := s.grpc_client.GetFileChunks(req.Context(), &metapb.GetFileChunksReq{Bucket: bucket, File: file})
+	if err != nil {
+		writeMetaError(w, err)
+		return
+	}
+
+	stats := objectStats{
+		Bucket:      bucket,
+		File:        file,
+		Size:        resp.Size,
+		StoredSize:  resp.StoredSize,
+		Tier:        resp.Tier,
+		Archived:    resp.Archived,
+		Codec:       resp.Codec,
+		Encryption:  resp.Encryption,
+		CreatedAt:   time.Unix(resp.CreatedAt, 0).UTC().Format(time.RFC3339),
+		Chunks:      make([]objectChunk, 0, len(resp.Chunks)),
+		ShardChunks: make(map[string]int64),
+	}
+	if resp.RestoredUntil != 0 {
+		stats.RestoredUntil = time.Unix(resp.RestoredUntil, 0).UTC().Format(time.RFC3339)
+	}
+	for _, chunk := range resp.Chunks {
+		stats.Chunks = append(stats.Chunks, objectChunk{Chunk: chunk.Filename, Shard: chunk.Shard})
+		stats.ShardChunks[chunk.Shard]++
+	}
+
+	writeJSON(w, stats)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"common"
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+)
+
+// states of the cluster: all services are ready, some of them are not, or the cluster can't serve
+// files because api or meta service isn't ready or quorum of shards of the default tier is lost
+const (
+	clusterOk       = "ok"
+	clusterDegraded = "degraded"
+	clusterDown     = "down"
+)
+
+type serviceStatus struct {
+	Service string `json:"service"`
+	Ready   bool   `json:"ready"`
+	// service didn't answer or isn't serving
+	Error  string                        `json:"error,omitempty"`
+	Checks map[string]common.CheckResult `json:"checks,omitempty"`
+}
+
+type clusterStatus struct {
+	Status   string          `json:"status"`
+	Services []serviceStatus `json:"services"`
+}
+
+func (s *statServer) readinessChecks() map[string]common.ReadinessCheck {
+	return map[string]common.ReadinessCheck{
+		common.MetaServiceName: common.GrpcHealthCheck(s.conn),
+	}
+}
+
+// remoteStatus asks the service at base_url whether it's ready
+func (s *statServer) remoteStatus(ctx context.Context, service, base_url string) serviceStatus {
+	status := serviceStatus{Service: service}
+	readiness, err := common.RemoteReadiness(ctx, s.health_client, base_url)
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+	status.Ready = readiness.Ready
+	status.Checks = readiness.Checks
+	return status
+}
+
+// getClusterStatus checks readiness of all services in parallel. It responds with 503 if the cluster is down
+func (s *statServer) getClusterStatus(w http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+	checks := map[string]func() serviceStatus{
+		common.ApiServiceName: func() serviceStatus {
+			return s.remoteStatus(ctx, common.ApiServiceName, s.config.GetServiceURL(common.ApiServiceName, s.config.Api_port))
+		},
+		common.MetaServiceName: func() serviceStatus {
+			status := serviceStatus{Service: common.MetaServiceName, Ready: true}
+			err := common.GrpcHealthCheck(s.conn)(ctx)
+			if err != nil {
+				status.Ready = false
+				status.Error = err.Error()
+			}
+			return status
+		},
+		common.StatServiceName: func() serviceStatus {
+			readiness := common.CheckReadiness(ctx, s.readinessChecks())
+			return serviceStatus{Service: common.StatServiceName, Ready: readiness.Ready, Checks: readiness.Checks}
+		},
+	}
+	for shard, port := range s.config.Shards {
+		checks[shard] = func() serviceStatus {
+			return s.remoteStatus(ctx, shard, s.config.GetServiceURL(shard, port))
+		}
+	}
+
+	statuses := make(map[string]serviceStatus, len(checks))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, check := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			status := check()
+			mu.Lock()
+			statuses[name] = status
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	cluster := clusterStatus{Status: clusterOk}
+	for _, service := range []string{common.ApiServiceName, common.MetaServiceName, common.StatServiceName} {
+		cluster.Services = append(cluster.Services, statuses[service])
+	}
+	shards, _ := s.sortedShards()
+	for _, shard := range shards {
+		cluster.Services = append(cluster.Services, statuses[shard])
+	}
+	for _, status := range cluster.Services {
+		if !status.Ready {
+			cluster.Status = clusterDegraded
+		}
+	}
+
+	default_shards := s.config.GetTierShards(s.config.GetDefaultTier())
+	ready_shards := 0
+	for shard := range default_shards {
+		if statuses[shard].Ready {
+			ready_shards++
+		}
+	}
+	code := http.StatusOK
+	if !statuses[common.ApiServiceName].Ready || !statuses[common.MetaServiceName].Ready || ready_shards*2 <= len(default_shards) {
+		cluster.Status = clusterDown
+		code = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(cluster)
+}
@@ -0,0 +1,503 @@
+package main
+
+import (
+	"common"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	metapb "meta/proto"
+	"net/http"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	defaultHistoryRetention = 30 * 24 * time.Hour
+	defaultHistoryPath      = "history.jsonl"
+)
+
+type shardUsage struct {
+	Chunks    int64 `json:"chunks"`
+	UsedBytes int64 `json:"used_bytes"`
+	FreeBytes int64 `json:"free_bytes"`
+}
+
+type bucketUsage struct {
+	Objects     int64 `json:"objects"`
+	Bytes       int64 `json:"bytes"`
+	StoredBytes int64 `json:"stored_bytes"`
+}
+
+// usageSample is usage of the cluster at one moment. Unreachable shards are missing from it,
+// buckets are missing if meta service has failed
+type usageSample struct {
+	Time    time.Time              `json:"time"`
+	Shards  map[string]shardUsage  `json:"shards"`
+	Buckets map[string]bucketUsage `json:"buckets,omitempty"`
+}
+
+// historyStore keeps samples in memory and appends them to a file as JSON lines, so history
+// survives restarts. Expired samples are removed from the file when it's rewritten
+type historyStore struct {
+	mu        sync.RWMutex
+	path      string
+	retention time.Duration
+	// sorted by time
+	samples []usageSample
+	file    *os.File
+	// samples which are dropped from memory, but are still in the file
+	expired int
+}
+
+func openHistory(path string, retention time.Duration) (*historyStore, error) {
+	h := &historyStore{path: path, retention: retention}
+
+	file, err := os.Open(path)
+	if err == nil {
+		decoder := json.NewDecoder(file)
+		for {
+			var sample usageSample
+			err = decoder.Decode(&sample)
+			if errors.Is(err, io.EOF) {
+				break
+			} else if err != nil {
+				// the last sample may be written partially if the service was killed
+				slog.Warn("history is read till broken sample", "path", path, "samples", len(h.samples), "error", err)
+				break
+			}
+			h.samples = append(h.samples, sample)
+		}
+		file.Close()
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	h.dropExpired(time.Now())
+	err = h.rewrite()
+	if err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// rewrite replaces the file with samples which are in memory and opens it for appending
+func (h *historyStore) rewrite() error {
+	h.samples = slices.Clone(h.samples)
+
+	tmp_path := h.path + ".tmp"
+	tmp_file, err := os.Create(tmp_path)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(tmp_file)
+	for _, sample := range h.samples {
+		err = encoder.Encode(sample)
+		if err != nil {
+			tmp_file.Close()
+			return err
+		}
+	}
+	err = tmp_file.Close()
+	if err != nil {
+		return err
+	}
+
+	if h.file != nil {
+		h.file.Close()
+		h.file = nil
+	}
+	err = os.Rename(tmp_path, h.path)
+	if err != nil {
+		return err
+	}
+	h.file, err = os.OpenFile(h.path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	h.expired = 0
+	return nil
+}
+
+func (h *historyStore) dropExpired(now time.Time) {
+	oldest := now.Add(-h.retention)
+	i := sort.Search(len(h.samples), func(i int) bool { return !h.samples[i].Time.Before(oldest) })
+	h.samples = h.samples[i:]
+	h.expired += i
+}
+
+// close closes the file on shutdown, when samples are not collected anymore
+func (h *historyStore) close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.file == nil {
+		return nil
+	}
+	err := h.file.Close()
+	h.file = nil
+	return err
+}
+
+func (h *historyStore) add(sample usageSample) error {
+	line, err := json.Marshal(sample)
+	if err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.samples = append(h.samples, sample)
+	h.dropExpired(sample.Time)
+	// file is rewritten when most of it is expired, so it doesn't grow forever
+	if h.expired > len(h.samples) {
+		return h.rewrite()
+	}
+	if h.file == nil {
+		return errors.New("file of history is not open")
+	}
+	_, err = h.file.Write(append(line, '\n'))
+	return err
+}
+
+// between returns samples which are taken from from till to, they must not be modified
+func (h *historyStore) between(from, to time.Time) []usageSample {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	start := sort.Search(len(h.samples), func(i int) bool { return !h.samples[i].Time.Before(from) })
+	end := sort.Search(len(h.samples), func(i int) bool { return h.samples[i].Time.After(to) })
+	if start >= end {
+		return nil
+	}
+	return slices.Clone(h.samples[start:end])
+}
+
+// sampleUsage takes one sample of usage of shards and buckets
+func (s *statServer) sampleUsage(ctx context.Context) usageSample {
+	sample := usageSample{Time: time.Now().UTC(), Shards: make(map[string]shardUsage)}
+	for _, summary := range s.getShardSummaries(ctx) {
+		if !summary.Reachable {
+			slog.WarnContext(ctx, "shard is missing from sample of usage", "shard", summary.Shard, "error", summary.Error)
+			continue
+		}
+		sample.Shards[summary.Shard] = shardUsage{Chunks: summary.Chunks, UsedBytes: summary.UsedBytes, FreeBytes: summary.FreeBytes}
+	}
+
+	resp, err := s.grpc_client.ListBuckets(ctx, &metapb.ListBucketsReq{})
+	if err != nil {
+		slog.WarnContext(ctx, "buckets are missing from sample of usage", "error", err)
+		return sample
+	}
+	sample.Buckets = make(map[string]bucketUsage, len(resp.Buckets))
+	for _, info := range resp.Buckets {
+		sample.Buckets[info.Bucket] = bucketUsage{Objects: info.Objects, Bytes: info.Bytes, StoredBytes: info.StoredBytes}
+	}
+	return sample
+}
+
+// collectHistory samples usage every interval till ctx is cancelled
+func (s *statServer) collectHistory(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		sample_ctx, cancel := context.WithTimeout(common.WithRequestID(ctx, common.NewRequestID()), interval)
+		sample := s.sampleUsage(sample_ctx)
+		// sample which is interrupted by shutdown misses shards and buckets, so it's not saved
+		if ctx.Err() == nil {
+			err := s.history.add(sample)
+			if err != nil {
+				slog.ErrorContext(sample_ctx, "failed to save sample of usage", "error", err)
+			}
+		}
+		cancel()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// parsePeriod parses durations like time.ParseDuration, and also days like 7d
+func parsePeriod(value string) (time.Duration, error) {
+	if days, found := strings.CutSuffix(value, "d"); found {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("invalid number of days %q", value)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+
+	period, err := time.ParseDuration(value)
+	if err != nil || period <= 0 {
+		return 0, fmt.Errorf("invalid period %q", value)
+	}
+	return period, nil
+}
+
+// historyRange reads since and step queries, history of the last 7 days is returned by default.
+// Zero step means that all samples are returned
+func historyRange(req *http.Request) (time.Time, time.Time, time.Duration, error) {
+	to := time.Now().UTC()
+	since := 7 * 24 * time.Hour
+	var step time.Duration
+	var err error
+	if value := req.URL.Query().Get("since"); value != "" {
+		since, err = parsePeriod(value)
+		if err != nil {
+			return to, to, 0, err
+		}
+	}
+	if value := req.URL.Query().Get("step"); value != "" {
+		step, err = parsePeriod(value)
+		if err != nil {
+			return to, to, 0, err
+		}
+	}
+	return to.Add(-since), to, step, nil
+}
+
+// downsample keeps the last point of every step, points must be sorted by time
+func downsample[T any](points []T, time_of func(T) time.Time, step time.Duration) []T {
+	if step == 0 {
+		return points
+	}
+	result := make([]T, 0)
+	for _, point := range points {
+		if len(result) > 0 && time_of(result[len(result)-1]).Truncate(step).Equal(time_of(point).Truncate(step)) {
+			result[len(result)-1] = point
+		} else {
+			result = append(result, point)
+		}
+	}
+	return result
+}
+
+// growthPerDay is the slope of least squares line through values, nil if it can't be estimated
+func growthPerDay(times []time.Time, values []int64) *float64 {
+	if len(times) < 2 {
+		return nil
+	}
+	var sum_x, sum_y, sum_xx, sum_xy float64
+	for i := range times {
+		x := times[i].Sub(times[0]).Hours() / 24
+		y := float64(values[i])
+		sum_x += x
+		sum_y += y
+		sum_xx += x * x
+		sum_xy += x * y
+	}
+	n := float64(len(times))
+	denominator := n*sum_xx - sum_x*sum_x
+	if denominator == 0 {
+		return nil
+	}
+	growth := (n*sum_xy - sum_x*sum_y) / denominator
+	return &growth
+}
+
+// daysUntil estimates when growing usage reaches limit, nil if usage doesn't grow
+func daysUntil(used, limit int64, growth *float64) *float64 {
+	if growth == nil || *growth <= 0 {
+		return nil
+	}
+	days := max(float64(limit-used), 0) / *growth
+	return &days
+}
+
+type shardPoint struct {
+	Time time.Time `json:"time"`
+	shardUsage
+}
+
+type bucketPoint struct {
+	Time time.Time `json:"time"`
+	bucketUsage
+}
+
+type shardsHistory struct {
+	From   time.Time               `json:"from"`
+	To     time.Time               `json:"to"`
+	Shards map[string][]shardPoint `json:"shards"`
+}
+
+type bucketHistory struct {
+	Bucket string        `json:"bucket"`
+	From   time.Time     `json:"from"`
+	To     time.Time     `json:"to"`
+	Points []bucketPoint `json:"points"`
+}
+
+type shardProjection struct {
+	Shard     string `json:"shard"`
+	UsedBytes int64  `json:"used_bytes"`
+	FreeBytes int64  `json:"free_bytes"`
+	// null if there are not enough samples
+	GrowthBytesPerDay *float64 `json:"growth_bytes_per_day"`
+	// null if shard doesn't grow
+	DaysUntilFull *float64 `json:"days_until_full"`
+}
+
+type bucketProjection struct {
+	Bucket              string   `json:"bucket"`
+	Objects             int64    `json:"objects"`
+	Bytes               int64    `json:"bytes"`
+	GrowthObjectsPerDay *float64 `json:"growth_objects_per_day"`
+	GrowthBytesPerDay   *float64 `json:"growth_bytes_per_day"`
+	QuotaBytes          int64    `json:"quota_bytes"`
+	// null if bucket has no quota or doesn't grow
+	DaysUntilQuota *float64 `json:"days_until_quota"`
+}
+
+type usageProjection struct {
+	From    time.Time          `json:"from"`
+	To      time.Time          `json:"to"`
+	Shards  []shardProjection  `json:"shards"`
+	Buckets []bucketProjection `json:"buckets"`
+	// quotas of buckets are missing if meta service has failed
+	MetaError string `json:"meta_error,omitempty"`
+}
+
+// readHistory returns samples of the requested range and the range itself. It writes error and
+// returns false if history is not collected or range is invalid
+func (s *statServer) readHistory(w http.ResponseWriter, req *http.Request) ([]usageSample, time.Time, time.Time, time.Duration, bool) {
+	if s.history == nil {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintln(w, "History of usage is not collected, set history.interval_seconds in config.json")
+		return nil, time.Time{}, time.Time{}, 0, false
+	}
+	from, to, step, err := historyRange(req)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Invalid range of history: %v\n", err)
+		return nil, time.Time{}, time.Time{}, 0, false
+	}
+	return s.history.between(from, to), from, to, step, true
+}
+
+func (s *statServer) getShardsHistory(w http.ResponseWriter, req *http.Request) {
+	samples, from, to, step, ok := s.readHistory(w, req)
+	if !ok {
+		return
+	}
+	only_shard := req.URL.Query().Get("shard")
+	if _, exists := s.config.Shards[only_shard]; only_shard != "" && !exists {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Unknown shard %s\n", only_shard)
+		return
+	}
+
+	history := shardsHistory{From: from, To: to, Shards: make(map[string][]shardPoint)}
+	for _, sample := range samples {
+		for shard, usage := range sample.Shards {
+			if only_shard == "" || shard == only_shard {
+				history.Shards[shard] = append(history.Shards[shard], shardPoint{Time: sample.Time, shardUsage: usage})
+			}
+		}
+	}
+	for shard, points := range history.Shards {
+		history.Shards[shard] = downsample(points, func(point shardPoint) time.Time { return point.Time }, step)
+	}
+
+	writeJSON(w, history)
+}
+
+func (s *statServer) getBucketHistory(w http.ResponseWriter, req *http.Request) {
+	bucket := mux.Vars(req)["bucket"]
+	samples, from, to, step, ok := s.readHistory(w, req)
+	if !ok {
+		return
+	}
+
+	history := bucketHistory{Bucket: bucket, From: from, To: to, Points: make([]bucketPoint, 0)}
+	for _, sample := range samples {
+		if usage, exists := sample.Buckets[bucket]; exists {
+			history.Points = append(history.Points, bucketPoint{Time: sample.Time, bucketUsage: usage})
+		}
+	}
+	history.Points = downsample(history.Points, func(point bucketPoint) time.Time { return point.Time }, step)
+
+	writeJSON(w, history)
+}
+
+// getProjection estimates growth of shards and buckets by their history and when they will be full
+func (s *statServer) getProjection(w http.ResponseWriter, req *http.Request) {
+	samples, from, to, _, ok := s.readHistory(w, req)
+	if !ok {
+		return
+	}
+
+	projection := usageProjection{From: from, To: to, Shards: make([]shardProjection, 0), Buckets: make([]bucketProjection, 0)}
+
+	shard_times := make(map[string][]time.Time)
+	shard_used := make(map[string][]int64)
+	shard_last := make(map[string]shardUsage)
+	bucket_times := make(map[string][]time.Time)
+	bucket_objects := make(map[string][]int64)
+	bucket_bytes := make(map[string][]int64)
+	for _, sample := range samples {
+		for shard, usage := range sample.Shards {
+			shard_times[shard] = append(shard_times[shard], sample.Time)
+			shard_used[shard] = append(shard_used[shard], usage.UsedBytes)
+			shard_last[shard] = usage
+		}
+		for bucket, usage := range sample.Buckets {
+			bucket_times[bucket] = append(bucket_times[bucket], sample.Time)
+			bucket_objects[bucket] = append(bucket_objects[bucket], usage.Objects)
+			bucket_bytes[bucket] = append(bucket_bytes[bucket], usage.Bytes)
+		}
+	}
+
+	for shard, times := range shard_times {
+		growth := growthPerDay(times, shard_used[shard])
+		projection.Shards = append(projection.Shards, shardProjection{
+			Shard:             shard,
+			UsedBytes:         shard_last[shard].UsedBytes,
+			FreeBytes:         shard_last[shard].FreeBytes,
+			GrowthBytesPerDay: growth,
+			DaysUntilFull:     daysUntil(0, shard_last[shard].FreeBytes, growth),
+		})
+	}
+	sort.Slice(projection.Shards, func(i, j int) bool { return projection.Shards[i].Shard < projection.Shards[j].Shard })
+
+	quotas := make(map[string]int64)
+	resp, err := s.grpc_client.ListBuckets(req.Context(), &metapb.ListBucketsReq{})
+	if err != nil {
+		projection.MetaError = err.Error()
+	} else {
+		for _, info := range resp.Buckets {
+			quotas[info.Bucket] = info.QuotaBytes
+		}
+	}
+	for bucket, times := range bucket_times {
+		objects := bucket_objects[bucket]
+		bytes := bucket_bytes[bucket]
+		bucket_projection := bucketProjection{
+			Bucket:              bucket,
+			Objects:             objects[len(objects)-1],
+			Bytes:               bytes[len(bytes)-1],
+			GrowthObjectsPerDay: growthPerDay(times, objects),
+			GrowthBytesPerDay:   growthPerDay(times, bytes),
+			QuotaBytes:          quotas[bucket],
+		}
+		if bucket_projection.QuotaBytes > 0 {
+			bucket_projection.DaysUntilQuota = daysUntil(bucket_projection.Bytes, bucket_projection.QuotaBytes, bucket_projection.GrowthBytesPerDay)
+		}
+		projection.Buckets = append(projection.Buckets, bucket_projection)
+	}
+	sort.Slice(projection.Buckets, func(i, j int) bool { return projection.Buckets[i].Bucket < projection.Buckets[j].Bucket })
+
+	writeJSON(w, projection)
+}
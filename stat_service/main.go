@@ -2,21 +2,36 @@ package main
 
 import (
 	"common"
+	"context"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
+	metapb "meta/proto"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gorilla/mux"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
 )
 
 type statServer struct {
-	config common.Config
+	config      common.Config
+	http_client *http.Client
+	// usage of buckets and users is read from meta service
+	conn        *grpc.ClientConn
+	grpc_client metapb.ApiWithMetaServiceClient
+	// checks readiness of other services, so checks are not mixed with requests of stats in metrics
+	health_client *http.Client
+	// nil if history of usage is not collected
+	history *historyStore
 }
 
-func getShardURL(shard string, port int) string {
-	return "http://" + shard + ":" + strconv.Itoa(port) + "/stats/get"
+func (s *statServer) getShardURL(shard string, port int) string {
+	return s.config.Scheme() + "://" + shard + ":" + strconv.Itoa(port) + "/stats/get"
 }
 
 func (s *statServer) getStatsFromShard(w http.ResponseWriter, req *http.Request) {
@@ -29,12 +44,19 @@ func (s *statServer) getStatsFromShard(w http.ResponseWriter, req *http.Request)
 		return
 	}
 
-	resp, err := http.Get(getShardURL(shard, port))
+	shard_req, err := http.NewRequestWithContext(req.Context(), http.MethodGet, s.getShardURL(shard, port), nil)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		fmt.Fprintf(w, "Unexpected error while getting stats from shard %s: %v\n", shard, err)
 		return
 	}
+	resp, err := s.http_client.Do(shard_req)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "Unexpected error while getting stats from shard %s: %v\n", shard, err)
+		return
+	}
+	defer resp.Body.Close()
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
@@ -43,20 +65,92 @@ func (s *statServer) getStatsFromShard(w http.ResponseWriter, req *http.Request)
 		return
 	}
 
+	// stats of shards are in JSON
+	w.Header().Set("Content-Type", resp.Header.Get("Content-Type"))
+	w.WriteHeader(resp.StatusCode)
 	w.Write(body)
 }
 
 func main() {
-	log.Println("stat server is started")
+	common.InitLogging(common.StatServiceName)
+	slog.Info("stat server is started")
+	ctx, stop := common.ShutdownContext()
+	defer stop()
 	stat_server := &statServer{config: common.ReadConfig()}
 
 	if stat_server.config.Stat_port == 0 {
-		log.Fatalln("You must specify port for statistics service")
+		common.Fatal("You must specify port for statistics service")
+	}
+
+	certs, err := common.NewCertReloader(stat_server.config, common.StatServiceName)
+	if err != nil {
+		common.Fatal("Failed to load certificate", "error", err)
+	}
+	shutdown_tracing, err := common.InitTracing(stat_server.config, common.StatServiceName)
+	if err != nil {
+		common.Fatal("Failed to set up tracing", "error", err)
+	}
+	defer shutdown_tracing(context.Background())
+
+	metrics := common.NewMetrics(common.StatServiceName)
+	stat_server.http_client = metrics.InstrumentShardClient(common.NewHTTPClient(certs, 0))
+	stat_server.health_client = common.NewHTTPClient(certs, 0)
+	meta_credentials := insecure.NewCredentials()
+	if certs != nil {
+		meta_credentials = credentials.NewTLS(certs.ClientConfig())
+	}
+	stat_server.conn, err = grpc.Dial("dns:///meta_service:"+strconv.Itoa(stat_server.config.Meta_port), grpc.WithTransportCredentials(meta_credentials),
+		grpc.WithStatsHandler(otelgrpc.NewClientHandler()), grpc.WithUnaryInterceptor(common.RequestIDClientInterceptor))
+	if err != nil {
+		common.Fatal("Failed to dial meta service", "error", err)
+	}
+	defer stat_server.conn.Close()
+	stat_server.grpc_client = metapb.NewApiWithMetaServiceClient(stat_server.conn)
+
+	if stat_server.config.History.Interval_seconds > 0 {
+		history_config := stat_server.config.History
+		path := history_config.Path
+		if path == "" {
+			path = defaultHistoryPath
+		}
+		retention := defaultHistoryRetention
+		if history_config.Retention_days > 0 {
+			retention = time.Duration(history_config.Retention_days) * 24 * time.Hour
+		}
+		stat_server.history, err = openHistory(path, retention)
+		if err != nil {
+			common.Fatal("Failed to open history of usage", "error", err)
+		}
+		defer stat_server.history.close()
+		go stat_server.collectHistory(ctx, time.Duration(history_config.Interval_seconds)*time.Second)
 	}
 
 	r := mux.NewRouter()
 
+	// stats are requested by users, so ids are always generated
+	r.Use(common.LoggingMiddleware(true))
+	r.Use(common.TracingMiddleware)
+	r.Use(metrics.Middleware)
+	r.Handle(common.MetricsPath, metrics.Handler()).Methods("GET")
+	common.HandleHealth(r, stat_server.readinessChecks())
 	r.HandleFunc("/stat/shard/{shard}", stat_server.getStatsFromShard).Methods("GET")
+	r.HandleFunc("/stat/bucket/{bucket}", stat_server.getBucketUsage).Methods("GET")
+	r.HandleFunc("/stat/user/{user}", stat_server.getUserUsage).Methods("GET")
+	r.HandleFunc("/stat/cluster", stat_server.getClusterStats).Methods("GET")
+	r.HandleFunc("/stat/cluster/status", stat_server.getClusterStatus).Methods("GET")
+	r.HandleFunc("/stat/cluster/buckets/{bucket}", stat_server.getBucketStats).Methods("GET")
+	r.HandleFunc("/stat/cluster/objects/{bucket}/{file}", stat_server.getObjectStats).Methods("GET")
+	r.HandleFunc("/stat/history/shards", stat_server.getShardsHistory).Methods("GET")
+	r.HandleFunc("/stat/history/buckets/{bucket}", stat_server.getBucketHistory).Methods("GET")
+	r.HandleFunc("/stat/history/projection", stat_server.getProjection).Methods("GET")
 
-	http.ListenAndServe(":"+strconv.Itoa(stat_server.config.Stat_port), r)
+	// stats are read by users, so their certificates are not checked
+	err = common.ListenAndServe(ctx, ":"+strconv.Itoa(stat_server.config.Stat_port), r, stat_server.config.ShutdownTimeout(), certs)
+	if ctx.Err() == nil {
+		common.Fatal("Stat service failed", "error", err)
+	}
+	if err != nil {
+		slog.Error("Failed to drain requests", "error", err)
+	}
+	slog.Info("stat service is stopped")
 }
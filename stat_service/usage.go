@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	metapb "meta/proto"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// usageWithQuota formats used amount together with its quota, zero quota means unlimited
+func usageWithQuota(used, quota int64) string {
+	if quota == 0 {
+		return fmt.Sprintf("%d (no quota)", used)
+	}
+	return fmt.Sprintf("%d of %d (%.1f%%)", used, quota, float64(used)*100/float64(quota))
+}
+
+func writeMetaError(w http.ResponseWriter, err error) {
+	switch status.Code(err) {
+	case codes.NotFound:
+		w.WriteHeader(http.StatusNotFound)
+	case codes.Unavailable:
+		w.WriteHeader(http.StatusServiceUnavailable)
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	fmt.Fprintf(w, "Unexpected error while getting usage from meta service: %v\n", err)
+}
+
+func (s *statServer) getBucketUsage(w http.ResponseWriter, req *http.Request) {
+	bucket := mux.Vars(req)["bucket"]
+
+	resp, err := s.grpc_client.GetBucket(req.Context(), &metapb.GetBucketReq{Bucket: bucket})
+	if err != nil {
+		writeMetaError(w, err)
+		return
+	}
+
+	info := resp.Bucket
+	fmt.Fprintf(w, "Usage of bucket %s (owner %q):\n", info.Bucket, info.Owner)
+	fmt.Fprintf(w, "  Objects:      %s\n", usageWithQuota(info.Objects, info.QuotaObjects))
+	fmt.Fprintf(w, "  Bytes:        %s\n", usageWithQuota(info.Bytes, info.QuotaBytes))
+	fmt.Fprintf(w, "  Stored bytes: %d\n", info.StoredBytes)
+}
+
+func (s *statServer) getUserUsage(w http.ResponseWriter, req *http.Request) {
+	user := mux.Vars(req)["user"]
+
+	resp, err := s.grpc_client.GetUserUsage(req.Context(), &metapb.GetUserUsageReq{User: user})
+	if err != nil {
+		writeMetaError(w, err)
+		return
+	}
+
+	usage := resp.Usage
+	fmt.Fprintf(w, "Usage of user %s:\n", usage.User)
+	fmt.Fprintf(w, "  Buckets:      %d\n", usage.Buckets)
+	fmt.Fprintf(w, "  Objects:      %s\n", usageWithQuota(usage.Objects, usage.QuotaObjects))
+	fmt.Fprintf(w, "  Bytes:        %s\n", usageWithQuota(usage.Bytes, usage.QuotaBytes))
+	fmt.Fprintf(w, "  Stored bytes: %d\n", usage.StoredBytes)
+}
@@ -2,30 +2,36 @@ package main
 
 import (
 	"common"
+	"context"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"io/fs"
+	"log/slog"
 	"net/http"
 	"os"
+	"path/filepath"
 	"strconv"
-	"strings"
+	"syscall"
 
 	"github.com/gorilla/mux"
 )
 
 type shardServer struct {
-	config    common.Config
-	data_path string
-	name      string
+	config      common.Config
+	data_path   string
+	name        string
+	http_client *http.Client
+	chunks      *chunkAccounting
 }
 
 func (s *shardServer) writeData(w http.ResponseWriter, req *http.Request) {
 	filename := mux.Vars(req)["filename"]
 	path := s.data_path + filename
 
-	body := make([]byte, req.ContentLength)
-	_, err := req.Body.Read(body)
-	if err != nil && err != io.EOF {
+	// one Read may return only a part of the body
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		fmt.Fprintln(w, "Troubles with reading data from request")
 		return
@@ -37,6 +43,8 @@ func (s *shardServer) writeData(w http.ResponseWriter, req *http.Request) {
 		fmt.Fprintln(w, "Can't create file")
 		return
 	}
+	// chunk is counted after it's closed
+	defer s.chunks.refresh(filename)
 
 	defer fd.Close()
 	_, err = fd.Write(body)
@@ -68,70 +76,187 @@ func (s *shardServer) readData(w http.ResponseWriter, req *http.Request) {
 	w.Header().Add("Content-Type", "application/octet-stream")
 }
 
-func (s *shardServer) deleteData(w http.ResponseWriter, req *http.Request) {
+// copyData copies chunk which is stored on this shard (or on source_shard, if it's passed) into new chunk,
+// so data of copied files does not pass through API service
+func (s *shardServer) copyData(w http.ResponseWriter, req *http.Request) {
 	filename := mux.Vars(req)["filename"]
 	path := s.data_path + filename
+	source := req.URL.Query().Get("source")
+	source_shard := req.URL.Query().Get("source_shard")
 
-	err := os.Remove(path)
+	if source == "" || filepath.Base(source) != source {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(w, "Invalid name of source chunk")
+		return
+	}
+
+	var src io.ReadCloser
+	if source_shard == "" || source_shard == s.name {
+		fd, err := os.Open(s.data_path + source)
+		if errors.Is(err, fs.ErrNotExist) {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintln(w, "Source file does not exist")
+			return
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintln(w, "Can't open source file")
+			return
+		}
+		src = fd
+	} else {
+		source_port, ok := s.config.Shards[source_shard]
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "Unknown shard %s\n", source_shard)
+			return
+		}
+		source_req, err := http.NewRequestWithContext(req.Context(), http.MethodGet, s.config.GetStorageURL(source_shard, source_port, source), nil)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintf(w, "Can't read source file from shard %s: %v\n", source_shard, err)
+			return
+		}
+		resp, err := s.http_client.Do(source_req)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			fmt.Fprintf(w, "Can't read source file from shard %s: %v\n", source_shard, err)
+			return
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			w.WriteHeader(http.StatusBadGateway)
+			fmt.Fprintf(w, "Can't read source file from shard %s: %s\n", source_shard, resp.Status)
+			return
+		}
+		src = resp.Body
+	}
+	defer src.Close()
+
+	fd, err := os.Create(path)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintln(w, "Can't remove file")
+		fmt.Fprintln(w, "Can't create file")
+		return
+	}
+	// chunk is counted after it's closed
+	defer s.chunks.refresh(filename)
+	defer fd.Close()
+
+	_, err = io.Copy(fd, src)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintln(w, "Can't copy data into file")
 		return
 	}
 }
 
-func (s *shardServer) getStats(w http.ResponseWriter, req *http.Request) {
-	chunk_files, err := os.ReadDir(s.data_path)
+func (s *shardServer) deleteData(w http.ResponseWriter, req *http.Request) {
+	filename := mux.Vars(req)["filename"]
+	path := s.data_path + filename
+
+	err := os.Remove(path)
+	s.chunks.refresh(filename)
+	if errors.Is(err, fs.ErrNotExist) {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintln(w, "File does not exist")
+		return
+	}
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Received unexpected error while reading dir: %v\n", err)
+		fmt.Fprintln(w, "Can't remove file")
 		return
 	}
+}
 
-	fmt.Fprintf(w, "There are %d chunks in %s shard:\n", len(chunk_files), s.name)
-	longest_name_len := 0
-	for _, chunk_file := range chunk_files {
-		longest_name_len = max(longest_name_len, len(chunk_file.Name()))
+// checkDisk checks that chunks can be written into data directory. Probe file is not counted in stats,
+// as it's not written through handlers
+func (s *shardServer) checkDisk(ctx context.Context) error {
+	probe, err := os.CreateTemp(s.data_path, ".readyz-*")
+	if err != nil {
+		return err
 	}
+	defer os.Remove(probe.Name())
+	_, err = probe.Write([]byte("ok"))
+	close_err := probe.Close()
+	return errors.Join(err, close_err)
+}
 
-	fmt.Fprintf(w, "  Filename: %s Size:\n", strings.Repeat(" ", longest_name_len))
-	for _, chunk_file := range chunk_files {
-		file_info, err := chunk_file.Info()
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			fmt.Fprintf(w, "Received unexpected error while reading some file in dir: %v\n", err)
-			return
-		}
+func (s *shardServer) newRouter(metrics *common.Metrics) *mux.Router {
+	r := mux.NewRouter()
 
-		fmt.Fprintf(w, "> %s%s%d bytes\n", chunk_file.Name(), strings.Repeat(" ", longest_name_len+11-len(chunk_file.Name())), file_info.Size())
-	}
+	// requests of other services carry their ids
+	r.Use(common.LoggingMiddleware(false))
+	r.Use(common.TracingMiddleware)
+	r.Use(metrics.Middleware)
+	// they are registered before chunks, but names of chunks never clash with them anyway
+	r.Handle(common.MetricsPath, metrics.Handler()).Methods("GET")
+	common.HandleHealth(r, map[string]common.ReadinessCheck{"disk": s.checkDisk})
+	r.HandleFunc("/{filename}", s.counted(opWrite, s.writeData)).Methods("POST")
+	r.HandleFunc("/{filename}", s.counted(opRead, s.readData)).Methods("GET")
+	r.HandleFunc("/{filename}", s.counted(opDelete, s.deleteData)).Methods("DELETE")
+	r.HandleFunc("/stats/get", s.getStats).Methods("GET")
+	r.HandleFunc("/stats/chunks", s.listChunks).Methods("GET")
+	r.HandleFunc("/copy/{filename}", s.counted(opCopy, s.copyData)).Methods("POST")
+	return r
 }
 
 // pass shard name in command line argument and create new foler data_<shard_name>
 func main() {
-	if len(os.Args) != 2 {
-		log.Fatalln("fatal error: You must specify shard name")
-	}
-
 	shard_server := &shardServer{}
-	shard_server.name = os.Args[1]
-	log.Printf("storage service is started (shard %s)\n", shard_server.name)
+	if len(os.Args) == 2 {
+		shard_server.name = os.Args[1]
+	}
+	common.InitLogging(common.StorageServiceName, common.LabelShard, shard_server.name)
+	if shard_server.name == "" {
+		common.Fatal("fatal error: You must specify shard name")
+	}
+	slog.Info("storage service is started")
+	ctx, stop := common.ShutdownContext()
+	defer stop()
 	shard_server.config = common.ReadConfig()
 	port, ok := shard_server.config.Shards[shard_server.name]
 	if !ok {
-		log.Fatalf("fatal error: unknown shard name: %s\n", shard_server.name)
+		common.Fatal("fatal error: unknown shard name")
+	}
+
+	certs, err := common.NewCertReloader(shard_server.config, shard_server.name)
+	if err != nil {
+		common.Fatal("fatal error: failed to load certificate", "error", err)
+	}
+	shutdown_tracing, err := common.InitTracing(shard_server.config, common.StorageServiceName)
+	if err != nil {
+		common.Fatal("fatal error: failed to set up tracing", "error", err)
+	}
+	defer shutdown_tracing(context.Background())
+
+	metrics := common.NewMetrics(common.StorageServiceName)
+	shard_server.http_client = metrics.InstrumentShardClient(common.NewHTTPClient(certs, 0))
+	// shards are used by api service, meta service deletes and copies chunks, stat service reads stats,
+	// other shards read chunks which are copied and Prometheus reads metrics
+	clients := []string{common.ApiServiceName, common.MetaServiceName, common.StatServiceName, common.MetricsScraperName}
+	for shard := range shard_server.config.Shards {
+		clients = append(clients, shard)
 	}
 
 	// it's ok if there is existing data directory
 	shard_server.data_path = "./data_" + shard_server.name + "/"
 	os.Mkdir(shard_server.data_path, 0755)
+	shard_server.chunks, err = newChunkAccounting(shard_server.data_path)
+	if err != nil {
+		common.Fatal("fatal error: failed to count chunks", "error", err)
+	}
+	metrics.Register(newDiskUsageCollector(shard_server.name, shard_server.chunks))
 
-	r := mux.NewRouter()
-
-	r.HandleFunc("/{filename}", shard_server.writeData).Methods("POST")
-	r.HandleFunc("/{filename}", shard_server.readData).Methods("GET")
-	r.HandleFunc("/{filename}", shard_server.deleteData).Methods("DELETE")
-	r.HandleFunc("/stats/get", shard_server.getStats).Methods("GET")
-
-	http.ListenAndServe(":"+strconv.Itoa(port), r)
+	r := shard_server.newRouter(metrics)
+	err = common.ListenAndServe(ctx, ":"+strconv.Itoa(port), r, shard_server.config.ShutdownTimeout(), certs, clients...)
+	if ctx.Err() == nil {
+		common.Fatal("fatal error: storage service failed", "error", err)
+	}
+	if err != nil {
+		slog.Error("failed to drain requests", "error", err)
+	}
+	// chunks which were written before shutdown reach the disk before the container is stopped
+	syscall.Sync()
+	slog.Info("storage service is stopped")
 }
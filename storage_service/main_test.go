@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"common"
+	"context"
+	"crypto/rand"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// testShard is a shard which serves on a free port till it gets SIGTERM
+type testShard struct {
+	server   *shardServer
+	base_url string
+	ctx      context.Context
+	served   chan error
+}
+
+func startTestShard(t *testing.T, drain_timeout time.Duration) *testShard {
+	data_path := t.TempDir() + "/"
+	chunks, err := newChunkAccounting(data_path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shard := &testShard{
+		server: &shardServer{name: "shard_test", data_path: data_path, http_client: http.DefaultClient, chunks: chunks},
+		served: make(chan error, 1),
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+	shard.base_url = "http://" + addr
+
+	ctx, stop := common.ShutdownContext()
+	t.Cleanup(stop)
+	shard.ctx = ctx
+	handler := shard.server.newRouter(common.NewMetrics(common.StorageServiceName))
+	go func() {
+		shard.served <- common.ListenAndServe(ctx, addr, handler, drain_timeout, nil)
+	}()
+
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		resp, err := http.Get(shard.base_url + common.HealthzPath)
+		if err == nil {
+			resp.Body.Close()
+			return shard
+		}
+		if time.Since(start) > 5*time.Second {
+			t.Fatalf("shard is not started: %v", err)
+		}
+	}
+}
+
+type uploadResult struct {
+	code int
+	err  error
+}
+
+// startUpload sends the first half of the chunk and returns writer of the rest of it
+func (s *testShard) startUpload(t *testing.T, chunk_name string, data []byte) (*io.PipeWriter, chan uploadResult) {
+	body, body_writer := io.Pipe()
+	result := make(chan uploadResult, 1)
+	go func() {
+		resp, err := http.Post(s.base_url+"/"+chunk_name, "application/octet-stream", body)
+		if err != nil {
+			result <- uploadResult{err: err}
+			return
+		}
+		resp.Body.Close()
+		result <- uploadResult{code: resp.StatusCode}
+	}()
+
+	_, err := body_writer.Write(data[:len(data)/2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the handler has to start reading the body before the signal
+	time.Sleep(100 * time.Millisecond)
+	return body_writer, result
+}
+
+// terminate sends SIGTERM to the test process, it's caught by ShutdownContext
+func (s *testShard) terminate(t *testing.T) time.Time {
+	err := syscall.Kill(os.Getpid(), syscall.SIGTERM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-s.ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("SIGTERM is not caught")
+	}
+	return time.Now()
+}
+
+// waitServed waits till ListenAndServe returns, it must return in drain timeout
+func (s *testShard) waitServed(t *testing.T, signalled time.Time, drain_timeout time.Duration) error {
+	select {
+	case err := <-s.served:
+		if elapsed := time.Since(signalled); elapsed > drain_timeout+time.Second {
+			t.Errorf("server stopped in %s, drain timeout is %s", elapsed, drain_timeout)
+		}
+		return err
+	case <-time.After(drain_timeout + 5*time.Second):
+		t.Fatal("server is not stopped")
+		return nil
+	}
+}
+
+func randomChunk(t *testing.T) []byte {
+	data := make([]byte, 256*1024)
+	_, err := rand.Read(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestShutdownDrainsUpload(t *testing.T) {
+	const drain_timeout = 5 * time.Second
+	shard := startTestShard(t, drain_timeout)
+	data := randomChunk(t)
+
+	body_writer, result := shard.startUpload(t, "chunk_0", data)
+	signalled := shard.terminate(t)
+
+	// new requests are not accepted while the upload is drained
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		resp, err := http.Get(shard.base_url + common.HealthzPath)
+		if err != nil {
+			break
+		}
+		resp.Body.Close()
+		if time.Since(start) > time.Second {
+			t.Fatal("shard accepts requests after SIGTERM")
+		}
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	_, err := body_writer.Write(data[len(data)/2:])
+	if err != nil {
+		t.Fatalf("rest of the chunk is not sent: %v", err)
+	}
+	body_writer.Close()
+
+	uploaded := <-result
+	if uploaded.err != nil || uploaded.code != http.StatusOK {
+		t.Fatalf("upload is not finished: code %d, error %v", uploaded.code, uploaded.err)
+	}
+	err = shard.waitServed(t, signalled, drain_timeout)
+	if err != nil {
+		t.Fatalf("upload is not drained: %v", err)
+	}
+
+	stored, err := os.ReadFile(shard.server.data_path + "chunk_0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(stored, data) {
+		t.Fatalf("stored chunk has %d bytes, differs from uploaded %d bytes", len(stored), len(data))
+	}
+	chunks, used_bytes := shard.server.chunks.usage()
+	if chunks != 1 || used_bytes != int64(len(data)) {
+		t.Fatalf("shard counts %d chunks of %d bytes, want 1 chunk of %d bytes", chunks, used_bytes, len(data))
+	}
+}
+
+func TestShutdownAbortsUploadAfterDrainTimeout(t *testing.T) {
+	const drain_timeout = 500 * time.Millisecond
+	shard := startTestShard(t, drain_timeout)
+	data := randomChunk(t)
+
+	body_writer, result := shard.startUpload(t, "chunk_0", data)
+	signalled := shard.terminate(t)
+
+	// the rest of the chunk is never sent
+	err := shard.waitServed(t, signalled, drain_timeout)
+	if err == nil {
+		t.Fatal("unfinished upload is reported as drained")
+	}
+	// client waits for its body even after the connection is closed
+	body_writer.CloseWithError(io.ErrUnexpectedEOF)
+	uploaded := <-result
+	if uploaded.err == nil && uploaded.code == http.StatusOK {
+		t.Fatal("unfinished upload succeeded")
+	}
+
+	// the handler finishes after its connection is closed
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		shard.server.chunks.mu.Lock()
+		writes := shard.server.chunks.ops[opWrite]
+		shard.server.chunks.mu.Unlock()
+		if writes == 1 {
+			break
+		}
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("handler of the upload is not finished")
+		}
+	}
+
+	chunk_files, err := os.ReadDir(shard.server.data_path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chunk_files) != 0 {
+		t.Fatalf("aborted upload left %d files, the first is %s", len(chunk_files), chunk_files[0].Name())
+	}
+	chunks, used_bytes := shard.server.chunks.usage()
+	if chunks != 0 || used_bytes != 0 {
+		t.Fatalf("shard counts %d chunks of %d bytes after aborted upload", chunks, used_bytes)
+	}
+}
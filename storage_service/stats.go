@@ -0,0 +1,252 @@
+package main
+
+import (
+	"common"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// operations with chunks which are counted by chunkAccounting
+const (
+	opWrite  = "write"
+	opRead   = "read"
+	opCopy   = "copy"
+	opDelete = "delete"
+)
+
+const (
+	defaultChunksPageSize = 1000
+	maxChunksPageSize     = 10000
+)
+
+// chunkAccounting keeps sizes of chunks in memory and updates them on every write and delete, so stats
+// don't read the whole data directory. It's rebuilt from the directory on start
+type chunkAccounting struct {
+	data_path string
+	started   time.Time
+
+	mu    sync.Mutex
+	sizes map[string]int64
+	// names of chunks in sizes, sorted for pages of listChunks
+	names  []string
+	bytes  int64
+	ops    map[string]int64
+	errors map[string]int64
+}
+
+func newChunkAccounting(data_path string) (*chunkAccounting, error) {
+	chunk_files, err := os.ReadDir(data_path)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &chunkAccounting{
+		data_path: data_path,
+		started:   time.Now().UTC(),
+		sizes:     make(map[string]int64, len(chunk_files)),
+		names:     make([]string, 0, len(chunk_files)),
+		ops:       make(map[string]int64),
+		errors:    make(map[string]int64),
+	}
+	for _, chunk_file := range chunk_files {
+		// probe of disk may be left if the shard was killed while checking it
+		if strings.HasPrefix(chunk_file.Name(), ".") {
+			continue
+		}
+		file_info, err := chunk_file.Info()
+		if err != nil {
+			// chunk is deleted while it's counted
+			continue
+		}
+		// ReadDir returns files sorted by names
+		a.sizes[chunk_file.Name()] = file_info.Size()
+		a.names = append(a.names, chunk_file.Name())
+		a.bytes += file_info.Size()
+	}
+	return a, nil
+}
+
+// refresh updates size of the chunk from its file after it's written or deleted, so the counters
+// stay right even if writing has failed halfway
+func (a *chunkAccounting) refresh(chunk string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.bytes -= a.sizes[chunk]
+	delete(a.sizes, chunk)
+	file_info, err := os.Stat(a.data_path + chunk)
+	if err == nil {
+		a.sizes[chunk] = file_info.Size()
+		a.bytes += file_info.Size()
+	}
+
+	pos, listed := slices.BinarySearch(a.names, chunk)
+	_, exists := a.sizes[chunk]
+	if exists && !listed {
+		a.names = slices.Insert(a.names, pos, chunk)
+	} else if !exists && listed {
+		a.names = slices.Delete(a.names, pos, pos+1)
+	}
+}
+
+func (a *chunkAccounting) count(op string, failed bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.ops[op]++
+	if failed {
+		a.errors[op]++
+	}
+}
+
+func (a *chunkAccounting) usage() (int64, int64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return int64(len(a.sizes)), a.bytes
+}
+
+// codeRecorder remembers status of response, so failed operations are counted
+type codeRecorder struct {
+	http.ResponseWriter
+	code int
+}
+
+func (w *codeRecorder) WriteHeader(code int) {
+	w.code = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// counted counts calls of handler of the operation, responses with 5xx status are counted as errors
+func (s *shardServer) counted(op string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		recorder := &codeRecorder{ResponseWriter: w, code: http.StatusOK}
+		handler(recorder, req)
+		s.chunks.count(op, recorder.code >= http.StatusInternalServerError)
+	}
+}
+
+// shardStats are counters of the shard. Counters of operations are reset on restart
+type shardStats struct {
+	Shard      string           `json:"shard"`
+	Chunks     int64            `json:"chunks"`
+	UsedBytes  int64            `json:"used_bytes"`
+	FreeBytes  int64            `json:"free_bytes"`
+	TotalBytes int64            `json:"total_bytes"`
+	Since      time.Time        `json:"since"`
+	Ops        map[string]int64 `json:"ops"`
+	Errors     map[string]int64 `json:"errors"`
+}
+
+func (s *shardServer) getStats(w http.ResponseWriter, req *http.Request) {
+	// space of the disk which data directory is on, it may be shared with other shards
+	var disk syscall.Statfs_t
+	err := syscall.Statfs(s.data_path, &disk)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "Received unexpected error while reading free space of disk: %v\n", err)
+		return
+	}
+
+	stats := shardStats{
+		Shard:      s.name,
+		FreeBytes:  int64(disk.Bavail) * int64(disk.Bsize),
+		TotalBytes: int64(disk.Blocks) * int64(disk.Bsize),
+		Since:      s.chunks.started,
+		Ops:        make(map[string]int64),
+		Errors:     make(map[string]int64),
+	}
+	s.chunks.mu.Lock()
+	stats.Chunks = int64(len(s.chunks.sizes))
+	stats.UsedBytes = s.chunks.bytes
+	for _, op := range []string{opWrite, opRead, opCopy, opDelete} {
+		stats.Ops[op] = s.chunks.ops[op]
+		stats.Errors[op] = s.chunks.errors[op]
+	}
+	s.chunks.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
+
+type chunkInfo struct {
+	Chunk string `json:"chunk"`
+	Size  int64  `json:"size"`
+}
+
+type chunksPage struct {
+	Chunks []chunkInfo `json:"chunks"`
+	// name of the last chunk of the page which is passed in after to get the next page,
+	// it's empty on the last page
+	Next string `json:"next,omitempty"`
+}
+
+// listChunks lists chunks sorted by names, page starts after the chunk which is passed in after
+// query and has at most limit chunks. Chunks which are written while pages are read may be missed
+func (s *shardServer) listChunks(w http.ResponseWriter, req *http.Request) {
+	after := req.URL.Query().Get("after")
+	limit := defaultChunksPageSize
+	if value := req.URL.Query().Get("limit"); value != "" {
+		var err error
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit <= 0 || limit > maxChunksPageSize {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "Limit must be between 1 and %d\n", maxChunksPageSize)
+			return
+		}
+	}
+
+	s.chunks.mu.Lock()
+	start, found := slices.BinarySearch(s.chunks.names, after)
+	if found {
+		start++
+	}
+	names := s.chunks.names[start:]
+	page := chunksPage{Chunks: make([]chunkInfo, 0, min(limit, len(names)))}
+	for _, chunk := range names[:min(limit, len(names))] {
+		page.Chunks = append(page.Chunks, chunkInfo{Chunk: chunk, Size: s.chunks.sizes[chunk]})
+	}
+	if len(names) > limit {
+		page.Next = names[limit-1]
+	}
+	s.chunks.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+// diskUsageCollector reports chunks which are stored on the shard by its counters
+type diskUsageCollector struct {
+	chunks      *chunkAccounting
+	chunks_desc *prometheus.Desc
+	bytes_desc  *prometheus.Desc
+}
+
+func newDiskUsageCollector(shard string, chunks *chunkAccounting) *diskUsageCollector {
+	labels := prometheus.Labels{common.LabelShard: shard}
+	return &diskUsageCollector{
+		chunks:      chunks,
+		chunks_desc: prometheus.NewDesc("storage_chunks", "Number of chunks stored on the shard.", nil, labels),
+		bytes_desc:  prometheus.NewDesc("storage_used_bytes", "Bytes of chunks stored on the shard.", nil, labels),
+	}
+}
+
+func (c *diskUsageCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.chunks_desc
+	ch <- c.bytes_desc
+}
+
+func (c *diskUsageCollector) Collect(ch chan<- prometheus.Metric) {
+	chunks, used_bytes := c.chunks.usage()
+	ch <- prometheus.MustNewConstMetric(c.chunks_desc, prometheus.GaugeValue, float64(chunks))
+	ch <- prometheus.MustNewConstMetric(c.bytes_desc, prometheus.GaugeValue, float64(used_bytes))
+}